@@ -0,0 +1,139 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerov2alpha1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v2alpha1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+// getRetriedDataUpload returns the latest DataUpload of the PVC owned by the backup named retryOf, or nil when there is none.
+func getRetriedDataUpload(ctx context.Context, backup *velerov1api.Backup, veleroClient veleroClientSet.Interface,
+	retryOf string, pvc *corev1api.PersistentVolumeClaim) (*velerov2alpha1.DataUpload, error) {
+	listOptions := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", velerov1api.PVCUIDLabel, pvc.UID)}
+	dataUploadList, err := veleroClient.VeleroV2alpha1().DataUploads(backup.Namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error to list DataUpload of PVC %s/%s", pvc.Namespace, pvc.Name)
+	}
+
+	var latest *velerov2alpha1.DataUpload
+	for i := range dataUploadList.Items {
+		dataUpload := &dataUploadList.Items[i]
+		if !isOwnedByBackup(dataUpload, retryOf) {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&dataUpload.CreationTimestamp) {
+			latest = dataUpload
+		}
+	}
+	return latest, nil
+}
+
+func isOwnedByBackup(dataUpload *velerov2alpha1.DataUpload, backupName string) bool {
+	for _, owner := range dataUpload.OwnerReferences {
+		if owner.Kind == "Backup" && owner.Name == backupName {
+			return true
+		}
+	}
+	return false
+}
+
+// isDataUploadReusable returns whether the retry can take the result of a DataUpload of the backup it retries rather
+// than uploading the data again. The DataUpload must have completed to the backup storage location of the retry.
+func isDataUploadReusable(dataUpload *velerov2alpha1.DataUpload, backup *velerov1api.Backup) bool {
+	return dataUpload.Status.Phase == velerov2alpha1.DataUploadPhaseCompleted &&
+		dataUpload.Spec.BackupStorageLocation == backup.Spec.StorageLocation &&
+		dataUpload.Labels[velerov1api.AsyncOperationIDLabel] != ""
+}
+
+// shareDataUpload adds the backup to the owners of the completed DataUpload of the backup it retries, so the DataUpload
+// and its result are kept while either backup exists.
+func shareDataUpload(ctx context.Context, backup *velerov1api.Backup, veleroClient veleroClientSet.Interface,
+	dataUpload *velerov2alpha1.DataUpload) (*velerov2alpha1.DataUpload, error) {
+	if isOwnedByBackup(dataUpload, backup.Name) {
+		return dataUpload, nil
+	}
+
+	owners := append(append([]metav1.OwnerReference{}, dataUpload.OwnerReferences...), metav1.OwnerReference{
+		APIVersion: velerov1api.SchemeGroupVersion.String(),
+		Kind:       "Backup",
+		Name:       backup.Name,
+		UID:        backup.UID,
+	})
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": owners,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling DataUpload patch")
+	}
+
+	shared, err := veleroClient.VeleroV2alpha1().DataUploads(dataUpload.Namespace).Patch(ctx, dataUpload.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to add backup %s to the owners of DataUpload %s/%s", backup.Name, dataUpload.Namespace, dataUpload.Name)
+	}
+	return shared, nil
+}
+
+// isDataUploadResubmittable returns whether a new DataUpload can be submitted for the volumesnapshot of a DataUpload
+// of the backup it retries. The DataUpload must have failed or been canceled, so the data mover no longer uses the
+// volumesnapshot. A completed DataUpload is reused instead, and a running one is left to the retried backup.
+func isDataUploadResubmittable(dataUpload *velerov2alpha1.DataUpload) bool {
+	return dataUpload.Spec.CSISnapshot != nil && dataUpload.Spec.CSISnapshot.VolumeSnapshot != "" &&
+		(dataUpload.Status.Phase == velerov2alpha1.DataUploadPhaseFailed ||
+			dataUpload.Status.Phase == velerov2alpha1.DataUploadPhaseCanceled)
+}
+
+// getResubmittableVolumeSnapshot returns the volumesnapshot of a DataUpload of the retried backup after labeling it with
+// the backup, or nil when it no longer exists or can't be used for the PVC anymore.
+func getResubmittableVolumeSnapshot(ctx context.Context, backup *velerov1api.Backup, dataUpload *velerov2alpha1.DataUpload,
+	pvc *corev1api.PersistentVolumeClaim, snapshotClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshot, error) {
+	vs, err := snapshotClient.VolumeSnapshots(pvc.Namespace).Get(ctx, dataUpload.Spec.CSISnapshot.VolumeSnapshot, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "fail to get volumesnapshot %s/%s", pvc.Namespace, dataUpload.Spec.CSISnapshot.VolumeSnapshot)
+	}
+	if vs.DeletionTimestamp != nil || util.IsSnapshotCanceled(&vs.ObjectMeta) ||
+		vs.Spec.Source.PersistentVolumeClaimName == nil || *vs.Spec.Source.PersistentVolumeClaimName != pvc.Name {
+		return nil, nil
+	}
+
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{"%s":"%s"}}}`, velerov1api.BackupNameLabel, label.GetValidName(backup.Name)))
+	vs, err = snapshotClient.VolumeSnapshots(vs.Namespace).Patch(ctx, vs.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to label volumesnapshot %s/%s", pvc.Namespace, dataUpload.Spec.CSISnapshot.VolumeSnapshot)
+	}
+	return vs, nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+)
+
+// ExternalSnapshotPolicy decides how VolumeSnapshotBackupItemAction backs up a VolumeSnapshot created outside Velero.
+// Velero doesn't wait for such a volumesnapshot, so by default it is backed up without its volumesnapshotcontent
+// when the snapshot controller hasn't bound it yet.
+type ExternalSnapshotPolicy string
+
+const (
+	// ExternalSnapshotPolicyBestEffort backs up the volumesnapshot as it is.
+	ExternalSnapshotPolicyBestEffort ExternalSnapshotPolicy = "best-effort"
+	// ExternalSnapshotPolicyWarn waits for the volumesnapshot to be ready to use, and backs it up
+	// with a warning when it isn't.
+	ExternalSnapshotPolicyWarn ExternalSnapshotPolicy = "warn"
+	// ExternalSnapshotPolicyFail waits for the volumesnapshot to be ready to use, and fails the backup
+	// of the volumesnapshot when it isn't.
+	ExternalSnapshotPolicyFail ExternalSnapshotPolicy = "fail"
+)
+
+// externalSnapshotInterval is the polling interval of the wait for a volumesnapshot created outside Velero.
+var externalSnapshotInterval = 5 * time.Second
+
+// getExternalSnapshotPolicy returns the policy for a volumesnapshot created outside Velero. The volumesnapshot
+// annotation takes precedence over the backup annotation.
+func getExternalSnapshotPolicy(vs *snapshotv1api.VolumeSnapshot, backup *velerov1api.Backup, log logrus.FieldLogger) ExternalSnapshotPolicy {
+	value, ok := vs.Annotations[util.ExternalSnapshotPolicyAnnotation]
+	if !ok {
+		value = backup.Annotations[util.ExternalSnapshotPolicyAnnotation]
+	}
+
+	switch policy := ExternalSnapshotPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "", ExternalSnapshotPolicyBestEffort:
+		return ExternalSnapshotPolicyBestEffort
+	case ExternalSnapshotPolicyWarn, ExternalSnapshotPolicyFail:
+		return policy
+	default:
+		log.Warnf("Unknown external snapshot policy %s. Fall back to %s.", value, ExternalSnapshotPolicyBestEffort)
+		return ExternalSnapshotPolicyBestEffort
+	}
+}
+
+// waitForExternalSnapshot waits until the volumesnapshot is bound to a volumesnapshotcontent with a snapshot handle
+// and is ready to use, for at most timeout. It returns the volumesnapshotcontent bound to the volumesnapshot, if any,
+// and an error telling why the snapshot isn't usable when the wait doesn't succeed.
+func waitForExternalSnapshot(ctx context.Context, volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface,
+	timeout time.Duration, log logrus.FieldLogger) (*snapshotv1api.VolumeSnapshotContent, error) {
+	var vsc *snapshotv1api.VolumeSnapshotContent
+	reason := ""
+	err := wait.PollImmediateWithContext(ctx, externalSnapshotInterval, timeout, func(ctx context.Context) (bool, error) {
+		vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(ctx, volSnap.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to get volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name)
+		}
+		if vs.Status == nil || vs.Status.BoundVolumeSnapshotContentName == nil {
+			reason = "is not bound to a volumesnapshotcontent"
+			log.Infof("Waiting for volumesnapshot %s/%s created outside Velero to be bound", vs.Namespace, vs.Name)
+			return false, nil
+		}
+
+		vsc, err = snapshotClient.VolumeSnapshotContents().Get(ctx, *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+		if err != nil {
+			vsc = nil
+			if apierrors.IsNotFound(err) {
+				reason = fmt.Sprintf("is bound to volumesnapshotcontent %s, which doesn't exist", *vs.Status.BoundVolumeSnapshotContentName)
+				return false, nil
+			}
+			return false, errors.Wrapf(err, "failed to get volumesnapshotcontent %s", *vs.Status.BoundVolumeSnapshotContentName)
+		}
+		if vsc.Status == nil || vsc.Status.SnapshotHandle == nil {
+			reason = fmt.Sprintf("is bound to volumesnapshotcontent %s without a snapshot handle", vsc.Name)
+			return false, nil
+		}
+
+		if state := util.GetVolumeSnapshotState(vs); !state.ReadyToUse {
+			reason = "is not ready to use"
+			if state.HasError {
+				reason = fmt.Sprintf("is not ready to use: %s", state.Error)
+			}
+			log.Infof("Waiting for volumesnapshot %s/%s created outside Velero to be ready to use", vs.Namespace, vs.Name)
+			return false, nil
+		}
+		return true, nil
+	})
+
+	if err == nil {
+		return vsc, nil
+	}
+	if ctx.Err() != nil {
+		return vsc, errors.Wrapf(ctx.Err(), "stopped waiting for volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name)
+	}
+	if err == wait.ErrWaitTimeout {
+		return vsc, errors.Errorf("volumesnapshot %s/%s %s after %s", volSnap.Namespace, volSnap.Name, reason, timeout)
+	}
+	return vsc, err
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
@@ -27,6 +28,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -34,7 +37,9 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/record"
 
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/metrics"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	velerov2alpha1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v2alpha1"
@@ -52,6 +57,9 @@ type PVCBackupItemAction struct {
 	Client         kubernetes.Interface
 	SnapshotClient snapshotterClientSet.Interface
 	VeleroClient   veleroClientSet.Interface
+	EventRecorder  record.EventRecorder
+	// Cache serves StorageClasses, VolumeSnapshotClasses and Pods. They are read from the API server when it is nil.
+	Cache *util.ResourceCache
 }
 
 // AppliesTo returns information indicating that the PVCBackupItemAction should be invoked to backup PVCs.
@@ -67,6 +75,12 @@ func (p *PVCBackupItemAction) AppliesTo() (velero.ResourceSelector, error) {
 // underlying PVs by creating volumesnapshot CSI API objects that will trigger the CSI driver to perform the snapshot operation on the volume.
 func (p *PVCBackupItemAction) Execute(item runtime.Unstructured, backup *velerov1api.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, string, []velero.ResourceIdentifier, error) {
 	p.Log.Info("Starting PVCBackupItemAction")
+	defer metrics.Export(p.Log)
+
+	ctx, cancel := util.NewBackupContext(backup, p.Log)
+	defer cancel()
+
+	util.SweepSnapshotsOnce(ctx, backup.Namespace, p.Client, p.SnapshotClient.SnapshotV1(), p.VeleroClient, p.Log)
 
 	// Do nothing if volume snapshots have not been requested in this backup
 	if boolptr.IsSetToFalse(backup.Spec.SnapshotVolumes) {
@@ -92,13 +106,20 @@ func (p *PVCBackupItemAction) Execute(item runtime.Unstructured, backup *velerov
 
 	p.Log.Debugf("Fetching underlying PV for PVC %s", fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name))
 	// Do nothing if this is not a CSI provisioned volume
-	pv, err := util.GetPVForPVC(&pvc, p.Client.CoreV1())
+	pv, err := util.GetPVForPVC(ctx, &pvc, p.Client.CoreV1())
 	if err != nil {
 		return nil, nil, "", nil, errors.WithStack(err)
 	}
 	if pv.Spec.PersistentVolumeSource.CSI == nil {
 		p.Log.Infof("Skipping PVC %s/%s, associated PV %s is not a CSI volume", pvc.Namespace, pvc.Name, pv.Name)
 
+		updateVolumeReport(ctx, p.Client, backup, pvc.Namespace, pvc.Name, func(report *util.VolumeReport) {
+			report.PV = pv.Name
+			report.SkipReason = "PV is not a CSI volume"
+		}, p.Log)
+		util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeNormal, util.EventReasonSnapshotSkipped,
+			"Skipped CSI snapshot, PV %s is not a CSI volume", pv.Name)
+
 		util.AddAnnotations(&pvc.ObjectMeta, map[string]string{
 			util.SkippedNoCSIPVAnnotation: "true",
 		})
@@ -107,12 +128,19 @@ func (p *PVCBackupItemAction) Execute(item runtime.Unstructured, backup *velerov
 	}
 
 	// Do nothing if FS uploader is used to backup this PV
-	isFSUploaderUsed, err := util.IsPVCDefaultToFSBackup(pvc.Namespace, pvc.Name, p.Client.CoreV1(), boolptr.IsSetToTrue(backup.Spec.DefaultVolumesToFsBackup))
+	isFSUploaderUsed, err := p.isPVCDefaultToFSBackup(ctx, &pvc, boolptr.IsSetToTrue(backup.Spec.DefaultVolumesToFsBackup))
 	if err != nil {
 		return nil, nil, "", nil, errors.WithStack(err)
 	}
 	if isFSUploaderUsed {
 		p.Log.Infof("Skipping  PVC %s/%s, PV %s will be backed up using FS uploader", pvc.Namespace, pvc.Name, pv.Name)
+		updateVolumeReport(ctx, p.Client, backup, pvc.Namespace, pvc.Name, func(report *util.VolumeReport) {
+			report.PV = pv.Name
+			report.Driver = pv.Spec.CSI.Driver
+			report.SkipReason = "PV is backed up by the FS uploader"
+		}, p.Log)
+		util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeNormal, util.EventReasonSnapshotSkipped,
+			"Skipped CSI snapshot, PV %s is backed up by the FS uploader", pv.Name)
 		return item, nil, "", nil, nil
 	}
 
@@ -122,43 +150,110 @@ func (p *PVCBackupItemAction) Execute(item runtime.Unstructured, backup *velerov
 	}
 
 	p.Log.Infof("Fetching storage class for PV %s", *pvc.Spec.StorageClassName)
-	storageClass, err := p.Client.StorageV1().StorageClasses().Get(context.TODO(), *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	storageClass, err := p.getStorageClass(ctx, *pvc.Spec.StorageClassName)
 	if err != nil {
 		return nil, nil, "", nil, errors.Wrap(err, "error getting storage class")
 	}
 	p.Log.Debugf("Fetching volumesnapshot class for %s", storageClass.Provisioner)
-	snapshotClass, err := util.GetVolumeSnapshotClass(storageClass.Provisioner, backup, &pvc, p.Log, p.SnapshotClient.SnapshotV1())
+	snapshotClass, snapshotClassSource, err := p.getVolumeSnapshotClass(ctx, storageClass.Provisioner, backup, &pvc)
 	if err != nil {
+		util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeWarning, util.EventReasonSnapshotFailed,
+			"Failed to get volumesnapshotclass for storageclass %s: %s", storageClass.Name, err.Error())
 		return nil, nil, "", nil, errors.Wrapf(err, "failed to get volumesnapshotclass for storageclass %s", storageClass.Name)
 	}
-	p.Log.Infof("volumesnapshot class=%s", snapshotClass.Name)
+	p.Log.Infof("volumesnapshot class=%s, chosen by %s", snapshotClass.Name, snapshotClassSource)
 
-	vsLabels := map[string]string{}
-	for k, v := range pvc.ObjectMeta.Labels {
-		vsLabels[k] = v
+	// The data mover settings are checked before taking the snapshot, so an invalid one doesn't leave a snapshot behind.
+	dataMover := ""
+	var dataMoverConfig map[string]string
+	if boolptr.IsSetToTrue(backup.Spec.SnapshotMoveData) {
+		dataMover = util.GetDataMover(&pvc, backup)
+		if dataMoverConfig, err = util.GetDataMoverConfig(&pvc, backup); err != nil {
+			util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeWarning, util.EventReasonSnapshotFailed,
+				"Invalid data mover config: %s", err.Error())
+			return nil, nil, "", nil, err
+		}
 	}
-	vsLabels[velerov1api.BackupNameLabel] = label.GetValidName(backup.Name)
 
-	// Craft the snapshot object to be created
-	snapshot := snapshotv1api.VolumeSnapshot{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: "velero-" + pvc.Name + "-",
-			Namespace:    pvc.Namespace,
-			Labels:       vsLabels,
-		},
-		Spec: snapshotv1api.VolumeSnapshotSpec{
-			Source: snapshotv1api.VolumeSnapshotSource{
-				PersistentVolumeClaimName: &pvc.Name,
-			},
-			VolumeSnapshotClassName: &snapshotClass.Name,
-		},
+	// A retry of a previous backup reuses the completed data upload of the previous backup, or resubmits the failed
+	// one with its volumesnapshot when it still exists, rather than snapshotting the volume again.
+	var upd *snapshotv1api.VolumeSnapshot
+	retryOf := backup.Annotations[util.RetryOfBackupAnnotation]
+	if !boolptr.IsSetToTrue(backup.Spec.SnapshotMoveData) || retryOf == backup.Name {
+		retryOf = ""
+	}
+	if retryOf != "" {
+		retried, err := getRetriedDataUpload(ctx, backup, p.VeleroClient, retryOf, &pvc)
+		switch {
+		case err != nil:
+			p.Log.WithError(err).Warnf("Fail to get the DataUpload of PVC %s/%s in backup %s, taking a new snapshot", pvc.Namespace, pvc.Name, retryOf)
+		case retried == nil:
+			p.Log.Infof("No DataUpload of PVC %s/%s in backup %s, taking a new snapshot", pvc.Namespace, pvc.Name, retryOf)
+		case isDataUploadReusable(retried, backup):
+			shared, err := shareDataUpload(ctx, backup, p.VeleroClient, retried)
+			if err == nil {
+				return p.reuseDataUpload(ctx, backup, &pvc, pv, shared, retryOf)
+			}
+			p.Log.WithError(err).Warnf("Fail to reuse DataUpload %s/%s, taking a new snapshot", retried.Namespace, retried.Name)
+		case isDataUploadResubmittable(retried):
+			if upd, err = getResubmittableVolumeSnapshot(ctx, backup, retried, &pvc, p.SnapshotClient.SnapshotV1()); err != nil {
+				p.Log.WithError(err).Warnf("Fail to get the volumesnapshot of DataUpload %s/%s, taking a new snapshot", retried.Namespace, retried.Name)
+			} else if upd == nil {
+				p.Log.Infof("Volumesnapshot of DataUpload %s/%s is gone, taking a new snapshot", retried.Namespace, retried.Name)
+			}
+		default:
+			p.Log.Infof("DataUpload %s/%s of backup %s is %s, taking a new snapshot", retried.Namespace, retried.Name, retryOf, retried.Status.Phase)
+		}
 	}
 
-	upd, err := p.SnapshotClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Create(context.TODO(), &snapshot, metav1.CreateOptions{})
-	if err != nil {
-		return nil, nil, "", nil, errors.Wrapf(err, "error creating volume snapshot")
-	}
-	p.Log.Infof("Created volumesnapshot %s", fmt.Sprintf("%s/%s", upd.Namespace, upd.Name))
+	if upd != nil {
+		p.Log.Infof("Resubmitting the data upload of volumesnapshot %s/%s of backup %s", upd.Namespace, upd.Name, retryOf)
+		util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeNormal, util.EventReasonDataUploadResubmitted,
+			"Resubmitting the data upload of volumesnapshot %s/%s of backup %s", upd.Namespace, upd.Name, retryOf)
+	} else {
+		retryOf = ""
+		vsLabels := map[string]string{}
+		for k, v := range pvc.ObjectMeta.Labels {
+			vsLabels[k] = v
+		}
+		vsLabels[velerov1api.BackupNameLabel] = label.GetValidName(backup.Name)
+
+		// Craft the snapshot object to be created
+		snapshot := snapshotv1api.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "velero-" + pvc.Name + "-",
+				Namespace:    pvc.Namespace,
+				Labels:       vsLabels,
+			},
+			Spec: snapshotv1api.VolumeSnapshotSpec{
+				Source: snapshotv1api.VolumeSnapshotSource{
+					PersistentVolumeClaimName: &pvc.Name,
+				},
+				VolumeSnapshotClassName: &snapshotClass.Name,
+			},
+		}
+
+		upd, err = p.SnapshotClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Create(ctx, &snapshot, metav1.CreateOptions{})
+		if err != nil {
+			metrics.RecordSnapshotFailure(storageClass.Provisioner, metrics.FailureReasonCreate)
+			util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeWarning, util.EventReasonSnapshotFailed,
+				"Failed to create volumesnapshot: %s", err.Error())
+			return nil, nil, "", nil, errors.Wrapf(err, "error creating volume snapshot")
+		}
+		p.Log.Infof("Created volumesnapshot %s", fmt.Sprintf("%s/%s", upd.Namespace, upd.Name))
+		util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeNormal, util.EventReasonSnapshotCreated,
+			"Created volumesnapshot %s/%s with volumesnapshotclass %s", upd.Namespace, upd.Name, snapshotClass.Name)
+	}
+	updateVolumeReport(ctx, p.Client, backup, pvc.Namespace, pvc.Name, func(report *util.VolumeReport) {
+		report.PV = pv.Name
+		report.Driver = pv.Spec.CSI.Driver
+		report.VolumeSnapshotClass = snapshotClass.Name
+		report.VolumeSnapshotClassSource = snapshotClassSource
+		report.VolumeSnapshot = upd.Namespace + "/" + upd.Name
+		report.SnapshotCreated = &upd.CreationTimestamp
+		report.RetryOf = retryOf
+		report.SkipReason = ""
+	}, p.Log)
 
 	labels := map[string]string{
 		util.VolumeSnapshotLabel:    upd.Name,
@@ -185,36 +280,94 @@ func (p *PVCBackupItemAction) Execute(item runtime.Unstructured, backup *velerov
 
 		// Wait until VS associated VSC snapshot handle created before returning with
 		// the Async operation for data mover.
-		_, err := util.GetVolumeSnapshotContentForVolumeSnapshot(upd, p.SnapshotClient.SnapshotV1(),
+		waitStart := time.Now()
+		vsc, err := util.GetVolumeSnapshotContentForVolumeSnapshot(ctx, upd, p.SnapshotClient.SnapshotV1(),
 			dataUploadLog, true, backup.Spec.CSISnapshotTimeout.Duration)
 		if err != nil {
 			dataUploadLog.Errorf("Fail to wait VolumeSnapshot snapshot handle created: %s", err.Error())
-			util.CleanupVolumeSnapshot(upd, p.SnapshotClient.SnapshotV1(), p.Log)
+			metrics.RecordSnapshotFailure(storageClass.Provisioner, metrics.FailureReasonWaitContent)
+			util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeWarning, util.EventReasonSnapshotFailed,
+				"Failed to wait for the snapshot handle of volumesnapshot %s/%s: %s", upd.Namespace, upd.Name, err.Error())
+			util.CleanupVolumeSnapshot(ctx, upd, p.SnapshotClient.SnapshotV1(), p.Log)
 			return nil, nil, "", nil, errors.WithStack(err)
 		}
 
+		metrics.ObserveSnapshotHandle(storageClass.Provisioner, time.Since(waitStart))
 		dataUploadLog.Info("Starting data upload of backup")
 
-		dataUpload, err := createDataUpload(context.Background(), backup, p.VeleroClient, upd, &pvc, operationID)
+		// The data mover pod mounting the snapshot volume must run where the snapshot is accessible,
+		// which is where the source volume is for zonal storage.
+		nodeAffinity := util.GetPVNodeAffinity(pv)
+		if nodeAffinity == nil {
+			nodeAffinity = util.GetStorageClassNodeAffinity(storageClass)
+		}
+		if nodeAffinity != nil && util.IsBuiltInDataMover(dataMover) {
+			dataUploadLog.Warn("Velero's built-in data mover ignores the node affinity of the volume, its pods are placed by the scheduler")
+			nodeAffinity = nil
+		}
+
+		// In hybrid mode the storage snapshot outlives the data mover's volumesnapshot, so it is kept before the data
+		// mover starts with it.
+		var localVSC *snapshotv1api.VolumeSnapshotContent
+		if util.IsKeepLocalSnapshot(&pvc, backup) {
+			if localVSC, err = util.KeepLocalSnapshot(ctx, vsc, &pvc, backup, p.SnapshotClient.SnapshotV1()); err != nil {
+				dataUploadLog.WithError(err).Error("Fail to keep the local snapshot")
+				util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeWarning, util.EventReasonSnapshotFailed,
+					"Failed to keep the local snapshot: %s", err.Error())
+				util.CleanupVolumeSnapshot(ctx, upd, p.SnapshotClient.SnapshotV1(), p.Log)
+				return nil, nil, "", nil, errors.WithStack(err)
+			}
+			dataUploadLog.Infof("Keeping the local snapshot with volumesnapshotcontent %s", localVSC.Name)
+		}
+
+		dataUpload, err := createDataUpload(ctx, backup, p.VeleroClient, upd, &pvc, operationID, dataMover, dataMoverConfig, nodeAffinity)
 		if err != nil {
 			dataUploadLog.WithError(err).Error("failed to submit DataUpload")
-			util.DeleteVolumeSnapshotIfAny(context.Background(), p.SnapshotClient, *upd, dataUploadLog)
+			metrics.RecordSnapshotFailure(storageClass.Provisioner, metrics.FailureReasonDataUpload)
+			util.RecordPVCEventf(p.EventRecorder, &pvc, backup, corev1api.EventTypeWarning, util.EventReasonSnapshotFailed,
+				"Failed to create DataUpload: %s", err.Error())
+			if localVSC != nil {
+				if err := util.ReleaseLocalSnapshot(ctx, vsc, localVSC, p.SnapshotClient.SnapshotV1()); err != nil {
+					dataUploadLog.WithError(err).Warn("Fail to release the local snapshot")
+				}
+			}
+			util.DeleteVolumeSnapshotIfAny(ctx, p.SnapshotClient, *upd, dataUploadLog)
 
 			return nil, nil, "", nil, errors.Wrapf(err, "error creating DataUpload")
 		} else {
-			itemToUpdate = []velero.ResourceIdentifier{
-				{
-					GroupResource: schema.GroupResource{
-						Group:    "velero.io",
-						Resource: "datauploads",
-					},
-					Namespace: dataUpload.Namespace,
-					Name:      dataUpload.Name,
-				},
-			}
+			itemToUpdate = []velero.ResourceIdentifier{dataUploadIdentifier(dataUpload)}
 			// Set the DataUploadNameLabel, which is used for restore to let CSI plugin check whether
 			// it should handle the volume. If volume is CSI migration, PVC doesn't have the annotation.
 			annotations[util.DataUploadNameAnnotation] = dataUpload.Namespace + "/" + dataUpload.Name
+			// Record the data mover, so the restore downloads the data with the same one.
+			if err := util.RecordDataMover(annotations, dataMover, dataMoverConfig); err != nil {
+				return nil, nil, "", nil, err
+			}
+			// Record the local snapshot, so the restore uses it rather than downloading the data when it still exists.
+			// Its volumesnapshotcontent is backed up to be removed with the backup.
+			if localVSC != nil {
+				annotations[util.LocalSnapshotContentAnnotation] = localVSC.Name
+				annotations[util.VolumeSnapshotHandleAnnotation] = *localVSC.Spec.Source.SnapshotHandle
+				annotations[util.CSIDriverNameAnnotation] = localVSC.Spec.Driver
+				if vsc.Status.RestoreSize != nil {
+					annotations[util.VolumeSnapshotRestoreSize] = resource.NewQuantity(*vsc.Status.RestoreSize, resource.BinarySI).String()
+				}
+				additionalItems = append(additionalItems, velero.ResourceIdentifier{
+					GroupResource: kuberesource.VolumeSnapshotContents,
+					Name:          localVSC.Name,
+				})
+			}
+
+			updateVolumeReport(ctx, p.Client, backup, pvc.Namespace, pvc.Name, func(report *util.VolumeReport) {
+				report.VolumeSnapshotContent = vsc.Name
+				if vsc.Status != nil && vsc.Status.SnapshotHandle != nil {
+					report.SnapshotHandle = *vsc.Status.SnapshotHandle
+				}
+				report.HandleDuration = time.Since(waitStart).Round(time.Second).String()
+				report.DataMoverOperationID = operationID
+				report.DataUpload = dataUpload.Namespace + "/" + dataUpload.Name
+				report.DataMover = dataMover
+			}, p.Log)
 
 			dataUploadLog.Info("DataUpload is submitted successfully.")
 		}
@@ -244,6 +397,90 @@ func (p *PVCBackupItemAction) Execute(item runtime.Unstructured, backup *velerov
 	return &unstructured.Unstructured{Object: pvcMap}, additionalItems, operationID, itemToUpdate, nil
 }
 
+// reuseDataUpload returns the PVC with the result of the completed DataUpload of the backup it retries, and the operation
+// ID of that DataUpload. No volumesnapshot is taken, and the DataUpload is backed up again with the retry.
+func (p *PVCBackupItemAction) reuseDataUpload(ctx context.Context, backup *velerov1api.Backup, pvc *corev1api.PersistentVolumeClaim,
+	pv *corev1api.PersistentVolume, dataUpload *velerov2alpha1.DataUpload, retryOf string) (runtime.Unstructured, []velero.ResourceIdentifier, string, []velero.ResourceIdentifier, error) {
+	operationID := dataUpload.Labels[velerov1api.AsyncOperationIDLabel]
+	vsName := dataUpload.Spec.CSISnapshot.VolumeSnapshot
+	p.Log.Infof("Reusing the completed DataUpload %s/%s of backup %s for PVC %s/%s", dataUpload.Namespace, dataUpload.Name, retryOf, pvc.Namespace, pvc.Name)
+	util.RecordPVCEventf(p.EventRecorder, pvc, backup, corev1api.EventTypeNormal, util.EventReasonDataUploadReused,
+		"Reusing the completed DataUpload %s/%s of backup %s", dataUpload.Namespace, dataUpload.Name, retryOf)
+
+	annotations := map[string]string{
+		util.VolumeSnapshotLabel:                 vsName,
+		util.MustIncludeAdditionalItemAnnotation: "true",
+		util.DataUploadNameAnnotation:            dataUpload.Namespace + "/" + dataUpload.Name,
+	}
+	// The restore downloads the data with the data mover that uploaded it. The node affinity of the upload was
+	// derived from the backed-up volume, and the restore derives its own.
+	dataMoverConfig := map[string]string{}
+	if dataUpload.Spec.DataMoverConfig != nil {
+		for k, v := range *dataUpload.Spec.DataMoverConfig {
+			if k != util.DataMoverNodeAffinityConfigKey && k != util.DataMoverNodeSelectorConfigKey {
+				dataMoverConfig[k] = v
+			}
+		}
+	}
+	if err := util.RecordDataMover(annotations, dataUpload.Spec.DataMover, dataMoverConfig); err != nil {
+		return nil, nil, "", nil, err
+	}
+	util.AddAnnotations(&pvc.ObjectMeta, annotations)
+	util.AddLabels(&pvc.ObjectMeta, map[string]string{
+		util.VolumeSnapshotLabel:    vsName,
+		velerov1api.BackupNameLabel: backup.Name,
+	})
+
+	updateVolumeReport(ctx, p.Client, backup, pvc.Namespace, pvc.Name, func(report *util.VolumeReport) {
+		report.PV = pv.Name
+		report.Driver = pv.Spec.CSI.Driver
+		report.VolumeSnapshot = pvc.Namespace + "/" + vsName
+		report.DataMoverOperationID = operationID
+		report.DataUpload = dataUpload.Namespace + "/" + dataUpload.Name
+		report.DataMover = dataUpload.Spec.DataMover
+		report.RetryOf = retryOf
+		report.SkipReason = ""
+	}, p.Log)
+
+	pvcMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pvc)
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+	return &unstructured.Unstructured{Object: pvcMap}, nil, operationID, []velero.ResourceIdentifier{dataUploadIdentifier(dataUpload)}, nil
+}
+
+func (p *PVCBackupItemAction) isPVCDefaultToFSBackup(ctx context.Context, pvc *corev1api.PersistentVolumeClaim, defaultVolumesToFsBackup bool) (bool, error) {
+	if p.Cache == nil {
+		return util.IsPVCDefaultToFSBackup(ctx, pvc.Namespace, pvc.Name, p.Client.CoreV1(), defaultVolumesToFsBackup)
+	}
+
+	pods, err := p.Cache.GetPodsUsingPVC(ctx, pvc.Namespace, pvc.Name, p.Log)
+	if err != nil {
+		return false, err
+	}
+	return util.IsPVCDefaultToFSBackupForPods(pvc.Name, pods, defaultVolumesToFsBackup)
+}
+
+func (p *PVCBackupItemAction) getStorageClass(ctx context.Context, name string) (*storagev1api.StorageClass, error) {
+	if p.Cache == nil {
+		return p.Client.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+	}
+	return p.Cache.GetStorageClass(ctx, name, p.Log)
+}
+
+func (p *PVCBackupItemAction) getVolumeSnapshotClass(ctx context.Context, provisioner string, backup *velerov1api.Backup,
+	pvc *corev1api.PersistentVolumeClaim) (*snapshotv1api.VolumeSnapshotClass, string, error) {
+	if p.Cache == nil {
+		return util.GetVolumeSnapshotClassWithSource(ctx, provisioner, backup, pvc, p.Log, p.SnapshotClient.SnapshotV1())
+	}
+
+	snapshotClasses, err := p.Cache.ListVolumeSnapshotClasses(ctx, p.Log)
+	if err != nil {
+		return nil, "", err
+	}
+	return util.ChooseVolumeSnapshotClass(provisioner, backup, pvc, p.Log, snapshotClasses)
+}
+
 func (p *PVCBackupItemAction) Name() string {
 	return "PVCBackupItemAction"
 }
@@ -254,7 +491,10 @@ func (p *PVCBackupItemAction) Progress(operationID string, backup *velerov1api.B
 		return progress, biav2.InvalidOperationIDError(operationID)
 	}
 
-	dataUpload, err := getDataUpload(context.Background(), backup, p.VeleroClient, operationID)
+	ctx, cancel := util.NewResourceContext(backup.Annotations, p.Log)
+	defer cancel()
+
+	dataUpload, err := getDataUpload(ctx, backup, p.VeleroClient, operationID)
 	if err != nil {
 		p.Log.Errorf("fail to get DataUpload for backup %s/%s: %s", backup.Namespace, backup.Name, err.Error())
 		return progress, err
@@ -295,17 +535,20 @@ func (p *PVCBackupItemAction) Cancel(operationID string, backup *velerov1api.Bac
 		return biav2.InvalidOperationIDError(operationID)
 	}
 
-	dataUpload, err := getDataUpload(context.Background(), backup, p.VeleroClient, operationID)
+	ctx, cancel := util.NewResourceContext(backup.Annotations, p.Log)
+	defer cancel()
+
+	dataUpload, err := getDataUpload(ctx, backup, p.VeleroClient, operationID)
 	if err != nil {
 		p.Log.Errorf("fail to get DataUpload for backup %s/%s: %s", backup.Namespace, backup.Name, err.Error())
 		return err
 	}
 
-	return cancelDataUpload(context.Background(), p.VeleroClient, dataUpload)
+	return cancelDataUpload(ctx, p.VeleroClient, dataUpload)
 }
 
 func newDataUpload(backup *velerov1api.Backup, vs *snapshotv1api.VolumeSnapshot,
-	pvc *corev1api.PersistentVolumeClaim, operationID string) *velerov2alpha1.DataUpload {
+	pvc *corev1api.PersistentVolumeClaim, operationID string, dataMover string, dataMoverConfig map[string]string) *velerov2alpha1.DataUpload {
 	dataUpload := &velerov2alpha1.DataUpload{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: velerov2alpha1.SchemeGroupVersion.String(),
@@ -337,19 +580,31 @@ func newDataUpload(backup *velerov1api.Backup, vs *snapshotv1api.VolumeSnapshot,
 				StorageClass:   *pvc.Spec.StorageClassName,
 			},
 			SourcePVC:             pvc.Name,
-			DataMover:             backup.Spec.DataMover,
+			DataMover:             dataMover,
 			BackupStorageLocation: backup.Spec.StorageLocation,
 			SourceNamespace:       pvc.Namespace,
 			OperationTimeout:      backup.Spec.CSISnapshotTimeout,
 		},
 	}
+	if len(dataMoverConfig) > 0 {
+		dataUpload.Spec.DataMoverConfig = &dataMoverConfig
+	}
 
 	return dataUpload
 }
 
 func createDataUpload(ctx context.Context, backup *velerov1api.Backup, veleroClient veleroClientSet.Interface,
-	vs *snapshotv1api.VolumeSnapshot, pvc *corev1api.PersistentVolumeClaim, operationID string) (*velerov2alpha1.DataUpload, error) {
-	dataUpload := newDataUpload(backup, vs, pvc, operationID)
+	vs *snapshotv1api.VolumeSnapshot, pvc *corev1api.PersistentVolumeClaim, operationID string, dataMover string,
+	dataMoverConfig map[string]string, nodeAffinity *corev1api.NodeSelector) (*velerov2alpha1.DataUpload, error) {
+	config := map[string]string{}
+	if err := util.SetDataMoverNodeAffinity(config, nodeAffinity); err != nil {
+		return nil, err
+	}
+	// The configuration set by the user wins over the one derived from the volume.
+	for k, v := range dataMoverConfig {
+		config[k] = v
+	}
+	dataUpload := newDataUpload(backup, vs, pvc, operationID, dataMover, config)
 
 	dataUpload, err := veleroClient.VeleroV2alpha1().DataUploads(dataUpload.Namespace).Create(ctx, dataUpload, metav1.CreateOptions{})
 	if err != nil {
@@ -359,11 +614,22 @@ func createDataUpload(ctx context.Context, backup *velerov1api.Backup, veleroCli
 	return dataUpload, err
 }
 
+func dataUploadIdentifier(dataUpload *velerov2alpha1.DataUpload) velero.ResourceIdentifier {
+	return velero.ResourceIdentifier{
+		GroupResource: schema.GroupResource{
+			Group:    "velero.io",
+			Resource: "datauploads",
+		},
+		Namespace: dataUpload.Namespace,
+		Name:      dataUpload.Name,
+	}
+}
+
 func getDataUpload(ctx context.Context, backup *velerov1api.Backup,
 	veleroClient veleroClientSet.Interface, operationID string) (*velerov2alpha1.DataUpload, error) {
 	listOptions := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", velerov1api.AsyncOperationIDLabel, operationID)}
 
-	dataUploadList, err := veleroClient.VeleroV2alpha1().DataUploads(backup.Namespace).List(context.Background(), listOptions)
+	dataUploadList, err := veleroClient.VeleroV2alpha1().DataUploads(backup.Namespace).List(ctx, listOptions)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error to list DataUpload")
 	}
@@ -34,6 +34,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	"github.com/vmware-tanzu/velero/pkg/apis/velero/shared"
@@ -161,6 +163,7 @@ func TestExecute(t *testing.T) {
 				Client:         client,
 				SnapshotClient: snapshotClient,
 				VeleroClient:   veleroClient,
+				EventRecorder:  record.NewFakeRecorder(10),
 			}
 
 			pvcMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&tc.pvc)
@@ -201,6 +204,17 @@ func TestExecute(t *testing.T) {
 				require.NoError(t, err)
 				require.Equal(t, 1, len(dataUploadList.Items))
 				require.Equal(t, *tc.expectedDataUpload, dataUploadList.Items[0])
+
+				reports, err := util.GetVolumeReports(context.Background(), client, tc.backup)
+				require.NoError(t, err)
+				report := reports[tc.pvc.Namespace+"/"+tc.pvc.Name]
+				require.Equal(t, "tescVSClass", report.VolumeSnapshotClass)
+				require.Equal(t, util.VolumeSnapshotClassSourceLabel, report.VolumeSnapshotClassSource)
+				require.Equal(t, "testHandle", report.SnapshotHandle)
+				require.Equal(t, "du-.", report.DataMoverOperationID)
+
+				events := pvcBIA.EventRecorder.(*record.FakeRecorder).Events
+				require.Equal(t, "Normal SnapshotCreated Created volumesnapshot velero/ with volumesnapshotclass tescVSClass", <-events)
 			}
 
 			if tc.expectedPVC != nil {
@@ -212,6 +226,148 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestExecuteRetry(t *testing.T) {
+	newRetriedDataUpload := func(phase velerov2alpha1.DataUploadPhase) *velerov2alpha1.DataUpload {
+		dataUpload := builder.ForDataUpload("velero", "prev-1").Phase(phase).SourceNamespace("ns").SourcePVC("testPVC").
+			CSISnapshot(&velerov2alpha1.CSISnapshotSpec{VolumeSnapshot: "vs-prev", StorageClass: "testSC"}).
+			DataMoverConfig(&map[string]string{util.DataMoverNodeSelectorConfigKey: "{}", "key": "value"}).
+			Labels(map[string]string{
+				velerov1api.BackupNameLabel:       "prev",
+				velerov1api.PVCUIDLabel:           "pvc-uid",
+				velerov1api.AsyncOperationIDLabel: "du-prev-uid.pvc-uid",
+			}).Result()
+		dataUpload.OwnerReferences = []metav1.OwnerReference{{APIVersion: "velero.io/v1", Kind: "Backup", Name: "prev", UID: "prev-uid"}}
+		return dataUpload
+	}
+	vscName := "vsc-prev"
+	handle := "prev-handle"
+	retriedVS := builder.ForVolumeSnapshot("ns", "vs-prev").ObjectMeta(builder.WithLabels(velerov1api.BackupNameLabel, "prev")).Status().
+		BoundVolumeSnapshotContentName(vscName).Result()
+	retriedVS.Spec.Source.PersistentVolumeClaimName = &[]string{"testPVC"}[0]
+
+	tests := []struct {
+		name                string
+		dataUpload          *velerov2alpha1.DataUpload
+		vs                  *snapshotv1api.VolumeSnapshot
+		expectedOperationID string
+		expectedVS          string
+		expectedDataUploads int
+		expectedRetryOf     string
+		expectedShared      bool
+	}{
+		{
+			name:                "completed DataUpload of the retried backup is reused without snapshotting again",
+			dataUpload:          newRetriedDataUpload(velerov2alpha1.DataUploadPhaseCompleted),
+			vs:                  retriedVS,
+			expectedOperationID: "du-prev-uid.pvc-uid",
+			expectedVS:          "vs-prev",
+			expectedDataUploads: 1,
+			expectedRetryOf:     "prev",
+			expectedShared:      true,
+		},
+		{
+			name: "completed DataUpload of the retried backup in another backup storage location is left to it and the PVC is snapshotted again",
+			dataUpload: func() *velerov2alpha1.DataUpload {
+				dataUpload := newRetriedDataUpload(velerov2alpha1.DataUploadPhaseCompleted)
+				dataUpload.Spec.BackupStorageLocation = "other"
+				return dataUpload
+			}(),
+			expectedOperationID: "du-test-uid.pvc-uid",
+			expectedVS:          "velero-testPVC-new",
+			expectedDataUploads: 2,
+			expectedRetryOf:     "",
+		},
+		{
+			name:                "failed DataUpload of the retried backup is resubmitted with its volumesnapshot",
+			dataUpload:          newRetriedDataUpload(velerov2alpha1.DataUploadPhaseFailed),
+			vs:                  retriedVS,
+			expectedOperationID: "du-test-uid.pvc-uid",
+			expectedVS:          "vs-prev",
+			expectedDataUploads: 2,
+			expectedRetryOf:     "prev",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := builder.ForBackup("velero", "test").SnapshotMoveData(true).
+				ObjectMeta(builder.WithUID("test-uid"), builder.WithAnnotations(util.RetryOfBackupAnnotation, "prev")).Result()
+			pvc := builder.ForPersistentVolumeClaim("ns", "testPVC").ObjectMeta(builder.WithUID("pvc-uid")).
+				VolumeName("testPV").StorageClass("testSC").Phase(corev1.ClaimBound).Result()
+			client := fake.NewSimpleClientset(pvc,
+				builder.ForPersistentVolume("testPV").CSI("hostpath", "testVolume").Result(),
+				builder.ForStorageClass("testSC").Provisioner("hostpath").Result())
+			snapshotClient := snapshotfake.NewSimpleClientset(
+				builder.ForVolumeSnapshotClass("testVSClass").Driver("hostpath").ObjectMeta(builder.WithLabels(util.VolumeSnapshotClassSelectorLabel, "")).Result(),
+				builder.ForVolumeSnapshotContent(vscName).Status(&snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle}).Result())
+			if tc.vs != nil {
+				_, err := snapshotClient.SnapshotV1().VolumeSnapshots(tc.vs.Namespace).Create(context.Background(), tc.vs, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+			// A new volumesnapshot is bound to the volumesnapshotcontent right away.
+			snapshotClient.PrependReactor("create", "volumesnapshots", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				vs := action.(clienttesting.CreateAction).GetObject().(*snapshotv1api.VolumeSnapshot)
+				if vs.GenerateName != "" {
+					vs.Name = vs.GenerateName + "new"
+					vs.Status = &snapshotv1api.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: &vscName}
+				}
+				return false, nil, nil
+			})
+			veleroClient := velerofake.NewSimpleClientset(tc.dataUpload)
+
+			pvcBIA := PVCBackupItemAction{
+				Log:            logrus.New(),
+				Client:         client,
+				SnapshotClient: snapshotClient,
+				VeleroClient:   veleroClient,
+				EventRecorder:  record.NewFakeRecorder(10),
+			}
+
+			pvcMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pvc)
+			require.NoError(t, err)
+			result, _, operationID, itemsToUpdate, err := pvcBIA.Execute(&unstructured.Unstructured{Object: pvcMap}, backup)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOperationID, operationID)
+			require.Len(t, itemsToUpdate, 1)
+
+			vsList, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, vsList.Items, 1)
+			require.Equal(t, tc.expectedVS, vsList.Items[0].Name)
+			if !tc.expectedShared {
+				require.Equal(t, "test", vsList.Items[0].Labels[velerov1api.BackupNameLabel])
+			}
+
+			retried, err := veleroClient.VeleroV2alpha1().DataUploads("velero").Get(context.Background(), "prev-1", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedShared, isOwnedByBackup(retried, "test"))
+			require.True(t, isOwnedByBackup(retried, "prev"))
+			require.Equal(t, "prev", retried.Labels[velerov1api.BackupNameLabel])
+
+			dataUploads, err := veleroClient.VeleroV2alpha1().DataUploads("velero").List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, dataUploads.Items, tc.expectedDataUploads)
+			dataUpload, err := getDataUpload(context.Background(), backup, veleroClient, operationID)
+			require.NoError(t, err)
+			require.Equal(t, itemsToUpdate[0].Name, dataUpload.Name)
+			require.Equal(t, tc.expectedVS, dataUpload.Spec.CSISnapshot.VolumeSnapshot)
+			require.True(t, isOwnedByBackup(dataUpload, "test"))
+
+			resultPVC := new(corev1.PersistentVolumeClaim)
+			require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(result.UnstructuredContent(), resultPVC))
+			require.Equal(t, "velero/"+dataUpload.Name, resultPVC.Annotations[util.DataUploadNameAnnotation])
+			require.Equal(t, tc.expectedVS, resultPVC.Labels[util.VolumeSnapshotLabel])
+			if tc.expectedShared {
+				require.Equal(t, `{"key":"value"}`, resultPVC.Annotations[util.BackupDataMoverConfigAnnotation])
+			}
+
+			reports, err := util.GetVolumeReports(context.Background(), client, backup)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRetryOf, reports["ns/testPVC"].RetryOf)
+		})
+	}
+}
+
 func TestProgress(t *testing.T) {
 	currentTime := time.Now()
 	tests := []struct {
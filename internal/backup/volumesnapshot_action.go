@@ -22,29 +22,37 @@ import (
 	"strings"
 	"time"
 
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/metrics"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/kuberesource"
 	"github.com/vmware-tanzu/velero/pkg/label"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	biav2 "github.com/vmware-tanzu/velero/pkg/plugin/velero/backupitemaction/v2"
-	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
 )
 
 // VolumeSnapshotBackupItemAction is a backup item action plugin to backup
 // CSI VolumeSnapshot objects using Velero
 type VolumeSnapshotBackupItemAction struct {
-	Log logrus.FieldLogger
+	Log            logrus.FieldLogger
+	Client         kubernetes.Interface
+	SnapshotClient snapshotterClientSet.Interface
+	EventRecorder  record.EventRecorder
 }
 
 // AppliesTo returns information indicating that the VolumeSnapshotBackupItemAction should be invoked to backup volumesnapshots.
@@ -61,16 +69,15 @@ func (p *VolumeSnapshotBackupItemAction) AppliesTo() (velero.ResourceSelector, e
 func (p *VolumeSnapshotBackupItemAction) Execute(item runtime.Unstructured, backup *velerov1api.Backup) (runtime.Unstructured, []velero.ResourceIdentifier,
 	string, []velero.ResourceIdentifier, error) {
 	p.Log.Infof("Executing VolumeSnapshotBackupItemAction")
+	defer metrics.Export(p.Log)
 
 	var vs snapshotv1api.VolumeSnapshot
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), &vs); err != nil {
 		return nil, nil, "", nil, errors.WithStack(err)
 	}
 
-	_, snapshotClient, err := util.GetClients()
-	if err != nil {
-		return nil, nil, "", nil, errors.WithStack(err)
-	}
+	ctx, cancel := util.NewBackupContext(backup, p.Log)
+	defer cancel()
 
 	additionalItems := []velero.ResourceIdentifier{
 		{
@@ -93,22 +100,51 @@ func (p *VolumeSnapshotBackupItemAction) Execute(item runtime.Unstructured, back
 	// backup name is the same as that of the value of the backupLabel
 	backupOngoing := vs.Labels[velerov1api.BackupNameLabel] == label.GetValidName(backup.Name)
 
+	// A volumesnapshot created outside of velero is verified before it is backed up when the backup asks for it.
+	externalSnapshotPolicy := ExternalSnapshotPolicyBestEffort
+	if !backupOngoing {
+		externalSnapshotPolicy = getExternalSnapshotPolicy(&vs, backup, p.Log)
+	}
+
 	p.Log.Infof("Getting VolumesnapshotContent for Volumesnapshot %s/%s", vs.Namespace, vs.Name)
 
-	vsc, err := util.GetVolumeSnapshotContentForVolumeSnapshot(&vs, snapshotClient.SnapshotV1(), p.Log, backupOngoing, backup.Spec.CSISnapshotTimeout.Duration)
-	if err != nil {
-		util.CleanupVolumeSnapshot(&vs, snapshotClient.SnapshotV1(), p.Log)
-		return nil, nil, "", nil, errors.WithStack(err)
+	var vsc *snapshotv1api.VolumeSnapshotContent
+	var err error
+	if externalSnapshotPolicy == ExternalSnapshotPolicyBestEffort {
+		vsc, err = util.GetVolumeSnapshotContentForVolumeSnapshot(ctx, &vs, p.SnapshotClient.SnapshotV1(), p.Log, backupOngoing, backup.Spec.CSISnapshotTimeout.Duration)
+		if err != nil {
+			if backupOngoing {
+				metrics.RecordSnapshotFailure(util.GetVolumeSnapshotDriver(ctx, &vs, p.SnapshotClient.SnapshotV1()), metrics.FailureReasonWaitContent)
+				if vs.Spec.Source.PersistentVolumeClaimName != nil {
+					util.RecordPVCEventf(p.EventRecorder, util.PVCEventObject(vs.Namespace, *vs.Spec.Source.PersistentVolumeClaimName), backup,
+						corev1api.EventTypeWarning, util.EventReasonSnapshotFailed, "Failed to get volumesnapshotcontent of volumesnapshot %s/%s: %s", vs.Namespace, vs.Name, err.Error())
+				}
+			}
+			util.CleanupVolumeSnapshot(ctx, &vs, p.SnapshotClient.SnapshotV1(), p.Log)
+			return nil, nil, "", nil, errors.WithStack(err)
+		}
+	} else {
+		// The volumesnapshot belongs to whoever created it, so it is left in place when it is not usable.
+		vsc, err = waitForExternalSnapshot(ctx, &vs, p.SnapshotClient.SnapshotV1(), util.GetCSISnapshotTimeout(backup), p.Log)
+		if err != nil {
+			if externalSnapshotPolicy == ExternalSnapshotPolicyFail {
+				return nil, nil, "", nil, errors.Wrapf(err, "volumesnapshot %s/%s created outside Velero is not usable", vs.Namespace, vs.Name)
+			}
+			p.Log.Warnf("Backing up volumesnapshot %s/%s created outside Velero that is not usable: %s", vs.Namespace, vs.Name, err.Error())
+		}
 	}
 
 	if backup.Status.Phase == velerov1api.BackupPhaseFinalizing || backup.Status.Phase == velerov1api.BackupPhaseFinalizingPartiallyFailed {
 		p.Log.WithField("Backup", fmt.Sprintf("%s/%s", backup.Namespace, backup.Name)).
 			WithField("BackupPhase", backup.Status.Phase).Debugf("Clean VolumeSnapshots.")
-		util.DeleteVolumeSnapshot(vs, *vsc, backup, snapshotClient.SnapshotV1(), p.Log)
+		util.DeleteVolumeSnapshot(ctx, vs, *vsc, backup, p.SnapshotClient.SnapshotV1(), p.Log)
 		return item, nil, "", nil, nil
 	}
 
 	annotations := make(map[string]string)
+	if externalSnapshotPolicy != ExternalSnapshotPolicyBestEffort {
+		annotations[util.ExternalSnapshotAnnotation] = "true"
+	}
 
 	if vsc != nil {
 		// when we are backing up volumesnapshots created outside of velero, we will not
@@ -122,6 +158,12 @@ func (p *VolumeSnapshotBackupItemAction) Execute(item runtime.Unstructured, back
 		})
 		annotations[util.CSIVSCDeletionPolicy] = string(vsc.Spec.DeletionPolicy)
 
+		// The static volumesnapshotcontent created on restore needs the same secret to delete the storage snapshot.
+		if util.IsVolumeSnapshotContentHasDeleteSecret(vsc) {
+			annotations[util.CSIDeleteSnapshotSecretName] = vsc.Annotations[util.PrefixedSnapshotterSecretNameKey]
+			annotations[util.CSIDeleteSnapshotSecretNamespace] = vsc.Annotations[util.PrefixedSnapshotterSecretNamespaceKey]
+		}
+
 		if vsc.Status != nil {
 			if vsc.Status.SnapshotHandle != nil {
 				// Capture storage provider snapshot handle and CSI driver name
@@ -147,10 +189,45 @@ func (p *VolumeSnapshotBackupItemAction) Execute(item runtime.Unstructured, back
 			// volumesnapshotcontents. We do that by adding the "velero.io/backup-name" label on the volumesnapshotcontent.
 			// Further, we want to add this label only on volumesnapshotcontents that were created during an ongoing velero backup.
 
-			pb := []byte(fmt.Sprintf(`{"metadata":{"labels":{"%s":"%s"}}}`, velerov1api.BackupNameLabel, label.GetValidName(backup.Name)))
-			if _, vscPatchError := snapshotClient.SnapshotV1().VolumeSnapshotContents().Patch(context.TODO(), vsc.Name, types.MergePatchType, pb, metav1.PatchOptions{}); vscPatchError != nil {
+			vscAnnotations := map[string]string{
+				util.SourceVolumeSnapshotAnnotation: vs.Namespace + "/" + vs.Name,
+			}
+
+			// When the backup asks for a shorter snapshot retention than its TTL, record the expiry on both objects.
+			// The retention sweeper removes the snapshot after it, and the backed-up volumesnapshot carries it for restore.
+			retention, err := util.GetSnapshotRetention(backup)
+			if err != nil {
+				p.Log.Warnf("Ignore snapshot retention: %s", err.Error())
+			} else if retention > 0 {
+				expiresAt := time.Now().Add(retention).UTC().Format(time.RFC3339)
+				vscAnnotations[util.SnapshotExpiresAtAnnotation] = expiresAt
+				annotations[util.SnapshotExpiresAtAnnotation] = expiresAt
+			}
+
+			vscAnnotationsPatch := ""
+			for k, v := range vscAnnotations {
+				vscAnnotationsPatch += fmt.Sprintf(`"%s":"%s",`, k, v)
+			}
+			pb := []byte(fmt.Sprintf(`{"metadata":{"labels":{"%s":"%s"},"annotations":{%s}}}`,
+				velerov1api.BackupNameLabel, label.GetValidName(backup.Name), strings.Trim(vscAnnotationsPatch, ",")))
+			if _, vscPatchError := p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Patch(ctx, vsc.Name, types.MergePatchType, pb, metav1.PatchOptions{}); vscPatchError != nil {
 				p.Log.Warnf("Failed to patch volumesnapshotcontent %s: %v", vsc.Name, vscPatchError)
 			}
+
+			if vsc.Status != nil && vsc.Status.SnapshotHandle != nil && !vs.CreationTimestamp.IsZero() {
+				metrics.ObserveSnapshotHandle(vsc.Spec.Driver, time.Since(vs.CreationTimestamp.Time))
+			}
+
+			if vs.Spec.Source.PersistentVolumeClaimName != nil {
+				updateVolumeReport(ctx, p.Client, backup, vs.Namespace, *vs.Spec.Source.PersistentVolumeClaimName, func(report *util.VolumeReport) {
+					report.VolumeSnapshotContent = vsc.Name
+					report.SnapshotHandle = annotations[util.VolumeSnapshotHandleAnnotation]
+					report.RestoreSize = annotations[util.VolumeSnapshotRestoreSize]
+					if report.HandleDuration == "" && !vs.CreationTimestamp.IsZero() {
+						report.HandleDuration = time.Since(vs.CreationTimestamp.Time).Round(time.Second).String()
+					}
+				}, p.Log)
+			}
 		}
 	}
 
@@ -164,7 +241,7 @@ func (p *VolumeSnapshotBackupItemAction) Execute(item runtime.Unstructured, back
 	}
 	pb = strings.Trim(pb, ",")
 	pb += "}}}"
-	if _, err := snapshotClient.SnapshotV1().VolumeSnapshots(vs.Namespace).Patch(context.TODO(),
+	if _, err := p.SnapshotClient.SnapshotV1().VolumeSnapshots(vs.Namespace).Patch(ctx,
 		vs.Name, types.MergePatchType, []byte(pb), metav1.PatchOptions{}); err != nil {
 		p.Log.Errorf("Fail to patch volumesnapshot with content %s: %s.", pb, err.Error())
 		return nil, nil, "", nil, errors.WithStack(err)
@@ -189,8 +266,18 @@ func (p *VolumeSnapshotBackupItemAction) Execute(item runtime.Unstructured, back
 
 	// Only return Async operation for VSC created for this backup.
 	if backupOngoing {
-		// The operationID is of the form <namespace>/<volumesnapshot-name>/<started-time>
-		operationID = vs.Namespace + "/" + vs.Name + "/" + time.Now().Format(time.RFC3339)
+		// The operation ID carries the UIDs, so Progress can tell a volumesnapshot recreated under the same name.
+		operationID, err = util.OperationID{
+			Kind:      util.VolumeSnapshotKindName,
+			Namespace: vs.Namespace,
+			Name:      vs.Name,
+			UID:       vs.UID,
+			Started:   time.Now(),
+			BackupUID: backup.UID,
+		}.Encode()
+		if err != nil {
+			return nil, nil, "", nil, err
+		}
 		itemToUpdate = []velero.ResourceIdentifier{
 			{
 				GroupResource: kuberesource.VolumeSnapshots,
@@ -212,48 +299,106 @@ func (p *VolumeSnapshotBackupItemAction) Progress(operationID string, backup *ve
 	if operationID == "" {
 		return progress, biav2.InvalidOperationIDError(operationID)
 	}
-	// The operationID is of the form <namespace>/<volumesnapshot-name>/<started-time>
-	operationIDParts := strings.Split(operationID, "/")
-	if len(operationIDParts) != 3 {
-		p.Log.Errorf("invalid operation ID %s", operationID)
-		return progress, biav2.InvalidOperationIDError(operationID)
-	}
-	var err error
-	if progress.Started, err = time.Parse(time.RFC3339, operationIDParts[2]); err != nil {
-		p.Log.Errorf("error parsing operation ID's StartedTime part into time %s: %s", operationID, err.Error())
-		return progress, errors.WithStack(err)
-	}
-
-	_, snapshotClient, err := util.GetClients()
+	id, err := p.decodeOperationID(operationID, backup)
 	if err != nil {
-		return progress, errors.WithStack(err)
+		return progress, err
 	}
+	progress.Started = id.Started
+
+	defer metrics.Export(p.Log)
+
+	ctx, cancel := util.NewResourceContext(backup.Annotations, p.Log)
+	defer cancel()
 
-	vs, err := snapshotClient.SnapshotV1().VolumeSnapshots(operationIDParts[0]).Get(context.Background(), operationIDParts[1], metav1.GetOptions{})
+	vs, err := p.SnapshotClient.SnapshotV1().VolumeSnapshots(id.Namespace).Get(ctx, id.Name, metav1.GetOptions{})
 	if err != nil {
-		p.Log.Errorf("error getting volumesnapshot %s/%s: %s", operationIDParts[0], operationIDParts[1], err.Error())
+		if apierrors.IsNotFound(err) {
+			// The volumesnapshot is removed by Cancel, or by someone else. Either way it can't become ready.
+			progress.Completed = true
+			progress.Err = fmt.Sprintf("VolumeSnapshot %s/%s is deleted, the operation is canceled", id.Namespace, id.Name)
+			return progress, nil
+		}
+		p.Log.Errorf("error getting volumesnapshot %s/%s: %s", id.Namespace, id.Name, err.Error())
 		return progress, errors.WithStack(err)
 	}
 
-	if vs.Status == nil {
-		p.Log.Debugf("VolumeSnapshot %s/%s has an empty status. Skip progress update.", vs.Namespace, vs.Name)
+	if id.UID != "" && vs.UID != id.UID {
+		// The volumesnapshot of the operation is deleted, and another one is created under the same name.
+		progress.Completed = true
+		progress.Err = fmt.Sprintf("VolumeSnapshot %s/%s is recreated with UID %s, the operation started with UID %s", vs.Namespace, vs.Name, vs.UID, id.UID)
 		return progress, nil
 	}
 
-	if boolptr.IsSetToTrue(vs.Status.ReadyToUse) {
+	if util.IsSnapshotCanceled(&vs.ObjectMeta) {
 		progress.Completed = true
-	} else if vs.Status.Error != nil {
-		errorMessage := ""
-		if vs.Status.Error.Message != nil {
-			errorMessage = *vs.Status.Error.Message
+		progress.Err = fmt.Sprintf("VolumeSnapshot %s/%s is canceled", vs.Namespace, vs.Name)
+		return progress, nil
+	}
+
+	state := util.GetVolumeSnapshotState(vs)
+	progress = util.GetSnapshotProgress(state, id.Started, backup, p.Log)
+
+	if state.ReadyToUse {
+		metrics.ObserveSnapshotReady(util.GetVolumeSnapshotDriver(ctx, vs, p.SnapshotClient.SnapshotV1()), time.Since(vs.CreationTimestamp.Time))
+		if vs.Spec.Source.PersistentVolumeClaimName != nil {
+			util.RecordPVCEventf(p.EventRecorder, util.PVCEventObject(vs.Namespace, *vs.Spec.Source.PersistentVolumeClaimName), backup,
+				corev1api.EventTypeNormal, util.EventReasonSnapshotReady, "Volumesnapshot %s/%s is ready to use", vs.Namespace, vs.Name)
+			updateVolumeReport(ctx, p.Client, backup, vs.Namespace, *vs.Spec.Source.PersistentVolumeClaimName, func(report *util.VolumeReport) {
+				report.ReadyDuration = time.Since(vs.CreationTimestamp.Time).Round(time.Second).String()
+			}, p.Log)
+		}
+	} else if progress.Completed {
+		reason := metrics.FailureReasonReadyTimeout
+		if state.HasError {
+			reason = metrics.FailureReasonSnapshotError
+		}
+		metrics.RecordSnapshotFailure(util.GetVolumeSnapshotDriver(ctx, vs, p.SnapshotClient.SnapshotV1()), reason)
+		if vs.Spec.Source.PersistentVolumeClaimName != nil {
+			util.RecordPVCEventf(p.EventRecorder, util.PVCEventObject(vs.Namespace, *vs.Spec.Source.PersistentVolumeClaimName), backup,
+				corev1api.EventTypeWarning, util.EventReasonSnapshotFailed, "Volumesnapshot %s/%s failed: %s", vs.Namespace, vs.Name, progress.Err)
 		}
-		p.Log.Warnf("VolumeSnapshot has a temporary error %s. Snapshot controller will retry later.", errorMessage)
 	}
 
 	return progress, nil
 }
 
 func (p *VolumeSnapshotBackupItemAction) Cancel(operationID string, backup *velerov1api.Backup) error {
-	// CSI Specification doesn't support canceling a snapshot creation.
+	id, err := p.decodeOperationID(operationID, backup)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := util.NewResourceContext(backup.Annotations, p.Log)
+	defer cancel()
+
+	// CSI Specification doesn't support canceling a snapshot creation, so the volumesnapshot that is not ready yet
+	// is removed together with the storage snapshot.
+	if err := util.CancelVolumeSnapshot(ctx, id.Namespace, id.Name, id.UID, p.SnapshotClient.SnapshotV1(), p.Log); err != nil {
+		p.Log.WithError(err).Errorf("Fail to cancel volumesnapshot %s/%s", id.Namespace, id.Name)
+		return err
+	}
 	return nil
 }
+
+// decodeOperationID decodes the operation ID returned by Execute, and checks it belongs to the backup.
+func (p *VolumeSnapshotBackupItemAction) decodeOperationID(operationID string, backup *velerov1api.Backup) (*util.OperationID, error) {
+	id, err := util.DecodeOperationID(operationID, util.VolumeSnapshotKindName)
+	if err != nil {
+		p.Log.WithError(err).Errorf("invalid operation ID %s", operationID)
+		return nil, biav2.InvalidOperationIDError(operationID)
+	}
+	if id.BackupUID != "" && backup.UID != "" && id.BackupUID != backup.UID {
+		p.Log.Errorf("operation ID %s belongs to backup with UID %s, not %s", operationID, id.BackupUID, backup.UID)
+		return nil, biav2.InvalidOperationIDError(operationID)
+	}
+	return id, nil
+}
+
+// updateVolumeReport records what was done for the PVC in the volume report of the backup.
+// The report is informational, so failing to write it doesn't fail the backup.
+func updateVolumeReport(ctx context.Context, client kubernetes.Interface, backup *velerov1api.Backup, pvcNamespace, pvcName string,
+	update func(*util.VolumeReport), log logrus.FieldLogger) {
+	if err := util.UpdateVolumeReport(ctx, client, backup, pvcNamespace, pvcName, update); err != nil {
+		log.WithError(err).Warnf("Fail to update volume report of PVC %s/%s", pvcNamespace, pvcName)
+	}
+}
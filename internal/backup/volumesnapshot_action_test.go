@@ -0,0 +1,229 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	"github.com/vmware-tanzu/velero/pkg/kuberesource"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+func TestVolumeSnapshotProgress(t *testing.T) {
+	started := time.Now().Truncate(time.Second)
+	backup := builder.ForBackup("velero", "test").Result()
+	backup.UID = "backup-uid"
+	encodedID := func(vsUID, backupUID types.UID) string {
+		id, err := util.OperationID{
+			Kind:      util.VolumeSnapshotKindName,
+			Namespace: "ns",
+			Name:      "vs-1",
+			UID:       vsUID,
+			Started:   started,
+			BackupUID: backupUID,
+		}.Encode()
+		require.NoError(t, err)
+		return id
+	}
+
+	tests := []struct {
+		name            string
+		operationID     string
+		expectErr       bool
+		expectCompleted bool
+		expectOpErr     bool
+	}{
+		{
+			name:            "legacy operation ID",
+			operationID:     "ns/vs-1/" + started.Format(time.RFC3339),
+			expectCompleted: true,
+		},
+		{
+			name:            "encoded operation ID",
+			operationID:     encodedID("vs-uid", "backup-uid"),
+			expectCompleted: true,
+		},
+		{
+			name:            "volumesnapshot is recreated",
+			operationID:     encodedID("old-uid", "backup-uid"),
+			expectCompleted: true,
+			expectOpErr:     true,
+		},
+		{
+			name:        "operation of another backup",
+			operationID: encodedID("vs-uid", "other-backup-uid"),
+			expectErr:   true,
+		},
+		{
+			name:        "invalid operation ID",
+			operationID: "vs-1",
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vs := &snapshotv1api.VolumeSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs-1", UID: "vs-uid"},
+				Status:     &snapshotv1api.VolumeSnapshotStatus{ReadyToUse: boolptr.True()},
+			}
+			p := VolumeSnapshotBackupItemAction{
+				Log:            logrus.New(),
+				Client:         fake.NewSimpleClientset(),
+				SnapshotClient: snapshotfake.NewSimpleClientset(vs),
+			}
+
+			progress, err := p.Progress(tc.operationID, backup)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, started.Equal(progress.Started))
+			assert.Equal(t, tc.expectCompleted, progress.Completed)
+			assert.Equal(t, tc.expectOpErr, progress.Err != "")
+		})
+	}
+}
+
+func TestVolumeSnapshotExecuteExternal(t *testing.T) {
+	defer func(interval time.Duration) { externalSnapshotInterval = interval }(externalSnapshotInterval)
+	externalSnapshotInterval = time.Millisecond
+	className, vscName, handle := "class", "vsc-1", "handle"
+
+	newVS := func(bound, ready bool) *snapshotv1api.VolumeSnapshot {
+		vs := &snapshotv1api.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs-1"},
+			Spec:       snapshotv1api.VolumeSnapshotSpec{VolumeSnapshotClassName: &className},
+		}
+		if bound {
+			vs.Status = &snapshotv1api.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: &vscName, ReadyToUse: boolptr.False()}
+			if ready {
+				vs.Status.ReadyToUse = boolptr.True()
+			}
+		}
+		return vs
+	}
+	vsc := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsc-1", Annotations: map[string]string{
+			util.PrefixedSnapshotterSecretNameKey: "secret", util.PrefixedSnapshotterSecretNamespaceKey: "secrets"}},
+		Spec:   snapshotv1api.VolumeSnapshotContentSpec{Driver: "driver", DeletionPolicy: snapshotv1api.VolumeSnapshotContentDelete},
+		Status: &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle},
+	}
+
+	tests := []struct {
+		name             string
+		policy           string
+		vs               *snapshotv1api.VolumeSnapshot
+		expectErr        string
+		expectVSC        bool
+		expectAnnotation bool
+	}{
+		{
+			name: "unbound volumesnapshot is backed up without verification by default",
+			vs:   newVS(false, false),
+		},
+		{
+			name:             "ready volumesnapshot is backed up with its volumesnapshotcontent",
+			policy:           "fail",
+			vs:               newVS(true, true),
+			expectVSC:        true,
+			expectAnnotation: true,
+		},
+		{
+			name:             "volumesnapshot not ready to use is backed up with a warning",
+			policy:           "warn",
+			vs:               newVS(true, false),
+			expectVSC:        true,
+			expectAnnotation: true,
+		},
+		{
+			name:      "unbound volumesnapshot fails the backup",
+			policy:    "fail",
+			vs:        newVS(false, false),
+			expectErr: "volumesnapshot ns/vs-1 created outside Velero is not usable: volumesnapshot ns/vs-1 is not bound to a volumesnapshotcontent after 10ms",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := builder.ForBackup("velero", "test").CSISnapshotTimeout(10 * time.Millisecond).
+				ObjectMeta(builder.WithAnnotations(util.ExternalSnapshotPolicyAnnotation, tc.policy)).Result()
+			snapshotClient := snapshotfake.NewSimpleClientset(tc.vs, vsc)
+			p := VolumeSnapshotBackupItemAction{
+				Log:            logrus.New(),
+				Client:         fake.NewSimpleClientset(),
+				SnapshotClient: snapshotClient,
+			}
+			vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc.vs)
+			require.NoError(t, err)
+
+			item, additionalItems, _, _, err := p.Execute(&unstructured.Unstructured{Object: vsMap}, backup)
+			if tc.expectErr != "" {
+				require.EqualError(t, err, tc.expectErr)
+				_, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-1", metav1.GetOptions{})
+				assert.NoError(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if tc.expectVSC {
+				assert.Contains(t, additionalItems, velero.ResourceIdentifier{GroupResource: kuberesource.VolumeSnapshotContents, Name: "vsc-1"})
+			} else {
+				assert.Len(t, additionalItems, 1)
+			}
+			backedUp := new(snapshotv1api.VolumeSnapshot)
+			require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), backedUp))
+			if tc.expectVSC {
+				assert.Equal(t, "secret", backedUp.Annotations[util.CSIDeleteSnapshotSecretName])
+				assert.Equal(t, "secrets", backedUp.Annotations[util.CSIDeleteSnapshotSecretNamespace])
+			}
+			if tc.expectAnnotation {
+				assert.Equal(t, "true", backedUp.Annotations[util.ExternalSnapshotAnnotation])
+				assert.Equal(t, string(snapshotv1api.VolumeSnapshotContentDelete), backedUp.Annotations[util.CSIVSCDeletionPolicy])
+			} else {
+				assert.NotContains(t, backedUp.Annotations, util.ExternalSnapshotAnnotation)
+			}
+		})
+	}
+}
+
+func TestGetExternalSnapshotPolicy(t *testing.T) {
+	backup := builder.ForBackup("velero", "test").ObjectMeta(builder.WithAnnotations(util.ExternalSnapshotPolicyAnnotation, "warn")).Result()
+	vs := &snapshotv1api.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{util.ExternalSnapshotPolicyAnnotation: "Fail"}}}
+
+	assert.Equal(t, ExternalSnapshotPolicyFail, getExternalSnapshotPolicy(vs, backup, logrus.New()))
+	assert.Equal(t, ExternalSnapshotPolicyWarn, getExternalSnapshotPolicy(&snapshotv1api.VolumeSnapshot{}, backup, logrus.New()))
+	assert.Equal(t, ExternalSnapshotPolicyBestEffort, getExternalSnapshotPolicy(&snapshotv1api.VolumeSnapshot{},
+		builder.ForBackup("velero", "test").ObjectMeta(builder.WithAnnotations(util.ExternalSnapshotPolicyAnnotation, "unknown")).Result(), logrus.New()))
+}
@@ -17,32 +17,33 @@ limitations under the License.
 package backup
 
 import (
-	"context"
 	"fmt"
-	"strings"
 	"time"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/metrics"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/kuberesource"
 	"github.com/vmware-tanzu/velero/pkg/label"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	biav2 "github.com/vmware-tanzu/velero/pkg/plugin/velero/backupitemaction/v2"
-	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
 )
 
 // VolumeSnapshotContentBackupItemAction is a backup item action plugin to backup
 // CSI VolumeSnapshotcontent objects using Velero
 type VolumeSnapshotContentBackupItemAction struct {
-	Log logrus.FieldLogger
+	Log            logrus.FieldLogger
+	SnapshotClient snapshotterClientSet.Interface
 }
 
 // AppliesTo returns information indicating that the VolumeSnapshotContentBackupItemAction action should be invoked to backup volumesnapshotcontents.
@@ -91,14 +92,25 @@ func (p *VolumeSnapshotContentBackupItemAction) Execute(item runtime.Unstructure
 		return nil, nil, "", nil, errors.WithStack(err)
 	}
 
-	backupOnGoing := snapCont.GetLabels()[velerov1api.BackupNameLabel] == label.GetValidName(backup.Name)
+	// The volumesnapshotcontent keeping a local snapshot is never bound, so there is no operation to wait for.
+	backupOnGoing := snapCont.GetLabels()[velerov1api.BackupNameLabel] == label.GetValidName(backup.Name) &&
+		!util.IsLocalSnapshotContent(&snapCont)
 	operationID := ""
 	var itemToUpdate []velero.ResourceIdentifier
 
 	// Only return Async operation for VSC created for this backup.
 	if backupOnGoing {
-		// The operationID is of the form <volumesnapshotcontent-name>/<started-time>
-		operationID = snapCont.Name + "/" + time.Now().Format(time.RFC3339)
+		// The operation ID carries the UIDs, so Progress can tell a volumesnapshotcontent recreated under the same name.
+		operationID, err = util.OperationID{
+			Kind:      util.VolumeSnapshotContentKindName,
+			Name:      snapCont.Name,
+			UID:       snapCont.UID,
+			Started:   time.Now(),
+			BackupUID: backup.UID,
+		}.Encode()
+		if err != nil {
+			return nil, nil, "", nil, err
+		}
 		itemToUpdate = []velero.ResourceIdentifier{
 			{
 				GroupResource: kuberesource.VolumeSnapshotContents,
@@ -122,47 +134,83 @@ func (p *VolumeSnapshotContentBackupItemAction) Progress(operationID string, bac
 		return progress, biav2.InvalidOperationIDError(operationID)
 	}
 
-	// The operationId is of the form <volumesnapshotcontent-name>/<started-time>
-	operationsIDParts := strings.Split(operationID, "/")
-	if len(operationsIDParts) != 2 {
-		p.Log.WithField("operationID", operationID).Error("Invalid operationID")
-		return progress, biav2.InvalidOperationIDError(operationID)
-	}
-	var err error
-	if progress.Started, err = time.Parse(time.RFC3339, operationsIDParts[1]); err != nil {
-		p.Log.Errorf("error parsing operationID's StartedTime part into time %s: %s", operationID, err.Error())
-		return progress, errors.WithStack(fmt.Errorf("fail to parse StartedTime: %s", err.Error()))
-	}
-
-	_, snapshotClient, err := util.GetClients()
+	id, err := p.decodeOperationID(operationID, backup)
 	if err != nil {
-		return progress, errors.WithStack(err)
+		return progress, err
 	}
+	progress.Started = id.Started
 
-	vsc, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), operationsIDParts[0], metav1.GetOptions{})
+	ctx, cancel := util.NewResourceContext(backup.Annotations, p.Log)
+	defer cancel()
+
+	vsc, err := p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, id.Name, metav1.GetOptions{})
 	if err != nil {
-		p.Log.Errorf("error getting volumesnapshotcontent %s: %s", operationsIDParts[0], err.Error())
+		if apierrors.IsNotFound(err) {
+			// The volumesnapshotcontent is removed by Cancel, or by someone else. Either way it can't become ready.
+			progress.Completed = true
+			progress.Err = fmt.Sprintf("VolumeSnapshotContent %s is deleted, the operation is canceled", id.Name)
+			return progress, nil
+		}
+		p.Log.Errorf("error getting volumesnapshotcontent %s: %s", id.Name, err.Error())
 		return progress, errors.WithStack(err)
 	}
 
-	if vsc.Status == nil {
-		p.Log.Debugf("VolumeSnapshotContent %s has an empty Status. Skip progress update.", vsc.Name)
+	if id.UID != "" && vsc.UID != id.UID {
+		// The volumesnapshotcontent of the operation is deleted, and another one is created under the same name.
+		progress.Completed = true
+		progress.Err = fmt.Sprintf("VolumeSnapshotContent %s is recreated with UID %s, the operation started with UID %s", vsc.Name, vsc.UID, id.UID)
 		return progress, nil
 	}
 
-	if boolptr.IsSetToTrue(vsc.Status.ReadyToUse) {
+	if util.IsSnapshotCanceled(&vsc.ObjectMeta) {
 		progress.Completed = true
-	} else if vsc.Status.Error != nil {
-		progress.Completed = true
-		if vsc.Status.Error.Message != nil {
-			progress.Err = *vsc.Status.Error.Message
+		progress.Err = fmt.Sprintf("VolumeSnapshotContent %s is canceled", vsc.Name)
+		return progress, nil
+	}
+
+	state := util.GetVolumeSnapshotContentState(vsc)
+	progress = util.GetSnapshotProgress(state, id.Started, backup, p.Log)
+
+	if !state.ReadyToUse && progress.Completed {
+		reason := metrics.FailureReasonReadyTimeout
+		if state.HasError {
+			reason = metrics.FailureReasonSnapshotError
 		}
+		metrics.RecordSnapshotFailure(vsc.Spec.Driver, reason)
+		metrics.Export(p.Log)
 	}
 
 	return progress, nil
 }
 
 func (p *VolumeSnapshotContentBackupItemAction) Cancel(operationID string, backup *velerov1api.Backup) error {
-	// CSI Specification doesn't support canceling a snapshot creation.
+	id, err := p.decodeOperationID(operationID, backup)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := util.NewResourceContext(backup.Annotations, p.Log)
+	defer cancel()
+
+	// CSI Specification doesn't support canceling a snapshot creation, so the volumesnapshotcontent that is not
+	// ready yet is removed together with its volumesnapshot and the storage snapshot.
+	if err := util.CancelVolumeSnapshotContent(ctx, id.Name, id.UID, p.SnapshotClient.SnapshotV1(), p.Log); err != nil {
+		p.Log.WithError(err).Errorf("Fail to cancel volumesnapshotcontent %s", id.Name)
+		return err
+	}
 	return nil
 }
+
+// decodeOperationID decodes the operation ID returned by Execute, and checks it belongs to the backup.
+func (p *VolumeSnapshotContentBackupItemAction) decodeOperationID(operationID string, backup *velerov1api.Backup) (*util.OperationID, error) {
+	id, err := util.DecodeOperationID(operationID, util.VolumeSnapshotContentKindName)
+	if err != nil {
+		p.Log.WithField("operationID", operationID).WithError(err).Error("Invalid operationID")
+		return nil, biav2.InvalidOperationIDError(operationID)
+	}
+	if id.BackupUID != "" && backup.UID != "" && id.BackupUID != backup.UID {
+		p.Log.WithField("operationID", operationID).Errorf("Operation belongs to backup with UID %s, not %s", id.BackupUID, backup.UID)
+		return nil, biav2.InvalidOperationIDError(operationID)
+	}
+	return id, nil
+}
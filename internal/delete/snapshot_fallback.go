@@ -0,0 +1,165 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package delete
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+var (
+	// snapshotDeletionTimeout bounds each wait on the CSI driver while deleting a storage snapshot through
+	// a temporary volumesnapshotcontent.
+	snapshotDeletionTimeout  = 5 * time.Minute
+	snapshotDeletionInterval = 2 * time.Second
+	// snapshotCleanupTimeout bounds the removal of the temporary volumesnapshotcontent after the CSI driver didn't process it.
+	snapshotCleanupTimeout = time.Minute
+)
+
+// backedUpSnapshotHandle returns the storage snapshot handle of the backed-up volumesnapshotcontent, if any.
+func backedUpSnapshotHandle(vsc *snapshotv1api.VolumeSnapshotContent) string {
+	if vsc.Status != nil && vsc.Status.SnapshotHandle != nil {
+		return *vsc.Status.SnapshotHandle
+	}
+	if vsc.Spec.Source.SnapshotHandle != nil {
+		return *vsc.Spec.Source.SnapshotHandle
+	}
+	return ""
+}
+
+// temporaryVolumeSnapshotContentName returns the name of the temporary volumesnapshotcontent deleting the storage snapshot
+// of the backed-up volumesnapshotcontent. It is scoped to the backup, so deletions of backups sharing a volumesnapshotcontent
+// name don't use the same one.
+func temporaryVolumeSnapshotContentName(backup *velerov1api.Backup, backedUp *snapshotv1api.VolumeSnapshotContent) string {
+	return label.GetValidName("velero-delete-" + string(backup.UID) + "-" + backedUp.Name)
+}
+
+// deleteSnapshotByHandle deletes the storage snapshot of the backed-up volumesnapshotcontent that no longer exists in the cluster.
+// It creates a temporary static volumesnapshotcontent for the snapshot handle with the Delete policy, waits for the CSI driver
+// to pick it up, deletes it and waits for the CSI driver to remove the storage snapshot.
+func deleteSnapshotByHandle(ctx context.Context, backedUp *snapshotv1api.VolumeSnapshotContent, handle string, backup *velerov1api.Backup,
+	snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) error {
+	name := temporaryVolumeSnapshotContentName(backup, backedUp)
+	vsc := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				velerov1api.BackupNameLabel: label.GetValidName(backup.Name),
+			},
+		},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{
+			DeletionPolicy: snapshotv1api.VolumeSnapshotContentDelete,
+			Driver:         backedUp.Spec.Driver,
+			// The reference is a placeholder no volumesnapshot binds to, so the snapshot controller never
+			// binds the temporary volumesnapshotcontent to a volumesnapshot of the workload.
+			VolumeSnapshotRef: corev1api.ObjectReference{
+				Kind:      util.VolumeSnapshotKindName,
+				Namespace: backup.Namespace,
+				Name:      name,
+			},
+			Source: snapshotv1api.VolumeSnapshotContentSource{
+				SnapshotHandle: &handle,
+			},
+			VolumeSnapshotClassName: backedUp.Spec.VolumeSnapshotClassName,
+		},
+	}
+	if util.IsVolumeSnapshotContentHasDeleteSecret(backedUp) {
+		vsc.Annotations = map[string]string{
+			util.PrefixedSnapshotterSecretNameKey:      backedUp.Annotations[util.PrefixedSnapshotterSecretNameKey],
+			util.PrefixedSnapshotterSecretNamespaceKey: backedUp.Annotations[util.PrefixedSnapshotterSecretNamespaceKey],
+		}
+	}
+
+	// A deletion retried after a failure finds the temporary volumesnapshotcontent it created before.
+	if _, err := snapshotClient.VolumeSnapshotContents().Create(ctx, vsc, metav1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "fail to create temporary volumesnapshotcontent %s", vsc.Name)
+		}
+		existing, err := snapshotClient.VolumeSnapshotContents().Get(ctx, vsc.Name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "fail to get temporary volumesnapshotcontent %s", vsc.Name)
+		}
+		if existing.Spec.Source.SnapshotHandle == nil || *existing.Spec.Source.SnapshotHandle != handle {
+			return errors.Errorf("volumesnapshotcontent %s exists and doesn't delete snapshot %s", vsc.Name, handle)
+		}
+	}
+	log.Infof("Created temporary volumesnapshotcontent %s to delete snapshot %s", vsc.Name, handle)
+
+	// The CSI driver only deletes the storage snapshot of a volumesnapshotcontent it has processed.
+	reason := ""
+	err := wait.PollImmediateWithContext(ctx, snapshotDeletionInterval, snapshotDeletionTimeout, func(ctx context.Context) (bool, error) {
+		current, err := snapshotClient.VolumeSnapshotContents().Get(ctx, vsc.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, errors.Wrapf(err, "fail to get temporary volumesnapshotcontent %s", vsc.Name)
+		}
+		if current.Status != nil && current.Status.Error != nil && current.Status.Error.Message != nil {
+			reason = fmt.Sprintf(": %s", *current.Status.Error.Message)
+		}
+		return current.Status != nil && current.Status.SnapshotHandle != nil, nil
+	})
+	if err != nil {
+		// The temporary volumesnapshotcontent is not left behind, a later deletion creates it again. ctx may be done
+		// when the wait ran out of it.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), snapshotCleanupTimeout)
+		defer cancel()
+		if deleteErr := snapshotClient.VolumeSnapshotContents().Delete(cleanupCtx, vsc.Name, metav1.DeleteOptions{}); deleteErr != nil && !apierrors.IsNotFound(deleteErr) {
+			log.WithError(deleteErr).Warnf("Fail to delete temporary volumesnapshotcontent %s", vsc.Name)
+		}
+		return errors.Wrapf(err, "CSI driver %s didn't process temporary volumesnapshotcontent %s of snapshot %s%s",
+			vsc.Spec.Driver, vsc.Name, handle, reason)
+	}
+
+	if err := snapshotClient.VolumeSnapshotContents().Delete(ctx, vsc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to delete temporary volumesnapshotcontent %s", vsc.Name)
+	}
+
+	reason = ""
+	err = wait.PollImmediateWithContext(ctx, snapshotDeletionInterval, snapshotDeletionTimeout, func(ctx context.Context) (bool, error) {
+		current, err := snapshotClient.VolumeSnapshotContents().Get(ctx, vsc.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "fail to get temporary volumesnapshotcontent %s", vsc.Name)
+		}
+		if current.Status != nil && current.Status.Error != nil && current.Status.Error.Message != nil {
+			reason = fmt.Sprintf(": %s", *current.Status.Error.Message)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "CSI driver %s didn't delete snapshot %s of temporary volumesnapshotcontent %s%s",
+			vsc.Spec.Driver, handle, vsc.Name, reason)
+	}
+
+	log.Infof("Deleted snapshot %s through temporary volumesnapshotcontent %s", handle, vsc.Name)
+	return nil
+}
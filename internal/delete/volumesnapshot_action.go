@@ -4,12 +4,16 @@ import (
 	"context"
 	"fmt"
 
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/metrics"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -17,7 +21,9 @@ import (
 
 // VolumeSnapshotDeleteItemAction is a backup item action plugin for Velero.
 type VolumeSnapshotDeleteItemAction struct {
-	Log logrus.FieldLogger
+	Log            logrus.FieldLogger
+	Client         kubernetes.Interface
+	SnapshotClient snapshotterClientSet.Interface
 }
 
 // AppliesTo returns information indicating that the VolumeSnapshotBackupItemAction should be invoked to backup volumesnapshots.
@@ -47,15 +53,68 @@ func (p *VolumeSnapshotDeleteItemAction) Execute(input *velero.DeleteItemActionE
 	}
 
 	p.Log.Infof("Deleting Volumesnapshot %s/%s", vs.Namespace, vs.Name)
-	_, snapClient, err := util.GetClients()
+	defer metrics.Export(p.Log)
+
+	ctx, cancel := util.NewResourceContext(input.Backup.Annotations, p.Log)
+	defer cancel()
+
+	// A dry run only reports what deleting the backup would do.
+	if util.IsDeletionDryRun(input.Backup) {
+		return p.reportDeletion(ctx, &vs, input.Backup)
+	}
+
+	// The legal hold annotation may be put on the volumesnapshot and volumesnapshotcontent in the cluster after the backup.
+	handle := vs.Annotations[util.VolumeSnapshotHandleAnnotation]
+	heldObjects := []*metav1.ObjectMeta{&vs.ObjectMeta}
+	liveVS, err := p.SnapshotClient.SnapshotV1().VolumeSnapshots(vs.Namespace).Get(ctx, vs.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		metrics.RecordDeleteFailure("volumesnapshots")
+		return errors.Wrapf(err, "failed to get volume snapshot %s/%s", vs.Namespace, vs.Name)
+	}
+	if err == nil {
+		heldObjects = append(heldObjects, &liveVS.ObjectMeta)
+	}
+
+	var heldVSC *snapshotv1api.VolumeSnapshotContent
+	if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil {
+		vsc, err := p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			metrics.RecordDeleteFailure("volumesnapshots")
+			return errors.Wrapf(err, "failed to get volumesnapshotcontent of volume snapshot %s/%s", vs.Namespace, vs.Name)
+		}
+		if err == nil {
+			heldVSC = vsc
+			if util.IsVolumeSnapshotContentProtected(vsc) {
+				return errors.Errorf("VolumeSnapshot %s/%s of backup %s is bound to VolumeSnapshotContent %s protected by label %s, refusing to delete them",
+					vs.Namespace, vs.Name, input.Backup.Name, vsc.Name, util.SnapshotProtectedLabel)
+			}
+			if h := backedUpSnapshotHandle(vsc); h != "" {
+				handle = h
+			}
+			heldObjects = append(heldObjects, &vsc.ObjectMeta)
+		}
+	}
+
+	holds, err := util.GetLegalHolds(ctx, input.Backup.Namespace, p.Client)
 	if err != nil {
-		return errors.WithStack(err)
+		metrics.RecordDeleteFailure("volumesnapshots")
+		return errors.Wrapf(err, "failed to check legal holds of volume snapshot %s/%s", vs.Namespace, vs.Name)
+	}
+	if reason := holds.HoldReason(input.Backup, handle, heldObjects...); reason != "" {
+		if heldVSC != nil {
+			if err := util.MarkHeldAtDeletion(ctx, heldVSC, input.Backup.Name, reason, p.SnapshotClient.SnapshotV1()); err != nil {
+				p.Log.WithError(err).Warn("Fail to mark the held volumesnapshotcontent for deletion once the hold is released")
+			}
+		}
+		return errors.Errorf("VolumeSnapshot %s/%s of backup %s and snapshot %s are under legal hold, refusing to delete them: %s",
+			vs.Namespace, vs.Name, input.Backup.Name, handle, reason)
 	}
 	if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil {
 		// we patch the DeletionPolicy of the volumesnapshotcontent to set it to Delete.
 		// This ensures that the volume snapshot in the storage provider is also deleted.
-		err := util.SetVolumeSnapshotContentDeletionPolicy(*vs.Status.BoundVolumeSnapshotContentName, snapClient.SnapshotV1())
+		err := util.SetVolumeSnapshotContentDeletionPolicy(ctx, *vs.Status.BoundVolumeSnapshotContentName, p.SnapshotClient.SnapshotV1())
 		if err != nil && !apierrors.IsNotFound(err) {
+			metrics.RecordDeleteFailure("volumesnapshots")
 			return errors.Wrapf(err, fmt.Sprintf("failed to patch DeletionPolicy of volume snapshot %s/%s", vs.Namespace, vs.Name))
 		}
 
@@ -63,9 +122,48 @@ func (p *VolumeSnapshotDeleteItemAction) Execute(input *velero.DeleteItemActionE
 			return nil
 		}
 	}
-	err = snapClient.SnapshotV1().VolumeSnapshots(vs.Namespace).Delete(context.TODO(), vs.Name, metav1.DeleteOptions{})
+	err = p.SnapshotClient.SnapshotV1().VolumeSnapshots(vs.Namespace).Delete(ctx, vs.Name, metav1.DeleteOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
+		metrics.RecordDeleteFailure("volumesnapshots")
 		return err
 	}
 	return nil
 }
+
+// reportDeletion adds what deleting the backup would do with the volumesnapshot to the deletion report of the backup,
+// without deleting anything.
+func (p *VolumeSnapshotDeleteItemAction) reportDeletion(ctx context.Context, vs *snapshotv1api.VolumeSnapshot, backup *velerov1api.Backup) error {
+	holds, err := util.GetLegalHolds(ctx, backup.Namespace, p.Client)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check legal holds of volume snapshot %s/%s", vs.Namespace, vs.Name)
+	}
+
+	report := util.DeletionReport{
+		VolumeSnapshot: vs.Namespace + "/" + vs.Name,
+		SnapshotHandle: vs.Annotations[util.VolumeSnapshotHandleAnnotation],
+	}
+	if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil {
+		report.VolumeSnapshotContent = *vs.Status.BoundVolumeSnapshotContentName
+		vsc, err := p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, report.VolumeSnapshotContent, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to get volumesnapshotcontent of volume snapshot %s/%s", vs.Namespace, vs.Name)
+		}
+		if err == nil {
+			if report, err = util.NewDeletionReport(ctx, backup, vsc, holds, p.SnapshotClient.SnapshotV1()); err != nil {
+				return errors.Wrapf(err, "failed to report deletion of volume snapshot %s/%s", vs.Namespace, vs.Name)
+			}
+			report.VolumeSnapshot = vs.Namespace + "/" + vs.Name
+		}
+	}
+	if report.KeptReason == "" {
+		if reason := holds.HoldReason(backup, report.SnapshotHandle, &vs.ObjectMeta); reason != "" {
+			report.KeptReason = "under legal hold: " + reason
+		}
+	}
+	if err := util.AddDeletionReport(ctx, backup, report, p.Client); err != nil {
+		return err
+	}
+
+	p.Log.Infof("Deletion of backup %s is a dry run, keep VolumeSnapshot %s/%s and snapshot %s", backup.Name, vs.Namespace, vs.Name, report.SnapshotHandle)
+	return nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package delete
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+func TestVolumeSnapshotExecuteGuards(t *testing.T) {
+	handle := "snap-1"
+	tests := []struct {
+		name           string
+		dryRun         bool
+		protected      bool
+		backupLabels   []string
+		vscAnnotations []string
+		holdEntries    string
+		expectedErr    string
+		expectDelete   bool
+		expectedKept   string
+	}{
+		{
+			name:         "volumesnapshot is deleted with its snapshot",
+			expectDelete: true,
+		},
+		{
+			name:   "dry run only reports the deletion",
+			dryRun: true,
+		},
+		{
+			name:           "dry run reports the legal hold",
+			dryRun:         true,
+			vscAnnotations: []string{util.LegalHoldLabel, "case-1234"},
+			expectedKept:   "under legal hold: vsc-1 is annotated velero.io/csi-legal-hold: case-1234",
+		},
+		{
+			name:        "volumesnapshot bound to a protected volumesnapshotcontent is kept",
+			protected:   true,
+			expectedErr: "VolumeSnapshot ns/vs-1 of backup backup is bound to VolumeSnapshotContent vsc-1 protected by label velero.io/csi-snapshot-protected, refusing to delete them",
+		},
+		{
+			name:         "volumesnapshot of a backup labeled for legal hold is kept",
+			backupLabels: []string{util.LegalHoldLabel, "true"},
+			expectedErr: "VolumeSnapshot ns/vs-1 of backup backup and snapshot snap-1 are under legal hold, refusing to delete them: " +
+				"backup backup is labeled velero.io/csi-legal-hold",
+		},
+		{
+			name:         "backup label turning the legal hold off",
+			backupLabels: []string{util.LegalHoldLabel, "false"},
+			expectDelete: true,
+		},
+		{
+			name:           "volumesnapshot bound to an annotated volumesnapshotcontent is kept",
+			vscAnnotations: []string{util.LegalHoldLabel, "case-1234"},
+			expectedErr: "VolumeSnapshot ns/vs-1 of backup backup and snapshot snap-1 are under legal hold, refusing to delete them: " +
+				"vsc-1 is annotated velero.io/csi-legal-hold: case-1234",
+		},
+		{
+			name:        "volumesnapshot of a backup in a hold configmap is kept",
+			holdEntries: "backup:other\nbackup:backup\n",
+			expectedErr: "VolumeSnapshot ns/vs-1 of backup backup and snapshot snap-1 are under legal hold, refusing to delete them: " +
+				"backup backup is held by legal-holds/case-1234",
+		},
+		{
+			name:        "snapshot in a hold configmap is kept",
+			holdEntries: "snapshot:snap-1",
+			expectedErr: "VolumeSnapshot ns/vs-1 of backup backup and snapshot snap-1 are under legal hold, refusing to delete them: " +
+				"snapshot snap-1 is held by legal-holds/case-1234",
+		},
+		{
+			name:         "hold configmap without the volumesnapshot",
+			holdEntries:  "backup:other\nsnapshot:snap-2",
+			expectDelete: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithLabels(tc.backupLabels...)).Result()
+			if tc.dryRun {
+				backup.Annotations = map[string]string{util.DeletionDryRunAnnotation: "true"}
+			}
+			vs := builder.ForVolumeSnapshot("ns", "vs-1").ObjectMeta(builder.WithLabels(velerov1api.BackupNameLabel, "backup")).
+				Status().BoundVolumeSnapshotContentName("vsc-1").Result()
+			vsc := builder.ForVolumeSnapshotContent("vsc-1").DeletionPolicy(snapshotv1api.VolumeSnapshotContentRetain).
+				Status(&snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle}).Result()
+			if len(tc.vscAnnotations) > 0 {
+				vsc.Annotations = map[string]string{tc.vscAnnotations[0]: tc.vscAnnotations[1]}
+			}
+			if tc.protected {
+				vsc.Labels = map[string]string{util.SnapshotProtectedLabel: "true"}
+			}
+			snapshotClient := snapshotfake.NewSimpleClientset(vs, vsc)
+			client := fake.NewSimpleClientset()
+			if tc.holdEntries != "" {
+				client = fake.NewSimpleClientset(builder.ForConfigMap("velero", "legal-holds").ObjectMeta(builder.WithLabels(util.LegalHoldLabel, "true")).
+					Data("case-1234", tc.holdEntries).Result())
+			}
+			vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vs)
+			require.NoError(t, err)
+
+			p := VolumeSnapshotDeleteItemAction{Log: logrus.New(), Client: client, SnapshotClient: snapshotClient}
+			err = p.Execute(&velero.DeleteItemActionExecuteInput{Item: &unstructured.Unstructured{Object: vsMap}, Backup: backup})
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			vsList, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectDelete, len(vsList.Items) == 0)
+			current, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-1", metav1.GetOptions{})
+			require.NoError(t, err)
+			if tc.expectDelete {
+				assert.Equal(t, snapshotv1api.VolumeSnapshotContentDelete, current.Spec.DeletionPolicy)
+			} else {
+				assert.Equal(t, snapshotv1api.VolumeSnapshotContentRetain, current.Spec.DeletionPolicy)
+			}
+			if tc.dryRun {
+				reports, err := util.GetDeletionReports(context.Background(), client, backup)
+				require.NoError(t, err)
+				assert.Equal(t, map[string]util.DeletionReport{
+					"vsc-1": {VolumeSnapshotContent: "vsc-1", VolumeSnapshot: "ns/vs-1", SnapshotHandle: handle, KeptReason: tc.expectedKept},
+				}, reports)
+			}
+			// Velero deletes the backup anyway, the held volumesnapshotcontent is marked to be deleted once the hold is released.
+			if strings.Contains(tc.expectedErr, "legal hold") {
+				assert.Equal(t, "backup", current.Annotations[util.HeldAtDeletionAnnotation])
+				// The hold outlives the backup.
+				assert.NotEmpty(t, current.Annotations[util.LegalHoldLabel])
+			} else {
+				assert.NotContains(t, current.Annotations, util.HeldAtDeletionAnnotation)
+			}
+		})
+	}
+}
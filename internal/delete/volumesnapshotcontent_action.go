@@ -4,20 +4,26 @@ import (
 	"context"
 	"fmt"
 
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/metrics"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 )
 
 // VolumeSnapshotContentDeleteItemAction is a restore item action plugin for Velero
 type VolumeSnapshotContentDeleteItemAction struct {
-	Log logrus.FieldLogger
+	Log            logrus.FieldLogger
+	Client         kubernetes.Interface
+	SnapshotClient snapshotterClientSet.Interface
 }
 
 // AppliesTo returns information indicating VolumeSnapshotContentRestoreItemAction action should be invoked while restoring
@@ -45,13 +51,48 @@ func (p *VolumeSnapshotContentDeleteItemAction) Execute(input *velero.DeleteItem
 	}
 
 	p.Log.Infof("Deleting VolumeSnapshotContent %s", snapCont.Name)
+	defer metrics.Export(p.Log)
 
-	_, snapClient, err := util.GetClients()
-	if err != nil {
-		return errors.WithStack(err)
+	ctx, cancel := util.NewResourceContext(input.Backup.Annotations, p.Log)
+	defer cancel()
+
+	// A dry run only reports what deleting the backup would do.
+	if util.IsDeletionDryRun(input.Backup) {
+		return p.reportDeletion(ctx, &snapCont, input.Backup)
 	}
 
-	err = util.SetVolumeSnapshotContentDeletionPolicy(snapCont.Name, snapClient.SnapshotV1())
+	// The protection label may be put on the volumesnapshotcontent in the cluster after the backup.
+	liveSnapCont, err := p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, snapCont.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		metrics.RecordDeleteFailure("volumesnapshotcontents")
+		return errors.Wrapf(err, "failed to get volumesnapshotcontent %s", snapCont.Name)
+	}
+	liveExists := err == nil
+
+	// A snapshot under legal hold is kept.
+	holds, err := util.GetLegalHolds(ctx, input.Backup.Namespace, p.Client)
+	if err != nil {
+		metrics.RecordDeleteFailure("volumesnapshotcontents")
+		return errors.Wrapf(err, "failed to check legal holds of volumesnapshotcontent %s", snapCont.Name)
+	}
+	var liveMeta *metav1.ObjectMeta
+	if liveExists {
+		liveMeta = &liveSnapCont.ObjectMeta
+	}
+	if reason := holds.HoldReason(input.Backup, backedUpSnapshotHandle(&snapCont), &snapCont.ObjectMeta, liveMeta); reason != "" {
+		if liveExists {
+			if err := util.MarkHeldAtDeletion(ctx, liveSnapCont, input.Backup.Name, reason, p.SnapshotClient.SnapshotV1()); err != nil {
+				p.Log.WithError(err).Warn("Fail to mark the held volumesnapshotcontent for deletion once the hold is released")
+			}
+		}
+		return errors.Errorf("VolumeSnapshotContent %s of backup %s and snapshot %s are under legal hold, refusing to delete them: %s",
+			snapCont.Name, input.Backup.Name, backedUpSnapshotHandle(&snapCont), reason)
+	}
+	if util.IsVolumeSnapshotContentProtected(&snapCont) || (liveExists && util.IsVolumeSnapshotContentProtected(liveSnapCont)) {
+		return errors.Errorf("VolumeSnapshotContent %s of backup %s is protected by label %s, refusing to delete it and snapshot %s",
+			snapCont.Name, input.Backup.Name, util.SnapshotProtectedLabel, backedUpSnapshotHandle(&snapCont))
+	}
+	err = util.SetVolumeSnapshotContentDeletionPolicy(ctx, snapCont.Name, p.SnapshotClient.SnapshotV1())
 	if err != nil {
 		// #4764: Leave a warning when VolumeSnapshotContent cannot be found for deletion.
 		// Manual deleting VolumeSnapshotContent can cause this.
@@ -60,18 +101,69 @@ func (p *VolumeSnapshotContentDeleteItemAction) Execute(input *velero.DeleteItem
 		// may not delete it correctly due to the snapshot represented by VolumeSnapshotContent
 		// already deleted on cloud provider.
 		if apierrors.IsNotFound(err) {
-			p.Log.Warnf("VolumeSnapshotContent %s of backup %s cannot be found. May leave orphan snapshot %s on cloud provider.",
-				snapCont.Name, input.Backup.Name, *snapCont.Status.SnapshotHandle)
+			if expiresAt, ok := snapCont.Annotations[util.SnapshotExpiresAtAnnotation]; ok {
+				p.Log.Infof("VolumeSnapshotContent %s of backup %s was removed by the snapshot retention expired at %s.",
+					snapCont.Name, input.Backup.Name, expiresAt)
+				return nil
+			}
+			// Delete the storage snapshot through a temporary volumesnapshotcontent recreated from the backed-up one.
+			handle := backedUpSnapshotHandle(&snapCont)
+			if handle == "" || snapCont.Spec.Driver == "" {
+				metrics.RecordOrphanSnapshot()
+				return errors.Errorf("VolumeSnapshotContent %s of backup %s cannot be found, and the backup has no snapshot handle and driver to delete its snapshot",
+					snapCont.Name, input.Backup.Name)
+			}
+			p.Log.Infof("VolumeSnapshotContent %s of backup %s cannot be found. Deleting snapshot %s through a temporary VolumeSnapshotContent.",
+				snapCont.Name, input.Backup.Name, handle)
+			if err := deleteSnapshotByHandle(ctx, &snapCont, handle, input.Backup, p.SnapshotClient.SnapshotV1(), p.Log); err != nil {
+				metrics.RecordOrphanSnapshot()
+				return errors.Wrapf(err, "failed to delete snapshot %s of VolumeSnapshotContent %s", handle, snapCont.Name)
+			}
 			return nil
 		}
+		metrics.RecordDeleteFailure("volumesnapshotcontents")
 		return errors.Wrapf(err, fmt.Sprintf("failed to set DeletionPolicy on volumesnapshotcontent %s. Skipping deletion", snapCont.Name))
 	}
 
-	err = snapClient.SnapshotV1().VolumeSnapshotContents().Delete(context.TODO(), snapCont.Name, metav1.DeleteOptions{})
+	err = p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Delete(ctx, snapCont.Name, metav1.DeleteOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
 		p.Log.Infof("VolumeSnapshotContent %s not found", snapCont.Name)
+		metrics.RecordDeleteFailure("volumesnapshotcontents")
+		return err
+	}
+
+	return nil
+}
+
+// reportDeletion adds what deleting the backup would do with the volumesnapshotcontent to the deletion report of the
+// backup, without deleting anything.
+func (p *VolumeSnapshotContentDeleteItemAction) reportDeletion(ctx context.Context, snapCont *snapshotv1api.VolumeSnapshotContent,
+	backup *velerov1api.Backup) error {
+	// The legal hold and protection may be put on the volumesnapshotcontent in the cluster after the backup.
+	vsc := snapCont
+	liveSnapCont, err := p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, snapCont.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get volumesnapshotcontent %s", snapCont.Name)
+	}
+	if err == nil {
+		vsc = liveSnapCont
+	}
+
+	holds, err := util.GetLegalHolds(ctx, backup.Namespace, p.Client)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check legal holds of volumesnapshotcontent %s", snapCont.Name)
+	}
+	report, err := util.NewDeletionReport(ctx, backup, vsc, holds, p.SnapshotClient.SnapshotV1())
+	if err != nil {
+		return errors.Wrapf(err, "failed to report deletion of volumesnapshotcontent %s", snapCont.Name)
+	}
+	if report.SnapshotHandle == "" {
+		report.SnapshotHandle = backedUpSnapshotHandle(snapCont)
+	}
+	if err := util.AddDeletionReport(ctx, backup, report, p.Client); err != nil {
 		return err
 	}
 
+	p.Log.Infof("Deletion of backup %s is a dry run, keep VolumeSnapshotContent %s and snapshot %s", backup.Name, snapCont.Name, report.SnapshotHandle)
 	return nil
 }
@@ -0,0 +1,217 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package delete
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+func TestVolumeSnapshotContentExecuteMissingContent(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		snapshotDeletionTimeout, snapshotDeletionInterval = timeout, interval
+	}(snapshotDeletionTimeout, snapshotDeletionInterval)
+	snapshotDeletionTimeout, snapshotDeletionInterval = 10*time.Millisecond, time.Millisecond
+
+	backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithUID("backup-uid")).Result()
+	handle := "handle"
+	otherHandle := "other-handle"
+	newVSC := func(handle *string) *snapshotv1api.VolumeSnapshotContent {
+		vsc := builder.ForVolumeSnapshotContent("vsc-1").Status(&snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: handle}).Result()
+		vsc.Spec.Driver = "driver"
+		vsc.Spec.VolumeSnapshotRef = corev1api.ObjectReference{Kind: "VolumeSnapshot", Namespace: "ns", Name: "vs-1", UID: "vs-uid"}
+		vsc.Labels = map[string]string{velerov1api.BackupNameLabel: "backup"}
+		vsc.Annotations = map[string]string{util.PrefixedSnapshotterSecretNameKey: "secret", util.PrefixedSnapshotterSecretNamespaceKey: "secrets"}
+		return vsc
+	}
+
+	tests := []struct {
+		name        string
+		vsc         *snapshotv1api.VolumeSnapshotContent
+		existing    *snapshotv1api.VolumeSnapshotContent
+		driverReady bool
+		expectedErr string
+	}{
+		{
+			name:        "snapshot is deleted through a temporary volumesnapshotcontent",
+			vsc:         newVSC(&handle),
+			driverReady: true,
+		},
+		{
+			name:        "CSI driver doesn't process the temporary volumesnapshotcontent",
+			vsc:         newVSC(&handle),
+			expectedErr: "failed to delete snapshot handle of VolumeSnapshotContent vsc-1: CSI driver driver didn't process temporary volumesnapshotcontent velero-delete-backup-uid-vsc-1 of snapshot handle: timed out waiting for the condition",
+		},
+		{
+			name: "volumesnapshotcontent with the temporary name for another snapshot is left alone",
+			vsc:  newVSC(&handle),
+			existing: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "velero-delete-backup-uid-vsc-1"},
+				Spec:       snapshotv1api.VolumeSnapshotContentSpec{Source: snapshotv1api.VolumeSnapshotContentSource{SnapshotHandle: &otherHandle}},
+			},
+			expectedErr: "failed to delete snapshot handle of VolumeSnapshotContent vsc-1: volumesnapshotcontent velero-delete-backup-uid-vsc-1 exists and doesn't delete snapshot handle",
+		},
+		{
+			name:        "no snapshot handle in the backup",
+			vsc:         newVSC(nil),
+			expectedErr: "VolumeSnapshotContent vsc-1 of backup backup cannot be found, and the backup has no snapshot handle and driver to delete its snapshot",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			snapshotClient := snapshotfake.NewSimpleClientset()
+			if tc.existing != nil {
+				_, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), tc.existing, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+			var created *snapshotv1api.VolumeSnapshotContent
+			snapshotClient.PrependReactor("create", "volumesnapshotcontents", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				created = action.(clienttesting.CreateAction).GetObject().(*snapshotv1api.VolumeSnapshotContent)
+				if tc.driverReady {
+					// The CSI driver finds the snapshot of the static volumesnapshotcontent.
+					created.Status = &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: created.Spec.Source.SnapshotHandle}
+				}
+				return false, nil, nil
+			})
+			vscMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc.vsc)
+			require.NoError(t, err)
+
+			client := fake.NewSimpleClientset()
+			p := VolumeSnapshotContentDeleteItemAction{Log: logrus.New(), Client: client, SnapshotClient: snapshotClient}
+			err = p.Execute(&velero.DeleteItemActionExecuteInput{Item: &unstructured.Unstructured{Object: vscMap}, Backup: backup})
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.NotNil(t, created)
+				assert.Equal(t, snapshotv1api.VolumeSnapshotContentDelete, created.Spec.DeletionPolicy)
+				assert.Equal(t, "handle", *created.Spec.Source.SnapshotHandle)
+				assert.Equal(t, "secret", created.Annotations[util.PrefixedSnapshotterSecretNameKey])
+				// The temporary volumesnapshotcontent doesn't refer to the volumesnapshot of the backed-up one.
+				assert.Equal(t, corev1api.ObjectReference{Kind: "VolumeSnapshot", Namespace: "velero", Name: "velero-delete-backup-uid-vsc-1"}, created.Spec.VolumeSnapshotRef)
+			}
+
+			// The temporary volumesnapshotcontent is never left behind, and one it didn't create is kept.
+			_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "velero-delete-backup-uid-vsc-1", metav1.GetOptions{})
+			assert.Equal(t, tc.existing == nil, apierrors.IsNotFound(err))
+		})
+	}
+}
+
+func TestVolumeSnapshotContentExecuteGuards(t *testing.T) {
+	handle := "handle"
+	tests := []struct {
+		name         string
+		protected    bool
+		held         bool
+		dryRun       bool
+		expectedErr  string
+		expectedKept string
+	}{
+		{
+			name: "volumesnapshotcontent under legal hold is kept",
+			held: true,
+			expectedErr: "VolumeSnapshotContent vsc-1 of backup backup and snapshot handle are under legal hold, refusing to delete them: " +
+				"vsc-1 is annotated velero.io/csi-legal-hold: case-1234",
+		},
+		{
+			name:        "protected volumesnapshotcontent is kept",
+			protected:   true,
+			expectedErr: "VolumeSnapshotContent vsc-1 of backup backup is protected by label velero.io/csi-snapshot-protected, refusing to delete it and snapshot handle",
+		},
+		{
+			name:   "dry run only reports the deletion",
+			dryRun: true,
+		},
+		{
+			name:         "dry run reports the protection",
+			dryRun:       true,
+			protected:    true,
+			expectedKept: "protected by label velero.io/csi-snapshot-protected",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := builder.ForBackup("velero", "backup").Result()
+			if tc.dryRun {
+				backup.Annotations = map[string]string{util.DeletionDryRunAnnotation: "true"}
+			}
+			backedUp := builder.ForVolumeSnapshotContent("vsc-1").DeletionPolicy(snapshotv1api.VolumeSnapshotContentRetain).
+				Status(&snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle}).Result()
+			backedUp.Labels = map[string]string{velerov1api.BackupNameLabel: "backup"}
+			// The protection label is put on the volumesnapshotcontent in the cluster after the backup.
+			live := backedUp.DeepCopy()
+			if tc.protected {
+				live.Labels[util.SnapshotProtectedLabel] = "true"
+			}
+			if tc.held {
+				live.Annotations = map[string]string{util.LegalHoldLabel: "case-1234"}
+			}
+			snapshotClient := snapshotfake.NewSimpleClientset(live)
+			vscMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(backedUp)
+			require.NoError(t, err)
+
+			client := fake.NewSimpleClientset()
+			p := VolumeSnapshotContentDeleteItemAction{Log: logrus.New(), Client: client, SnapshotClient: snapshotClient}
+			err = p.Execute(&velero.DeleteItemActionExecuteInput{Item: &unstructured.Unstructured{Object: vscMap}, Backup: backup})
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			vsc, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-1", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, snapshotv1api.VolumeSnapshotContentRetain, vsc.Spec.DeletionPolicy)
+			if tc.dryRun {
+				reports, err := util.GetDeletionReports(context.Background(), client, backup)
+				require.NoError(t, err)
+				assert.Equal(t, map[string]util.DeletionReport{
+					"vsc-1": {VolumeSnapshotContent: "vsc-1", SnapshotHandle: handle, KeptReason: tc.expectedKept},
+				}, reports)
+			}
+			if tc.held {
+				assert.Equal(t, "backup", vsc.Annotations[util.HeldAtDeletionAnnotation])
+				// The hold outlives the backup.
+				assert.NotEmpty(t, vsc.Annotations[util.LegalHoldLabel])
+			} else {
+				assert.NotContains(t, vsc.Annotations, util.HeldAtDeletionAnnotation)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics keeps the Prometheus metrics of the CSI snapshot lifecycle.
+//
+// Velero runs the plugin as short-lived processes that don't serve HTTP, so the
+// metrics can't be scraped. Instead, Export pushes them to a Prometheus pushgateway
+// or writes them to a file for the node exporter textfile collector. Each plugin process
+// replaces the metrics exported by the previous one under the same grouping key and file,
+// so nothing piles up. A new process starts its counters at zero, which Prometheus
+// handles as a counter reset.
+package metrics
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PushgatewayURLEnv is the environment variable holding the URL of the pushgateway the metrics are pushed to.
+	PushgatewayURLEnv = "VELERO_CSI_METRICS_PUSHGATEWAY_URL"
+	// TextfileDirEnv is the environment variable holding the directory the metrics files are written to.
+	TextfileDirEnv = "VELERO_CSI_METRICS_TEXTFILE_DIR"
+
+	pushgatewayJob = "velero-plugin-for-csi"
+	namespace      = "velero_csi"
+	// pushTimeout bounds a push, so an unreachable pushgateway doesn't hold up the item being processed.
+	pushTimeout = 5 * time.Second
+)
+
+// Reasons of snapshot failures.
+const (
+	FailureReasonCreate        = "create"
+	FailureReasonWaitContent   = "wait-content"
+	FailureReasonSnapshotError = "snapshot-error"
+	FailureReasonDataUpload    = "data-upload"
+	FailureReasonReadyTimeout  = "ready-timeout"
+)
+
+var (
+	registry   = prometheus.NewRegistry()
+	pushClient = &http.Client{Timeout: pushTimeout}
+
+	snapshotHandleSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "snapshot_handle_seconds",
+		Help:      "Time from the VolumeSnapshot creation until the storage snapshot handle is available.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	}, []string{"driver"})
+	snapshotReadySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "snapshot_ready_seconds",
+		Help:      "Time from the VolumeSnapshot creation until the VolumeSnapshot is ReadyToUse.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200},
+	}, []string{"driver"})
+	snapshotFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshot_failures_total",
+		Help:      "Number of CSI snapshots that failed.",
+	}, []string{"driver", "reason"})
+	snapshotWaitTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshot_wait_timeouts_total",
+		Help:      "Number of times waiting for a VolumeSnapshot to be bound to a VolumeSnapshotContent timed out.",
+	})
+	restoreVolumeSnapshotContents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "restore_volumesnapshotcontents_total",
+		Help:      "Number of VolumeSnapshotContents created on restore.",
+	}, []string{"driver"})
+	deleteFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delete_failures_total",
+		Help:      "Number of VolumeSnapshots and VolumeSnapshotContents that failed to be deleted with their backup.",
+	}, []string{"resource"})
+	orphanSnapshots = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orphan_snapshots_total",
+		Help:      "Number of deleted backups whose VolumeSnapshotContent was missing, possibly leaving the storage snapshot behind.",
+	})
+
+	exportLock sync.Mutex
+)
+
+func init() {
+	registry.MustRegister(
+		snapshotHandleSeconds,
+		snapshotReadySeconds,
+		snapshotFailures,
+		snapshotWaitTimeouts,
+		restoreVolumeSnapshotContents,
+		deleteFailures,
+		orphanSnapshots,
+	)
+}
+
+// ObserveSnapshotHandle records how long the storage snapshot handle of a snapshot took.
+func ObserveSnapshotHandle(driver string, latency time.Duration) {
+	snapshotHandleSeconds.WithLabelValues(driver).Observe(latency.Seconds())
+}
+
+// ObserveSnapshotReady records how long a snapshot took to be ReadyToUse.
+func ObserveSnapshotReady(driver string, latency time.Duration) {
+	snapshotReadySeconds.WithLabelValues(driver).Observe(latency.Seconds())
+}
+
+// RecordSnapshotFailure counts a failed snapshot.
+func RecordSnapshotFailure(driver, reason string) {
+	snapshotFailures.WithLabelValues(driver, reason).Inc()
+}
+
+// RecordSnapshotWaitTimeout counts a timeout waiting for a VolumeSnapshot to be bound.
+func RecordSnapshotWaitTimeout() {
+	snapshotWaitTimeouts.Inc()
+}
+
+// RecordRestoreVolumeSnapshotContent counts a VolumeSnapshotContent created on restore.
+func RecordRestoreVolumeSnapshotContent(driver string) {
+	restoreVolumeSnapshotContents.WithLabelValues(driver).Inc()
+}
+
+// RecordDeleteFailure counts a snapshot object that failed to be deleted with its backup.
+func RecordDeleteFailure(resource string) {
+	deleteFailures.WithLabelValues(resource).Inc()
+}
+
+// RecordOrphanSnapshot counts a deleted backup whose VolumeSnapshotContent was missing.
+func RecordOrphanSnapshot() {
+	orphanSnapshots.Inc()
+}
+
+// Export pushes the metrics to the pushgateway and writes them to the textfile directory,
+// as configured by the environment. Metrics are only informational, so failures are logged.
+func Export(log logrus.FieldLogger) {
+	exportLock.Lock()
+	defer exportLock.Unlock()
+
+	if url := os.Getenv(PushgatewayURLEnv); url != "" {
+		if err := pushToGateway(url); err != nil {
+			log.WithError(err).Warn("Fail to push metrics")
+		}
+	}
+	if dir := os.Getenv(TextfileDirEnv); dir != "" {
+		if err := writeTextfile(dir); err != nil {
+			log.WithError(err).Warn("Fail to write metrics file")
+		}
+	}
+}
+
+func pushToGateway(url string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "fail to get hostname")
+	}
+
+	pusher := push.New(url, pushgatewayJob).
+		Client(pushClient).
+		Gatherer(registry).
+		Grouping("instance", hostname)
+	if err := pusher.Push(); err != nil {
+		return errors.Wrapf(err, "fail to push metrics to %s", url)
+	}
+	return nil
+}
+
+func writeTextfile(dir string) error {
+	filename := filepath.Join(dir, pushgatewayJob+".prom")
+	if err := prometheus.WriteToTextfile(filename, registry); err != nil {
+		return errors.Wrapf(err, "fail to write metrics to %s", filename)
+	}
+	return nil
+}
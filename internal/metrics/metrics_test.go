@@ -0,0 +1,63 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	ObserveSnapshotHandle("hostpath.csi.k8s.io", 3*time.Second)
+	RecordSnapshotFailure("hostpath.csi.k8s.io", FailureReasonCreate)
+	RecordOrphanSnapshot()
+
+	var pushedPath, pushedBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pushedPath = r.URL.Path
+		pushedBody = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	t.Setenv(PushgatewayURLEnv, server.URL)
+	t.Setenv(TextfileDirEnv, dir)
+
+	Export(logrus.New())
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	assert.Equal(t, "/metrics/job/velero-plugin-for-csi/instance/"+hostname, pushedPath)
+	assert.NotEmpty(t, pushedBody)
+
+	content, err := os.ReadFile(filepath.Join(dir, "velero-plugin-for-csi.prom"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `velero_csi_snapshot_handle_seconds_count{driver="hostpath.csi.k8s.io"} 1`)
+	assert.Contains(t, string(content), `velero_csi_snapshot_failures_total{driver="hostpath.csi.k8s.io",reason="create"} 1`)
+	assert.Contains(t, string(content), `velero_csi_orphan_snapshots_total 1`)
+}
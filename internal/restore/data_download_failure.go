@@ -0,0 +1,197 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerov2alpha1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v2alpha1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+)
+
+// DataDownloadFailurePolicy decides what PVCRestoreItemAction does with the restored PVC when its
+// DataDownload fails or is canceled. The PVC waits for the PV created by the data mover through the
+// velero.io/dynamic-pv-restore selector, which nothing else satisfies.
+type DataDownloadFailurePolicy string
+
+const (
+	// DataDownloadFailurePolicyLeavePending leaves the PVC pending with its selector.
+	DataDownloadFailurePolicyLeavePending DataDownloadFailurePolicy = "leave-pending"
+	// DataDownloadFailurePolicyDelete deletes the PVC.
+	DataDownloadFailurePolicyDelete DataDownloadFailurePolicy = "delete"
+	// DataDownloadFailurePolicyProvisionEmpty recreates the PVC without the selector,
+	// so its storage class provisions an empty volume.
+	DataDownloadFailurePolicyProvisionEmpty DataDownloadFailurePolicy = "provision-empty"
+)
+
+// getDataDownloadFailurePolicy returns the policy for the PVC of a failed DataDownload. The PVC annotation
+// takes precedence over the restore annotation.
+func getDataDownloadFailurePolicy(pvc *corev1api.PersistentVolumeClaim, restore *velerov1api.Restore, log logrus.FieldLogger) DataDownloadFailurePolicy {
+	value, ok := pvc.Annotations[util.DataDownloadFailurePolicyAnnotation]
+	if !ok {
+		value = restore.Annotations[util.DataDownloadFailurePolicyAnnotation]
+	}
+
+	switch policy := DataDownloadFailurePolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "", DataDownloadFailurePolicyLeavePending:
+		return DataDownloadFailurePolicyLeavePending
+	case DataDownloadFailurePolicyDelete, DataDownloadFailurePolicyProvisionEmpty:
+		return policy
+	default:
+		log.Warnf("Unknown DataDownload failure policy %s. Fall back to %s.", value, DataDownloadFailurePolicyLeavePending)
+		return DataDownloadFailurePolicyLeavePending
+	}
+}
+
+var (
+	// dataDownloadCancelTimeout bounds the wait for node-agent to stop a canceled DataDownload.
+	dataDownloadCancelTimeout  = time.Minute
+	dataDownloadCancelInterval = 2 * time.Second
+)
+
+// waitForStoppedDataDownload waits until node-agent moved the canceled DataDownload to a final phase,
+// and returns it in that phase. Until then, node-agent may still bind a volume to the PVC.
+func waitForStoppedDataDownload(ctx context.Context, dataDownload *velerov2alpha1.DataDownload,
+	veleroClient veleroClientSet.Interface) (*velerov2alpha1.DataDownload, error) {
+	var stopped *velerov2alpha1.DataDownload
+	err := wait.PollImmediateWithContext(ctx, dataDownloadCancelInterval, dataDownloadCancelTimeout, func(ctx context.Context) (bool, error) {
+		current, err := veleroClient.VeleroV2alpha1().DataDownloads(dataDownload.Namespace).Get(ctx, dataDownload.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch current.Status.Phase {
+		case velerov2alpha1.DataDownloadPhaseCanceled, velerov2alpha1.DataDownloadPhaseFailed, velerov2alpha1.DataDownloadPhaseCompleted:
+			stopped = current
+			return true, nil
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout && ctx.Err() == nil {
+		return nil, errors.Errorf("DataDownload %s/%s is not stopped after %s", dataDownload.Namespace, dataDownload.Name, dataDownloadCancelTimeout)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to wait for DataDownload %s/%s to stop", dataDownload.Namespace, dataDownload.Name)
+	}
+	return stopped, nil
+}
+
+// handleFailedDataDownload applies the DataDownload failure policy to the PVC the DataDownload restores,
+// and returns what happened to the PVC. A PVC that is no longer pending with the data mover selector is left as is.
+func (p *PVCRestoreItemAction) handleFailedDataDownload(ctx context.Context, dataDownload *velerov2alpha1.DataDownload,
+	restore *velerov1api.Restore, log logrus.FieldLogger) (string, error) {
+	namespace, name := dataDownload.Spec.TargetVolume.Namespace, dataDownload.Spec.TargetVolume.PVC
+	pvc, err := p.Client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("PVC %s/%s no longer exists", namespace, name), nil
+		}
+		return "", errors.Wrapf(err, "fail to get PVC %s/%s", namespace, name)
+	}
+	if pvc.Status.Phase != corev1api.ClaimPending || pvc.DeletionTimestamp != nil ||
+		pvc.Spec.Selector == nil || pvc.Spec.Selector.MatchLabels[util.DynamicPVRestoreLabel] == "" {
+		return fmt.Sprintf("PVC %s/%s is left as is", namespace, name), nil
+	}
+
+	policy := getDataDownloadFailurePolicy(pvc, restore, log)
+	switch policy {
+	case DataDownloadFailurePolicyDelete:
+		if err := p.deletePendingPVC(ctx, pvc); err != nil {
+			return "", err
+		}
+		log.Infof("Deleted PVC %s/%s of the failed DataDownload", namespace, name)
+		return fmt.Sprintf("PVC %s/%s is deleted", namespace, name), nil
+	case DataDownloadFailurePolicyProvisionEmpty:
+		if err := p.recreatePVCWithoutDataMoverSelector(ctx, pvc, policy); err != nil {
+			return "", err
+		}
+		log.Infof("Recreated PVC %s/%s of the failed DataDownload to provision an empty volume", namespace, name)
+		return fmt.Sprintf("PVC %s/%s is recreated to provision an empty volume", namespace, name), nil
+	default:
+		return fmt.Sprintf("PVC %s/%s is left pending", namespace, name), nil
+	}
+}
+
+// deletePendingPVC deletes the PVC and waits until it is gone.
+func (p *PVCRestoreItemAction) deletePendingPVC(ctx context.Context, pvc *corev1api.PersistentVolumeClaim) error {
+	err := p.Client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &pvc.UID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to delete PVC %s/%s", pvc.Namespace, pvc.Name)
+	}
+
+	err = wait.PollImmediateWithContext(ctx, pvcReleaseInterval, pvcReleaseTimeout, func(ctx context.Context) (bool, error) {
+		current, err := p.Client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return current.UID != pvc.UID, nil
+	})
+	if err == wait.ErrWaitTimeout && ctx.Err() == nil {
+		return errors.Errorf("PVC %s/%s is still being deleted, it may be in use by a pod", pvc.Namespace, pvc.Name)
+	}
+	return errors.Wrapf(err, "fail to wait for PVC %s/%s to be deleted", pvc.Namespace, pvc.Name)
+}
+
+// recreatePVCWithoutDataMoverSelector replaces the PVC with one without the data mover selector.
+// The PVC spec is immutable, so the PVC is deleted before it is created again.
+func (p *PVCRestoreItemAction) recreatePVCWithoutDataMoverSelector(ctx context.Context, pvc *corev1api.PersistentVolumeClaim,
+	policy DataDownloadFailurePolicy) error {
+	recreated := &corev1api.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   pvc.Namespace,
+			Name:        pvc.Name,
+			Labels:      pvc.Labels,
+			Annotations: map[string]string{},
+		},
+		Spec: *pvc.Spec.DeepCopy(),
+	}
+	for k, v := range pvc.Annotations {
+		if k != AnnBindCompleted && k != AnnBoundByController {
+			recreated.Annotations[k] = v
+		}
+	}
+	recreated.Annotations[util.DataDownloadFailureActionAnnotation] = string(policy)
+	recreated.Spec.VolumeName = ""
+	delete(recreated.Spec.Selector.MatchLabels, util.DynamicPVRestoreLabel)
+	if len(recreated.Spec.Selector.MatchLabels) == 0 && len(recreated.Spec.Selector.MatchExpressions) == 0 {
+		recreated.Spec.Selector = nil
+	}
+
+	if err := p.deletePendingPVC(ctx, pvc); err != nil {
+		return err
+	}
+	if _, err := p.Client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(ctx, recreated, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "fail to recreate PVC %s/%s", pvc.Namespace, pvc.Name)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerov2alpha1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v2alpha1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+)
+
+func TestHandleFailedDataDownload(t *testing.T) {
+	dataDownload := builder.ForDataDownload("velero", "dd-1").TargetVolume(velerov2alpha1.TargetVolumeSpec{Namespace: "ns", PVC: "pvc"}).Result()
+	newPVC := func(phase corev1api.PersistentVolumeClaimPhase) *corev1api.PersistentVolumeClaim {
+		pvc := builder.ForPersistentVolumeClaim("ns", "pvc").ObjectMeta(builder.WithUID("old-uid"), builder.WithAnnotations(AnnBindCompleted, "yes", "app", "demo")).
+			StorageClass("sc").Phase(phase).Result()
+		pvc.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{util.DynamicPVRestoreLabel: "ns.pvc.abcde"}}
+		return pvc
+	}
+
+	tests := []struct {
+		name            string
+		policy          string
+		pvc             *corev1api.PersistentVolumeClaim
+		expectedAction  string
+		expectedDeleted bool
+		expectRecreated bool
+	}{
+		{
+			name:           "pending PVC is left pending by default",
+			pvc:            newPVC(corev1api.ClaimPending),
+			expectedAction: "PVC ns/pvc is left pending",
+		},
+		{
+			name:            "pending PVC is deleted",
+			policy:          "delete",
+			pvc:             newPVC(corev1api.ClaimPending),
+			expectedAction:  "PVC ns/pvc is deleted",
+			expectedDeleted: true,
+		},
+		{
+			name:            "pending PVC is recreated without the data mover selector",
+			policy:          "provision-empty",
+			pvc:             newPVC(corev1api.ClaimPending),
+			expectedAction:  "PVC ns/pvc is recreated to provision an empty volume",
+			expectRecreated: true,
+		},
+		{
+			name:           "bound PVC is left as is",
+			policy:         "delete",
+			pvc:            newPVC(corev1api.ClaimBound),
+			expectedAction: "PVC ns/pvc is left as is",
+		},
+		{
+			name:           "missing PVC",
+			policy:         "delete",
+			expectedAction: "PVC ns/pvc no longer exists",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			if tc.pvc != nil {
+				client = fake.NewSimpleClientset(tc.pvc)
+			}
+			pvcRIA := PVCRestoreItemAction{Log: logrus.New(), Client: client}
+			restore := builder.ForRestore("velero", "restore").ObjectMeta(builder.WithAnnotations(util.DataDownloadFailurePolicyAnnotation, tc.policy)).Result()
+
+			action, err := pvcRIA.handleFailedDataDownload(context.Background(), dataDownload, restore, logrus.New())
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedAction, action)
+
+			pvc, err := client.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+			if tc.expectedDeleted || tc.pvc == nil {
+				assert.True(t, apierrors.IsNotFound(err))
+				return
+			}
+			require.NoError(t, err)
+			if tc.expectRecreated {
+				assert.Nil(t, pvc.Spec.Selector)
+				assert.Equal(t, "sc", *pvc.Spec.StorageClassName)
+				assert.Equal(t, "demo", pvc.Annotations["app"])
+				assert.Equal(t, tc.policy, pvc.Annotations[util.DataDownloadFailureActionAnnotation])
+				assert.NotContains(t, pvc.Annotations, AnnBindCompleted)
+			} else {
+				assert.Equal(t, tc.pvc.Spec.Selector, pvc.Spec.Selector)
+			}
+		})
+	}
+}
+
+func TestCancelAppliesFailurePolicyOnceStopped(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		dataDownloadCancelTimeout, dataDownloadCancelInterval = timeout, interval
+	}(dataDownloadCancelTimeout, dataDownloadCancelInterval)
+	dataDownloadCancelTimeout, dataDownloadCancelInterval = 50*time.Millisecond, 10*time.Millisecond
+
+	tests := []struct {
+		name            string
+		stoppedPhase    velerov2alpha1.DataDownloadPhase
+		expectedDeleted bool
+	}{
+		{
+			name:            "canceled DataDownload",
+			stoppedPhase:    velerov2alpha1.DataDownloadPhaseCanceled,
+			expectedDeleted: true,
+		},
+		{
+			name:            "failed DataDownload",
+			stoppedPhase:    velerov2alpha1.DataDownloadPhaseFailed,
+			expectedDeleted: true,
+		},
+		{
+			name:         "DataDownload completed before it was canceled",
+			stoppedPhase: velerov2alpha1.DataDownloadPhaseCompleted,
+		},
+		{
+			name: "DataDownload not stopped by node-agent",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pvc := builder.ForPersistentVolumeClaim("ns", "pvc").ObjectMeta(builder.WithUID("uid")).Phase(corev1api.ClaimPending).Result()
+			pvc.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{util.DynamicPVRestoreLabel: "ns.pvc.abcde"}}
+			dataDownload := builder.ForDataDownload("velero", "dd-1").Phase(velerov2alpha1.DataDownloadPhaseInProgress).
+				TargetVolume(velerov2alpha1.TargetVolumeSpec{Namespace: "ns", PVC: "pvc"}).
+				ObjectMeta(builder.WithLabels(velerov1api.AsyncOperationIDLabel, "dd-1")).Result()
+			veleroClient := velerofake.NewSimpleClientset(dataDownload)
+			// node-agent stops the DataDownload only after it saw the cancel request.
+			veleroClient.PrependReactor("get", "datadownloads", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if tc.stoppedPhase == "" {
+					return false, nil, nil
+				}
+				current, err := veleroClient.Tracker().Get(action.GetResource(), "velero", "dd-1")
+				if err != nil {
+					return true, nil, err
+				}
+				stopped := current.(*velerov2alpha1.DataDownload).DeepCopy()
+				if stopped.Spec.Cancel {
+					stopped.Status.Phase = tc.stoppedPhase
+				}
+				return true, stopped, nil
+			})
+			client := fake.NewSimpleClientset(pvc)
+			pvcRIA := PVCRestoreItemAction{Log: logrus.New(), Client: client, VeleroClient: veleroClient}
+			restore := builder.ForRestore("velero", "restore").ObjectMeta(builder.WithAnnotations(util.DataDownloadFailurePolicyAnnotation, "delete")).Result()
+
+			require.NoError(t, pvcRIA.Cancel("dd-1", restore))
+
+			_, err := client.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+			if tc.expectedDeleted {
+				assert.True(t, apierrors.IsNotFound(err))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetDataDownloadFailurePolicy(t *testing.T) {
+	pvc := builder.ForPersistentVolumeClaim("ns", "pvc").ObjectMeta(builder.WithAnnotations(util.DataDownloadFailurePolicyAnnotation, "Delete")).Result()
+	restore := builder.ForRestore("velero", "restore").ObjectMeta(builder.WithAnnotations(util.DataDownloadFailurePolicyAnnotation, "provision-empty")).Result()
+
+	assert.Equal(t, DataDownloadFailurePolicyDelete, getDataDownloadFailurePolicy(pvc, restore, logrus.New()))
+	assert.Equal(t, DataDownloadFailurePolicyProvisionEmpty, getDataDownloadFailurePolicy(builder.ForPersistentVolumeClaim("ns", "pvc").Result(), restore, logrus.New()))
+	assert.Equal(t, DataDownloadFailurePolicyLeavePending, getDataDownloadFailurePolicy(builder.ForPersistentVolumeClaim("ns", "pvc").Result(),
+		builder.ForRestore("velero", "restore").ObjectMeta(builder.WithAnnotations(util.DataDownloadFailurePolicyAnnotation, "unknown")).Result(), logrus.New()))
+}
@@ -0,0 +1,139 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerov2alpha1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v2alpha1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+// getDataUploadResult returns the DataUploadResult of the PVC in the restore. The PVC is identified by its namespace
+// and name in the backup, so a PVC restored into a mapped namespace or under another name is found too.
+// Velero creates the DataUploadResult configmaps from the DataUploads in the backup. When there is none for the PVC,
+// the result is taken from the DataUpload recorded on the PVC. That fallback only works in the cluster that took the
+// backup, while its DataUpload still exists; in any other cluster the restore fails with the missing result.
+func getDataUploadResult(ctx context.Context, restore *velerov1api.Restore, backup *velerov1api.Backup, pvcFromBackup *corev1api.PersistentVolumeClaim,
+	kubeClient kubernetes.Interface, veleroClient veleroClientSet.Interface) (*velerov2alpha1.DataUploadResult, error) {
+	labelSelector := fmt.Sprintf("%s=%s,%s=%s",
+		velerov1api.RestoreUIDLabel, label.GetValidName(string(restore.UID)),
+		velerov1api.ResourceUsageLabel, label.GetValidName(string(velerov1api.VeleroResourceUsageDataUploadResult)),
+	)
+	cmList, err := kubeClient.CoreV1().ConfigMaps(restore.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error to get DataUpload result cm with labels %s", labelSelector)
+	}
+
+	pvcLabel := label.GetValidName(pvcFromBackup.Namespace + "." + pvcFromBackup.Name)
+	var matches []*corev1api.ConfigMap
+	for i := range cmList.Items {
+		if cmList.Items[i].Labels[velerov1api.PVCNamespaceNameLabel] == pvcLabel {
+			matches = append(matches, &cmList.Items[i])
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return parseDataUploadResult(matches[0], restore)
+	case 0:
+		result, dataUploadErr := getDataUploadResultFromDataUpload(ctx, backup, pvcFromBackup, veleroClient)
+		if dataUploadErr == nil {
+			return result, nil
+		}
+		return nil, errors.Errorf("no DataUpload result cm found for PVC %s/%s with labels %s,%s=%s (DataUpload result cms of the restore: %s), "+
+			"and no result from its DataUpload: %s", pvcFromBackup.Namespace, pvcFromBackup.Name, labelSelector, velerov1api.PVCNamespaceNameLabel,
+			pvcLabel, describeDataUploadResults(cmList.Items), dataUploadErr.Error())
+	default:
+		names := make([]string, 0, len(matches))
+		for _, cm := range matches {
+			names = append(names, cm.Name)
+		}
+		return nil, errors.Errorf("multiple DataUpload result cms found for PVC %s/%s: %s",
+			pvcFromBackup.Namespace, pvcFromBackup.Name, strings.Join(names, ", "))
+	}
+}
+
+func parseDataUploadResult(cm *corev1api.ConfigMap, restore *velerov1api.Restore) (*velerov2alpha1.DataUploadResult, error) {
+	jsonBytes, exist := cm.Data[string(restore.UID)]
+	if !exist {
+		return nil, errors.Errorf("no DataUpload result found with restore key %s in cm %s, restore %s", string(restore.UID), cm.Name, restore.Name)
+	}
+
+	result := velerov2alpha1.DataUploadResult{}
+	if err := json.Unmarshal([]byte(jsonBytes), &result); err != nil {
+		return nil, errors.Errorf("error to unmarshal DataUploadResult in cm %s, restore UID %s, restore name %s", cm.Name, string(restore.UID), restore.Name)
+	}
+	return &result, nil
+}
+
+// getDataUploadResultFromDataUpload builds the DataUploadResult of the PVC the way Velero does from the DataUpload
+// recorded on the PVC by the backup. It reads the live DataUpload CR rather than the backup's metadata, so it is a
+// same-cluster-only fallback, and it only accepts a DataUpload that still belongs to the backup.
+func getDataUploadResultFromDataUpload(ctx context.Context, backup *velerov1api.Backup, pvcFromBackup *corev1api.PersistentVolumeClaim,
+	veleroClient veleroClientSet.Interface) (*velerov2alpha1.DataUploadResult, error) {
+	namespace, name, found := strings.Cut(pvcFromBackup.Annotations[util.DataUploadNameAnnotation], "/")
+	if !found || namespace == "" || name == "" {
+		return nil, errors.Errorf("the PVC has no DataUpload name in the %s annotation", util.DataUploadNameAnnotation)
+	}
+
+	dataUpload, err := veleroClient.VeleroV2alpha1().DataUploads(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get DataUpload %s/%s", namespace, name)
+	}
+	if dataUpload.Status.Phase != velerov2alpha1.DataUploadPhaseCompleted || dataUpload.Status.SnapshotID == "" {
+		return nil, errors.Errorf("DataUpload %s/%s is in phase %s without a completed snapshot", namespace, name, dataUpload.Status.Phase)
+	}
+	if dataUpload.Labels[velerov1api.BackupNameLabel] != label.GetValidName(backup.Name) ||
+		dataUpload.Spec.BackupStorageLocation != backup.Spec.StorageLocation {
+		return nil, errors.Errorf("DataUpload %s/%s does not belong to backup %s", namespace, name, backup.Name)
+	}
+	if dataUpload.Spec.SourceNamespace != pvcFromBackup.Namespace || dataUpload.Spec.SourcePVC != pvcFromBackup.Name {
+		return nil, errors.Errorf("DataUpload %s/%s is for PVC %s/%s", namespace, name, dataUpload.Spec.SourceNamespace, dataUpload.Spec.SourcePVC)
+	}
+
+	return &velerov2alpha1.DataUploadResult{
+		BackupStorageLocation: backup.Spec.StorageLocation,
+		DataMover:             dataUpload.Spec.DataMover,
+		SnapshotID:            dataUpload.Status.SnapshotID,
+		SourceNamespace:       dataUpload.Spec.SourceNamespace,
+		DataMoverResult:       dataUpload.Status.DataMoverResult,
+	}, nil
+}
+
+// describeDataUploadResults lists the DataUpload result configmaps with the PVC each one is for.
+func describeDataUploadResults(cms []corev1api.ConfigMap) string {
+	if len(cms) == 0 {
+		return "none"
+	}
+	descriptions := make([]string, 0, len(cms))
+	for _, cm := range cms {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s=%s)", cm.Name, velerov1api.PVCNamespaceNameLabel, cm.Labels[velerov1api.PVCNamespaceNameLabel]))
+	}
+	return strings.Join(descriptions, ", ")
+}
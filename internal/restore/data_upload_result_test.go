@@ -0,0 +1,115 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerov2alpha1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v2alpha1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+func TestGetDataUploadResult(t *testing.T) {
+	newResultCM := func(name, pvcLabel string) *corev1api.ConfigMap {
+		return builder.ForConfigMap("velero", name).Data("uid", `{"snapshotID":"`+name+`"}`).ObjectMeta(builder.WithLabels(
+			velerov1api.RestoreUIDLabel, "uid",
+			velerov1api.PVCNamespaceNameLabel, pvcLabel,
+			velerov1api.ResourceUsageLabel, label.GetValidName(string(velerov1api.VeleroResourceUsageDataUploadResult)))).Result()
+	}
+	newCompletedDataUpload := func(backupName string) *velerov2alpha1.DataUpload {
+		return builder.ForDataUpload("velero", "du-1").Phase(velerov2alpha1.DataUploadPhaseCompleted).BackupStorageLocation("default").
+			SnapshotID("snap-1").DataMover("mover").SourceNamespace("source").SourcePVC("testPVC").
+			Labels(map[string]string{velerov1api.BackupNameLabel: backupName}).Result()
+	}
+
+	tests := []struct {
+		name               string
+		objects            []runtime.Object
+		dataUpload         *velerov2alpha1.DataUpload
+		expectedSnapshotID string
+		expectedErr        string
+	}{
+		{
+			name:               "result of the PVC in its backup namespace",
+			objects:            []runtime.Object{newResultCM("cm-1", "source.testPVC"), newResultCM("cm-2", "source.otherPVC")},
+			expectedSnapshotID: "cm-1",
+		},
+		{
+			name:               "result from the DataUpload without result configmap",
+			objects:            []runtime.Object{newResultCM("cm-2", "source.otherPVC")},
+			dataUpload:         newCompletedDataUpload("testBackup"),
+			expectedSnapshotID: "snap-1",
+		},
+		{
+			name:       "DataUpload of another backup is not used",
+			objects:    []runtime.Object{newResultCM("cm-2", "source.otherPVC")},
+			dataUpload: newCompletedDataUpload("otherBackup"),
+			expectedErr: "no DataUpload result cm found for PVC source/testPVC with labels velero.io/restore-uid=uid,velero.io/resource-usage=DataUpload," +
+				"velero.io/pvc-namespace-name=source.testPVC (DataUpload result cms of the restore: cm-2 (velero.io/pvc-namespace-name=source.otherPVC)), " +
+				"and no result from its DataUpload: DataUpload velero/du-1 does not belong to backup testBackup",
+		},
+		{
+			name:    "diagnostic lists the result configmaps of the restore",
+			objects: []runtime.Object{newResultCM("cm-2", "source.otherPVC")},
+			expectedErr: "no DataUpload result cm found for PVC source/testPVC with labels velero.io/restore-uid=uid,velero.io/resource-usage=DataUpload," +
+				"velero.io/pvc-namespace-name=source.testPVC (DataUpload result cms of the restore: cm-2 (velero.io/pvc-namespace-name=source.otherPVC)), " +
+				"and no result from its DataUpload: fail to get DataUpload velero/du-1: datauploads.velero.io \"du-1\" not found",
+		},
+		{
+			name:        "multiple results of the PVC",
+			objects:     []runtime.Object{newResultCM("cm-1", "source.testPVC"), newResultCM("cm-3", "source.testPVC")},
+			expectedErr: "multiple DataUpload result cms found for PVC source/testPVC: cm-1, cm-3",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			restore := builder.ForRestore("velero", "testRestore").ObjectMeta(builder.WithUID("uid")).Result()
+			backup := builder.ForBackup("velero", "testBackup").StorageLocation("default").Result()
+			pvcFromBackup := builder.ForPersistentVolumeClaim("source", "testPVC").
+				ObjectMeta(builder.WithAnnotations(util.DataUploadNameAnnotation, "velero/du-1")).Result()
+			veleroClient := velerofake.NewSimpleClientset()
+			if tc.dataUpload != nil {
+				veleroClient = velerofake.NewSimpleClientset(tc.dataUpload)
+			}
+
+			result, err := getDataUploadResult(context.Background(), restore, backup, pvcFromBackup, fake.NewSimpleClientset(tc.objects...), veleroClient)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedSnapshotID, result.SnapshotID)
+			if tc.dataUpload != nil {
+				assert.Equal(t, "default", result.BackupStorageLocation)
+				assert.Equal(t, "mover", result.DataMover)
+				assert.Equal(t, "source", result.SourceNamespace)
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerov2alpha1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v2alpha1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+// ExistingPVCPolicy decides how PVCRestoreItemAction handles a PVC that already exists
+// in the namespace it is restored into.
+type ExistingPVCPolicy string
+
+const (
+	// ExistingPVCPolicySkip leaves the existing PVC and its volume untouched.
+	ExistingPVCPolicySkip ExistingPVCPolicy = "skip"
+	// ExistingPVCPolicyReplace scales down the workloads using the existing PVC, deletes it
+	// and restores the PVC from the backup in its place.
+	ExistingPVCPolicyReplace ExistingPVCPolicy = "replace"
+	// ExistingPVCPolicyRenameOld keeps the existing volume bound to a PVC with a new name
+	// and restores the PVC from the backup under the original name.
+	ExistingPVCPolicyRenameOld ExistingPVCPolicy = "rename-old"
+)
+
+var (
+	// pvcReleaseTimeout bounds the wait for pods to stop mounting an existing PVC
+	// and for the existing PVC to be removed.
+	pvcReleaseTimeout  = 5 * time.Minute
+	pvcReleaseInterval = 2 * time.Second
+)
+
+// getExistingPVCPolicy returns the policy for an existing PVC. The PVC annotation takes
+// precedence over the restore annotation. Policies that modify the existing PVC are only
+// honoured when the restore's existingResourcePolicy is update.
+func getExistingPVCPolicy(pvc *corev1api.PersistentVolumeClaim, restore *velerov1api.Restore, log logrus.FieldLogger) ExistingPVCPolicy {
+	value, ok := pvc.Annotations[util.ExistingPVCPolicyAnnotation]
+	if !ok {
+		value = restore.Annotations[util.ExistingPVCPolicyAnnotation]
+	}
+
+	switch policy := ExistingPVCPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "", ExistingPVCPolicySkip:
+		return ExistingPVCPolicySkip
+	case ExistingPVCPolicyReplace, ExistingPVCPolicyRenameOld:
+		if restore.Spec.ExistingResourcePolicy != velerov1api.PolicyTypeUpdate {
+			log.Warnf("Existing PVC policy %s requires the restore's existingResourcePolicy to be %s. Fall back to %s.",
+				policy, velerov1api.PolicyTypeUpdate, ExistingPVCPolicySkip)
+			return ExistingPVCPolicySkip
+		}
+		return policy
+	default:
+		log.Warnf("Unknown existing PVC policy %s. Fall back to %s.", value, ExistingPVCPolicySkip)
+		return ExistingPVCPolicySkip
+	}
+}
+
+// handleExistingPVC applies a replace or rename-old policy to the existing PVC. When it
+// returns without error, the PVC name is free to be restored from the backup, and it returns
+// the workloads scaled down to release the existing PVC as <kind>/<name>.
+func (p *PVCRestoreItemAction) handleExistingPVC(ctx context.Context, existing *corev1api.PersistentVolumeClaim,
+	policy ExistingPVCPolicy, restore *velerov1api.Restore, log logrus.FieldLogger) ([]string, error) {
+	if existing.DeletionTimestamp != nil {
+		return nil, errors.Errorf("PVC %s/%s is already being deleted", existing.Namespace, existing.Name)
+	}
+	if policy == ExistingPVCPolicyRenameOld && existing.Spec.VolumeName == "" {
+		return nil, errors.Errorf("PVC %s/%s is not bound to a volume, there is no volume to keep", existing.Namespace, existing.Name)
+	}
+
+	workloads, err := p.releasePVC(ctx, existing, log)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy == ExistingPVCPolicyRenameOld {
+		err = p.renameExistingPVC(ctx, existing, restore, log)
+	} else {
+		log.Infof("Deleting existing PVC %s/%s to restore it from backup", existing.Namespace, existing.Name)
+		err = p.deletePVCAndWait(ctx, existing)
+	}
+	if err != nil {
+		return nil, utilerrors.NewAggregate([]error{err, p.scaleUpWorkloads(ctx, existing.Namespace, workloads, log)})
+	}
+
+	return workloads, nil
+}
+
+// renameExistingPVC moves the volume of the existing PVC to a new PVC named after the restore.
+// The PV reclaim policy is set to Retain first, so the volume survives the PVC deletion. The original
+// policy is recorded on the PV, and put back once the renamed PVC is bound to it.
+func (p *PVCRestoreItemAction) renameExistingPVC(ctx context.Context, existing *corev1api.PersistentVolumeClaim,
+	restore *velerov1api.Restore, log logrus.FieldLogger) error {
+	pv, err := p.Client.CoreV1().PersistentVolumes().Get(ctx, existing.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "fail to get PV %s of existing PVC %s/%s", existing.Spec.VolumeName, existing.Namespace, existing.Name)
+	}
+
+	// Keep the annotation from an earlier rename, it holds the policy before any restore touched the PV.
+	originalPolicy := corev1api.PersistentVolumeReclaimPolicy(pv.Annotations[util.OriginalReclaimPolicyAnnotation])
+	if pv.Spec.PersistentVolumeReclaimPolicy != corev1api.PersistentVolumeReclaimRetain {
+		originalPolicy = pv.Spec.PersistentVolumeReclaimPolicy
+		log.Infof("Setting reclaim policy of PV %s from %s to %s to keep it after renaming PVC %s/%s",
+			pv.Name, pv.Spec.PersistentVolumeReclaimPolicy, corev1api.PersistentVolumeReclaimRetain, existing.Namespace, existing.Name)
+		pb := []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%s"}},"spec":{"persistentVolumeReclaimPolicy":"%s"}}`,
+			util.OriginalReclaimPolicyAnnotation, originalPolicy, corev1api.PersistentVolumeReclaimRetain))
+		if _, err := p.Client.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, pb, metav1.PatchOptions{}); err != nil {
+			return errors.Wrapf(err, "fail to set reclaim policy of PV %s", pv.Name)
+		}
+	}
+
+	if err := p.deletePVCAndWait(ctx, existing); err != nil {
+		return err
+	}
+
+	renamed := &corev1api.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      label.GetValidName(existing.Name + "-old-" + restore.Name),
+			Namespace: existing.Namespace,
+			Labels:    existing.Labels,
+			Annotations: map[string]string{
+				util.RenamedFromPVCAnnotation: existing.Name,
+			},
+		},
+		Spec: corev1api.PersistentVolumeClaimSpec{
+			AccessModes:      existing.Spec.AccessModes,
+			Resources:        existing.Spec.Resources,
+			StorageClassName: existing.Spec.StorageClassName,
+			VolumeMode:       existing.Spec.VolumeMode,
+			VolumeName:       pv.Name,
+		},
+	}
+
+	// Point the released PV to the renamed PVC, so the PV controller binds them.
+	pb := []byte(fmt.Sprintf(`{"spec":{"claimRef":{"namespace":"%s","name":"%s","uid":null,"resourceVersion":null}}}`,
+		renamed.Namespace, renamed.Name))
+	if _, err := p.Client.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, pb, metav1.PatchOptions{}); err != nil {
+		return errors.Wrapf(err, "fail to update claimRef of PV %s", pv.Name)
+	}
+
+	if _, err := p.Client.CoreV1().PersistentVolumeClaims(renamed.Namespace).Create(ctx, renamed, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "fail to create PVC %s/%s for PV %s", renamed.Namespace, renamed.Name, pv.Name)
+	}
+	log.Infof("Renamed existing PVC %s/%s to %s, PV %s is kept", existing.Namespace, existing.Name, renamed.Name, pv.Name)
+
+	if originalPolicy != "" {
+		p.restoreReclaimPolicy(ctx, pv.Name, renamed, originalPolicy, log)
+	}
+	return nil
+}
+
+// restoreReclaimPolicy puts the original reclaim policy back on the PV once the renamed PVC is bound to it.
+// Until then the PV keeps Retain, and the original policy stays recorded in its annotation.
+func (p *PVCRestoreItemAction) restoreReclaimPolicy(ctx context.Context, pvName string, renamed *corev1api.PersistentVolumeClaim,
+	policy corev1api.PersistentVolumeReclaimPolicy, log logrus.FieldLogger) {
+	err := wait.PollImmediateWithContext(ctx, pvcReleaseInterval, pvcReleaseTimeout, func(ctx context.Context) (bool, error) {
+		pvc, err := p.Client.CoreV1().PersistentVolumeClaims(renamed.Namespace).Get(ctx, renamed.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return pvc.Status.Phase == corev1api.ClaimBound && pvc.Spec.VolumeName == pvName, nil
+	})
+	if err == nil {
+		pb := []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":null}},"spec":{"persistentVolumeReclaimPolicy":"%s"}}`,
+			util.OriginalReclaimPolicyAnnotation, policy))
+		_, err = p.Client.CoreV1().PersistentVolumes().Patch(ctx, pvName, types.MergePatchType, pb, metav1.PatchOptions{})
+	}
+	if err != nil {
+		log.WithError(err).Warnf("Fail to put reclaim policy %s back on PV %s of renamed PVC %s/%s, it keeps %s with annotation %s",
+			policy, pvName, renamed.Namespace, renamed.Name, corev1api.PersistentVolumeReclaimRetain, util.OriginalReclaimPolicyAnnotation)
+		return
+	}
+	log.Infof("Renamed PVC %s/%s is bound, reclaim policy of PV %s is back to %s", renamed.Namespace, renamed.Name, pvName, policy)
+}
+
+// releasePVC scales down the workloads of the pods mounting the PVC and waits until no
+// running pod mounts it anymore. It refuses to continue when a pod is not managed by a
+// Deployment, ReplicaSet or StatefulSet, or when pods are still running after the timeout.
+// The original replicas are recorded in an annotation on the scaled workload, and the
+// workloads are returned as <kind>/<name>. They are scaled up again when it fails.
+func (p *PVCRestoreItemAction) releasePVC(ctx context.Context, pvc *corev1api.PersistentVolumeClaim, log logrus.FieldLogger) ([]string, error) {
+	pods, err := p.activePodsUsingPVC(ctx, pvc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pods) == 0 {
+		return nil, nil
+	}
+
+	// Resolve all owners before scaling anything, so an unsupported pod doesn't leave
+	// the workloads half scaled down.
+	owners := map[string]bool{}
+	for _, pod := range pods {
+		kind, name, err := p.getScalableOwner(ctx, pod)
+		if err != nil {
+			return nil, err
+		}
+		owners[kind+"/"+name] = true
+	}
+	workloads := []string{}
+	for owner := range owners {
+		workloads = append(workloads, owner)
+	}
+	sort.Strings(workloads)
+
+	for i, workload := range workloads {
+		kind, name, _ := strings.Cut(workload, "/")
+		if err := p.scaleDown(ctx, pvc.Namespace, kind, name, log); err != nil {
+			return nil, utilerrors.NewAggregate([]error{err, p.scaleUpWorkloads(ctx, pvc.Namespace, workloads[:i], log)})
+		}
+	}
+
+	var remaining []corev1api.Pod
+	err = wait.PollImmediateWithContext(ctx, pvcReleaseInterval, pvcReleaseTimeout, func(ctx context.Context) (bool, error) {
+		remaining, err = p.activePodsUsingPVC(ctx, pvc)
+		if err != nil {
+			return false, err
+		}
+		return len(remaining) == 0, nil
+	})
+	if err == wait.ErrWaitTimeout && ctx.Err() == nil {
+		names := []string{}
+		for _, pod := range remaining {
+			names = append(names, pod.Name)
+		}
+		err = errors.Errorf("PVC %s/%s is still mounted by pods %s, refuse to modify it", pvc.Namespace, pvc.Name, strings.Join(names, ","))
+	}
+	if err != nil {
+		return nil, utilerrors.NewAggregate([]error{err, p.scaleUpWorkloads(ctx, pvc.Namespace, workloads, log)})
+	}
+
+	return workloads, nil
+}
+
+// activePodsUsingPVC returns the pods that mount the PVC and haven't terminated. The pods are listed from the API server
+// rather than the resource cache, which may miss a pod created since its last sync, and the PVC would be modified in use.
+func (p *PVCRestoreItemAction) activePodsUsingPVC(ctx context.Context, pvc *corev1api.PersistentVolumeClaim) ([]corev1api.Pod, error) {
+	pods, err := util.GetPodsUsingPVC(ctx, pvc.Namespace, pvc.Name, p.Client.CoreV1())
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to list pods using PVC %s/%s", pvc.Namespace, pvc.Name)
+	}
+
+	active := []corev1api.Pod{}
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1api.PodSucceeded || pod.Status.Phase == corev1api.PodFailed {
+			continue
+		}
+		active = append(active, pod)
+	}
+
+	return active, nil
+}
+
+// getScalableOwner returns the kind and name of the workload that controls the pod.
+func (p *PVCRestoreItemAction) getScalableOwner(ctx context.Context, pod corev1api.Pod) (string, string, error) {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return "", "", errors.Errorf("pod %s/%s is not managed by a controller and cannot be scaled down", pod.Namespace, pod.Name)
+	}
+
+	switch owner.Kind {
+	case "StatefulSet":
+		return owner.Kind, owner.Name, nil
+	case "ReplicaSet":
+		rs, err := p.Client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", "", errors.Wrapf(err, "fail to get ReplicaSet %s/%s", pod.Namespace, owner.Name)
+		}
+		if rsOwner := metav1.GetControllerOf(rs); rsOwner != nil && rsOwner.Kind == "Deployment" {
+			return rsOwner.Kind, rsOwner.Name, nil
+		}
+		return owner.Kind, owner.Name, nil
+	default:
+		return "", "", errors.Errorf("pod %s/%s is managed by %s %s which cannot be scaled down", pod.Namespace, pod.Name, owner.Kind, owner.Name)
+	}
+}
+
+// scalableWorkload is a Deployment, ReplicaSet or StatefulSet scaled down to release a PVC.
+type scalableWorkload struct {
+	annotations map[string]string
+	replicas    *int32
+	patch       func(pb []byte) error
+}
+
+func (p *PVCRestoreItemAction) getScalableWorkload(ctx context.Context, namespace, kind, name string) (*scalableWorkload, error) {
+	workload := &scalableWorkload{}
+	switch kind {
+	case "Deployment":
+		obj, err := p.Client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to get Deployment %s/%s", namespace, name)
+		}
+		workload.annotations, workload.replicas = obj.Annotations, obj.Spec.Replicas
+		workload.patch = func(pb []byte) error {
+			_, err := p.Client.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, pb, metav1.PatchOptions{})
+			return err
+		}
+	case "StatefulSet":
+		obj, err := p.Client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to get StatefulSet %s/%s", namespace, name)
+		}
+		workload.annotations, workload.replicas = obj.Annotations, obj.Spec.Replicas
+		workload.patch = func(pb []byte) error {
+			_, err := p.Client.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, pb, metav1.PatchOptions{})
+			return err
+		}
+	case "ReplicaSet":
+		obj, err := p.Client.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to get ReplicaSet %s/%s", namespace, name)
+		}
+		workload.annotations, workload.replicas = obj.Annotations, obj.Spec.Replicas
+		workload.patch = func(pb []byte) error {
+			_, err := p.Client.AppsV1().ReplicaSets(namespace).Patch(ctx, name, types.MergePatchType, pb, metav1.PatchOptions{})
+			return err
+		}
+	default:
+		return nil, errors.Errorf("cannot scale %s %s/%s", kind, namespace, name)
+	}
+	return workload, nil
+}
+
+func (p *PVCRestoreItemAction) scaleDown(ctx context.Context, namespace, kind, name string, log logrus.FieldLogger) error {
+	workload, err := p.getScalableWorkload(ctx, namespace, kind, name)
+	if err != nil {
+		return err
+	}
+
+	if workload.replicas != nil && *workload.replicas == 0 {
+		return nil
+	}
+
+	original := int32(1)
+	if workload.replicas != nil {
+		original = *workload.replicas
+	}
+	// Keep the annotation from an earlier scale down, it holds the replicas before any restore touched the workload.
+	pb := []byte(`{"spec":{"replicas":0}}`)
+	if _, exists := workload.annotations[util.OriginalReplicasAnnotation]; !exists {
+		pb = []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%d"}},"spec":{"replicas":0}}`, util.OriginalReplicasAnnotation, original))
+	}
+
+	log.Infof("Scaling down %s %s/%s from %d replicas to release PVC", kind, namespace, name, original)
+	if err := workload.patch(pb); err != nil {
+		return errors.Wrapf(err, "fail to scale down %s %s/%s", kind, namespace, name)
+	}
+
+	return nil
+}
+
+// scaleUp restores the replicas recorded on a workload when it was scaled down. A workload without
+// the annotation was not scaled down by a restore and is left as is.
+func (p *PVCRestoreItemAction) scaleUp(ctx context.Context, namespace, kind, name string, log logrus.FieldLogger) error {
+	workload, err := p.getScalableWorkload(ctx, namespace, kind, name)
+	if err != nil {
+		return err
+	}
+
+	value, exists := workload.annotations[util.OriginalReplicasAnnotation]
+	if !exists {
+		return nil
+	}
+	replicas, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return errors.Wrapf(err, "fail to parse %s %s of %s %s/%s", util.OriginalReplicasAnnotation, value, kind, namespace, name)
+	}
+
+	log.Infof("Scaling up %s %s/%s to %d replicas", kind, namespace, name, replicas)
+	pb := []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":null}},"spec":{"replicas":%d}}`, util.OriginalReplicasAnnotation, replicas))
+	if err := workload.patch(pb); err != nil {
+		return errors.Wrapf(err, "fail to scale up %s %s/%s", kind, namespace, name)
+	}
+
+	return nil
+}
+
+// scaleUpWorkloads scales up the workloads given as <kind>/<name>. It carries on with the other workloads
+// when one fails, and returns the failures.
+func (p *PVCRestoreItemAction) scaleUpWorkloads(ctx context.Context, namespace string, workloads []string, log logrus.FieldLogger) error {
+	failures := []string{}
+	for _, workload := range workloads {
+		kind, name, _ := strings.Cut(workload, "/")
+		if err := p.scaleUp(ctx, namespace, kind, name, log); err != nil {
+			log.WithError(err).Warnf("Fail to scale up %s %s/%s, it is left scaled down with annotation %s",
+				kind, namespace, name, util.OriginalReplicasAnnotation)
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// releasedPVCOperationIDPrefix starts the operation ID returned for a PVC restored in place of an existing PVC
+// whose workloads were scaled down, when there is no DataDownload to wait for.
+const releasedPVCOperationIDPrefix = "csi-released-pvc/"
+
+func releasedPVCOperationID(namespace, name string) string {
+	return releasedPVCOperationIDPrefix + namespace + "/" + name
+}
+
+func parseReleasedPVCOperationID(operationID string) (string, string, bool) {
+	id := strings.TrimPrefix(operationID, releasedPVCOperationIDPrefix)
+	if id == operationID {
+		return "", "", false
+	}
+	namespace, name, ok := strings.Cut(id, "/")
+	return namespace, name, ok
+}
+
+// recordScaledDownWorkloads records the workloads scaled down to release the existing PVC on the restored PVC,
+// so they are scaled up once Velero created it. Without a DataDownload to wait for, an operation is returned for it.
+func recordScaledDownWorkloads(output *velero.RestoreItemActionExecuteOutput, existing *corev1api.PersistentVolumeClaim,
+	workloads []string) (*velero.RestoreItemActionExecuteOutput, error) {
+	if output.SkipRestore {
+		return nil, errors.Errorf("PVC %s/%s is not restored in place of the existing PVC", existing.Namespace, existing.Name)
+	}
+
+	item := &unstructured.Unstructured{Object: output.UpdatedItem.UnstructuredContent()}
+	annotations := item.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[util.ScaledDownWorkloadsAnnotation] = strings.Join(workloads, ",")
+	item.SetAnnotations(annotations)
+	output.UpdatedItem = item
+
+	if output.OperationID == "" {
+		output.OperationID = releasedPVCOperationID(existing.Namespace, existing.Name)
+	}
+	return output, nil
+}
+
+// scaleUpDataDownloadWorkloads scales up the workloads released for the PVC restored by the DataDownload.
+func (p *PVCRestoreItemAction) scaleUpDataDownloadWorkloads(ctx context.Context, dataDownload *velerov2alpha1.DataDownload, log logrus.FieldLogger) error {
+	namespace, name := dataDownload.Spec.TargetVolume.Namespace, dataDownload.Spec.TargetVolume.PVC
+	pvc, err := p.Client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "fail to get PVC %s/%s", namespace, name)
+	}
+	return p.scaleUpReleasedWorkloads(ctx, pvc, log)
+}
+
+// releasedPVCProgress scales up the workloads recorded on the restored PVC once Velero created it.
+func (p *PVCRestoreItemAction) releasedPVCProgress(ctx context.Context, namespace, name string, log logrus.FieldLogger) (velero.OperationProgress, error) {
+	progress := velero.OperationProgress{}
+	pvc, err := p.Client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		progress.Description = "Waiting for the PVC to be restored"
+		return progress, nil
+	}
+	if err != nil {
+		return progress, errors.Wrapf(err, "fail to get PVC %s/%s", namespace, name)
+	}
+
+	progress.Completed = true
+	progress.Description = "Workloads scaled up"
+	if err := p.scaleUpReleasedWorkloads(ctx, pvc, log); err != nil {
+		progress.Err = err.Error()
+	}
+	return progress, nil
+}
+
+// scaleUpReleasedWorkloads scales up the workloads recorded on the restored PVC and removes the record.
+func (p *PVCRestoreItemAction) scaleUpReleasedWorkloads(ctx context.Context, pvc *corev1api.PersistentVolumeClaim, log logrus.FieldLogger) error {
+	value := pvc.Annotations[util.ScaledDownWorkloadsAnnotation]
+	if value == "" {
+		return nil
+	}
+	if err := p.scaleUpWorkloads(ctx, pvc.Namespace, strings.Split(value, ","), log); err != nil {
+		return err
+	}
+
+	pb := []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":null}}}`, util.ScaledDownWorkloadsAnnotation))
+	if _, err := p.Client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, pb, metav1.PatchOptions{}); err != nil {
+		return errors.Wrapf(err, "fail to remove annotation %s of PVC %s/%s", util.ScaledDownWorkloadsAnnotation, pvc.Namespace, pvc.Name)
+	}
+	return nil
+}
+
+func (p *PVCRestoreItemAction) deletePVCAndWait(ctx context.Context, pvc *corev1api.PersistentVolumeClaim) error {
+	err := p.Client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to delete PVC %s/%s", pvc.Namespace, pvc.Name)
+	}
+
+	err = wait.PollImmediateWithContext(ctx, pvcReleaseInterval, pvcReleaseTimeout, func(ctx context.Context) (bool, error) {
+		_, err := p.Client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fail to wait for PVC %s/%s to be deleted", pvc.Namespace, pvc.Name)
+	}
+
+	return nil
+}
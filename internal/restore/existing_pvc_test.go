@@ -0,0 +1,252 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1api "k8s.io/api/apps/v1"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+func TestGetExistingPVCPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		pvc      *corev1api.PersistentVolumeClaim
+		restore  *velerov1api.Restore
+		expected ExistingPVCPolicy
+	}{
+		{
+			name:     "no annotation defaults to skip",
+			pvc:      builder.ForPersistentVolumeClaim("ns", "pvc").Result(),
+			restore:  builder.ForRestore("velero", "restore").ExistingResourcePolicy("update").Result(),
+			expected: ExistingPVCPolicySkip,
+		},
+		{
+			name:     "restore annotation is used",
+			pvc:      builder.ForPersistentVolumeClaim("ns", "pvc").Result(),
+			restore:  builder.ForRestore("velero", "restore").ExistingResourcePolicy("update").ObjectMeta(builder.WithAnnotations(util.ExistingPVCPolicyAnnotation, "replace")).Result(),
+			expected: ExistingPVCPolicyReplace,
+		},
+		{
+			name:     "PVC annotation takes precedence over restore annotation",
+			pvc:      builder.ForPersistentVolumeClaim("ns", "pvc").ObjectMeta(builder.WithAnnotations(util.ExistingPVCPolicyAnnotation, "rename-old")).Result(),
+			restore:  builder.ForRestore("velero", "restore").ExistingResourcePolicy("update").ObjectMeta(builder.WithAnnotations(util.ExistingPVCPolicyAnnotation, "replace")).Result(),
+			expected: ExistingPVCPolicyRenameOld,
+		},
+		{
+			name:     "replace without update existingResourcePolicy falls back to skip",
+			pvc:      builder.ForPersistentVolumeClaim("ns", "pvc").Result(),
+			restore:  builder.ForRestore("velero", "restore").ObjectMeta(builder.WithAnnotations(util.ExistingPVCPolicyAnnotation, "replace")).Result(),
+			expected: ExistingPVCPolicySkip,
+		},
+		{
+			name:     "unknown policy falls back to skip",
+			pvc:      builder.ForPersistentVolumeClaim("ns", "pvc").Result(),
+			restore:  builder.ForRestore("velero", "restore").ExistingResourcePolicy("update").ObjectMeta(builder.WithAnnotations(util.ExistingPVCPolicyAnnotation, "overwrite")).Result(),
+			expected: ExistingPVCPolicySkip,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, getExistingPVCPolicy(tc.pvc, tc.restore, logrus.New()))
+		})
+	}
+}
+
+func TestHandleExistingPVC(t *testing.T) {
+	pvcReleaseTimeout = 100 * time.Millisecond
+	pvcReleaseInterval = 10 * time.Millisecond
+
+	existingPVC := builder.ForPersistentVolumeClaim("ns", "pvc").VolumeName("pv").StorageClass("sc").Phase(corev1api.ClaimBound).Result()
+	pv := builder.ForPersistentVolume("pv").ReclaimPolicy(corev1api.PersistentVolumeReclaimDelete).Result()
+	volume := builder.ForVolume("data").PersistentVolumeClaimSource("pvc").Result()
+	bareRunningPod := builder.ForPod("ns", "bare").Volumes(volume).Result()
+	completedPod := builder.ForPod("ns", "job").Volumes(volume).Result()
+	completedPod.Status.Phase = corev1api.PodSucceeded
+	deploymentPod := builder.ForPod("ns", "app-123").Volumes(volume).
+		ObjectMeta(builder.WithOwnerReference([]metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app-rs", Controller: boolptr.True()}})).Result()
+	replicaSet := &appsv1api.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-rs",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "app", Controller: boolptr.True()}}}}
+	replicas := int32(3)
+	deployment := &appsv1api.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app"}, Spec: appsv1api.DeploymentSpec{Replicas: &replicas}}
+
+	tests := []struct {
+		name            string
+		policy          ExistingPVCPolicy
+		pvc             *corev1api.PersistentVolumeClaim
+		objs            []runtime.Object
+		expectedErr     string
+		expectPVCExists bool
+		expectedRenamed string
+		bindRenamed     bool
+		expectedPolicy  corev1api.PersistentVolumeReclaimPolicy
+	}{
+		{
+			name:   "replace deletes the existing PVC",
+			policy: ExistingPVCPolicyReplace,
+			objs:   []runtime.Object{pv, completedPod},
+		},
+		{
+			name:            "replace refuses when a bare pod mounts the PVC",
+			policy:          ExistingPVCPolicyReplace,
+			objs:            []runtime.Object{pv, bareRunningPod},
+			expectedErr:     "pod ns/bare is not managed by a controller and cannot be scaled down",
+			expectPVCExists: true,
+		},
+		{
+			name:            "replace scales the deployment back up when pods are still running",
+			policy:          ExistingPVCPolicyReplace,
+			objs:            []runtime.Object{pv, deploymentPod, replicaSet, deployment},
+			expectedErr:     "PVC ns/pvc is still mounted by pods app-123, refuse to modify it",
+			expectPVCExists: true,
+		},
+		{
+			name:            "rename-old keeps the volume under a new PVC",
+			policy:          ExistingPVCPolicyRenameOld,
+			objs:            []runtime.Object{pv},
+			expectedRenamed: "pvc-old-restore",
+			expectedPolicy:  corev1api.PersistentVolumeReclaimRetain,
+		},
+		{
+			name:            "rename-old puts the reclaim policy back once the new PVC is bound",
+			policy:          ExistingPVCPolicyRenameOld,
+			objs:            []runtime.Object{pv},
+			expectedRenamed: "pvc-old-restore",
+			bindRenamed:     true,
+			expectedPolicy:  corev1api.PersistentVolumeReclaimDelete,
+		},
+		{
+			name:            "rename-old refuses a PVC without volume",
+			policy:          ExistingPVCPolicyRenameOld,
+			pvc:             builder.ForPersistentVolumeClaim("ns", "pvc").StorageClass("sc").Phase(corev1api.ClaimPending).Result(),
+			expectedErr:     "PVC ns/pvc is not bound to a volume, there is no volume to keep",
+			expectPVCExists: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pvc := existingPVC
+			if tc.pvc != nil {
+				pvc = tc.pvc
+			}
+			client := fake.NewSimpleClientset(append(tc.objs, pvc)...)
+			if tc.bindRenamed {
+				// The PV controller binds the renamed PVC to the PV it is created for.
+				client.PrependReactor("create", "persistentvolumeclaims", func(action clienttesting.Action) (bool, runtime.Object, error) {
+					action.(clienttesting.CreateAction).GetObject().(*corev1api.PersistentVolumeClaim).Status.Phase = corev1api.ClaimBound
+					return false, nil, nil
+				})
+			}
+			pvcRIA := PVCRestoreItemAction{
+				Log:    logrus.New(),
+				Client: client,
+			}
+			restore := builder.ForRestore("velero", "restore").ExistingResourcePolicy("update").Result()
+
+			_, err := pvcRIA.handleExistingPVC(context.Background(), pvc, tc.policy, restore, pvcRIA.Log)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			_, err = pvcRIA.Client.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+			if tc.expectPVCExists {
+				require.NoError(t, err)
+			} else {
+				require.True(t, apierrors.IsNotFound(err))
+			}
+
+			if tc.expectedRenamed != "" {
+				renamed, err := pvcRIA.Client.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), tc.expectedRenamed, metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, "pv", renamed.Spec.VolumeName)
+				assert.Equal(t, "pvc", renamed.Annotations[util.RenamedFromPVCAnnotation])
+
+				updatedPV, err := pvcRIA.Client.CoreV1().PersistentVolumes().Get(context.Background(), "pv", metav1.GetOptions{})
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedPolicy, updatedPV.Spec.PersistentVolumeReclaimPolicy)
+				if tc.expectedPolicy == corev1api.PersistentVolumeReclaimRetain {
+					assert.Equal(t, string(corev1api.PersistentVolumeReclaimDelete), updatedPV.Annotations[util.OriginalReclaimPolicyAnnotation])
+				} else {
+					assert.NotContains(t, updatedPV.Annotations, util.OriginalReclaimPolicyAnnotation)
+				}
+				require.NotNil(t, updatedPV.Spec.ClaimRef)
+				assert.Equal(t, tc.expectedRenamed, updatedPV.Spec.ClaimRef.Name)
+			}
+
+			if deployment, err := pvcRIA.Client.AppsV1().Deployments("ns").Get(context.Background(), "app", metav1.GetOptions{}); err == nil {
+				assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+				assert.NotContains(t, deployment.Annotations, util.OriginalReplicasAnnotation)
+			}
+		})
+	}
+}
+
+func TestScaleUpReleasedWorkloads(t *testing.T) {
+	zero := int32(0)
+	scaled := &appsv1api.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app",
+		Annotations: map[string]string{util.OriginalReplicasAnnotation: "3"}}, Spec: appsv1api.DeploymentSpec{Replicas: &zero}}
+	stopped := &appsv1api.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "db"}, Spec: appsv1api.StatefulSetSpec{Replicas: &zero}}
+	pvc := builder.ForPersistentVolumeClaim("ns", "pvc").
+		ObjectMeta(builder.WithAnnotations(util.ScaledDownWorkloadsAnnotation, "Deployment/app,StatefulSet/db")).Result()
+
+	pvcRIA := PVCRestoreItemAction{
+		Log:    logrus.New(),
+		Client: fake.NewSimpleClientset(scaled, stopped, pvc),
+	}
+
+	progress, err := pvcRIA.releasedPVCProgress(context.Background(), "ns", "pvc", pvcRIA.Log)
+	require.NoError(t, err)
+	assert.True(t, progress.Completed)
+	assert.Empty(t, progress.Err)
+
+	deployment, err := pvcRIA.Client.AppsV1().Deployments("ns").Get(context.Background(), "app", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+	assert.NotContains(t, deployment.Annotations, util.OriginalReplicasAnnotation)
+
+	statefulSet, err := pvcRIA.Client.AppsV1().StatefulSets("ns").Get(context.Background(), "db", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), *statefulSet.Spec.Replicas)
+
+	restored, err := pvcRIA.Client.CoreV1().PersistentVolumeClaims("ns").Get(context.Background(), "pvc", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, restored.Annotations, util.ScaledDownWorkloadsAnnotation)
+
+	progress, err = pvcRIA.releasedPVCProgress(context.Background(), "ns", "missing", pvcRIA.Log)
+	require.NoError(t, err)
+	assert.False(t, progress.Completed)
+}
@@ -27,13 +27,17 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilrand "k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
@@ -63,6 +67,9 @@ type PVCRestoreItemAction struct {
 	Client         kubernetes.Interface
 	SnapshotClient snapshotterClientSet.Interface
 	VeleroClient   veleroClientSet.Interface
+	EventRecorder  record.EventRecorder
+	// Cache serves the pods using the PVCs and the storage classes. They are read from the API server when it is nil.
+	Cache *util.ResourceCache
 }
 
 // AppliesTo returns information indicating that the PVCRestoreItemAction should be run while restoring PVCs.
@@ -115,7 +122,7 @@ func setPVCStorageResourceRequest(pvc *corev1api.PersistentVolumeClaim, restoreS
 
 // Execute modifies the PVC's spec to use the volumesnapshot object as the data source ensuring that the newly provisioned volume
 // can be pre-populated with data from the volumesnapshot.
-func (p *PVCRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
+func (p *PVCRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (output *velero.RestoreItemActionExecuteOutput, err error) {
 	var pvc, pvcFromBackup corev1api.PersistentVolumeClaim
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), &pvc); err != nil {
 		return nil, errors.WithStack(err)
@@ -131,12 +138,66 @@ func (p *PVCRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInp
 	})
 	logger.Info("Starting PVCRestoreItemAction for PVC")
 
-	// If PVC already exists, returns early.
-	if p.isResourceExist(pvc, *input.Restore) {
-		logger.Warnf("PVC already exists. Skip restore this PVC.")
-		return &velero.RestoreItemActionExecuteOutput{
-			UpdatedItem: input.Item,
-		}, nil
+	if util.IsSnapshotsOnlyRestore(input.Restore) {
+		logger.Info("Skipping PVC in a snapshots-only restore")
+		return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
+	}
+
+	ctx, cancel := util.NewResourceContext(input.Restore.Annotations, logger)
+	defer cancel()
+
+	util.SweepSnapshotsOnce(ctx, input.Restore.Namespace, p.Client, p.SnapshotClient.SnapshotV1(), p.VeleroClient, p.Log)
+
+	// If PVC already exists, returns early unless the existing PVC policy asks to overwrite it.
+	existingPVC, err := p.getExistingPVC(ctx, pvc, *input.Restore)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	policy := ExistingPVCPolicySkip
+	if existingPVC != nil {
+		policy = getExistingPVCPolicy(&pvc, input.Restore, logger)
+		if policy == ExistingPVCPolicySkip {
+			logger.Warnf("PVC already exists. Skip restore this PVC.")
+			return &velero.RestoreItemActionExecuteOutput{
+				UpdatedItem: input.Item,
+			}, nil
+		}
+	}
+
+	// The existing PVC policy is applied once the restore source is validated, so a restore that cannot
+	// use the backup leaves the existing PVC and its volume untouched.
+	var workloads []string
+	applyExistingPVCPolicy := func() error {
+		if existingPVC == nil {
+			return nil
+		}
+		logger.Infof("PVC already exists. Apply existing PVC policy %s.", policy)
+		var err error
+		if workloads, err = p.handleExistingPVC(ctx, existingPVC, policy, input.Restore, logger); err != nil {
+			logger.Errorf("Fail to apply existing PVC policy %s: %s", policy, err.Error())
+			return errors.Wrapf(err, "fail to apply existing PVC policy %s", policy)
+		}
+		return nil
+	}
+	// The workloads are scaled up once the restored PVC is created, or right away when it is not restored.
+	defer func() {
+		if len(workloads) == 0 {
+			return
+		}
+		if err == nil {
+			output, err = recordScaledDownWorkloads(output, existingPVC, workloads)
+		}
+		if err != nil {
+			if scaleUpErr := p.scaleUpWorkloads(ctx, existingPVC.Namespace, workloads, logger); scaleUpErr != nil {
+				err = utilerrors.NewAggregate([]error{err, scaleUpErr})
+			}
+		}
+	}()
+	// Without a snapshot or data to restore from, the existing PVC is kept.
+	keepExistingPVC := func() {
+		if existingPVC != nil {
+			logger.Warnf("Nothing to restore the PVC from, the existing PVC is kept despite existing PVC policy %s.", policy)
+		}
 	}
 
 	removePVCAnnotations(&pvc,
@@ -152,15 +213,20 @@ func (p *PVCRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInp
 
 	// remove the volumesnapshot name annotation as well
 	// clean the DataUploadNameLabel for snapshot data mover case.
-	removePVCAnnotations(&pvc, []string{util.VolumeSnapshotLabel, util.DataUploadNameAnnotation})
+	removePVCAnnotations(&pvc, []string{util.VolumeSnapshotLabel, util.DataUploadNameAnnotation, util.ScaledDownWorkloadsAnnotation,
+		util.BackupDataMoverAnnotation, util.BackupDataMoverConfigAnnotation, util.LocalSnapshotContentAnnotation,
+		util.VolumeSnapshotHandleAnnotation, util.CSIDriverNameAnnotation})
 
 	if boolptr.IsSetToFalse(input.Restore.Spec.RestorePVs) {
 		logger.Info("Restore did not request for PVs to be restored from snapshot")
 		pvc.Spec.VolumeName = ""
 		pvc.Spec.DataSource = nil
 		pvc.Spec.DataSourceRef = nil
+		if err := applyExistingPVCPolicy(); err != nil {
+			return nil, err
+		}
 	} else {
-		backup, err := p.VeleroClient.VeleroV1().Backups(input.Restore.Namespace).Get(context.Background(),
+		backup, err := p.VeleroClient.VeleroV1().Backups(input.Restore.Namespace).Get(ctx,
 			input.Restore.Spec.BackupName, metav1.GetOptions{})
 		if err != nil {
 			logger.Error("Fail to get backup for restore.")
@@ -175,16 +241,60 @@ func (p *PVCRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInp
 			// so return early to let Velero tries to fall back to Velero native snapshot.
 			if _, ok := pvcFromBackup.Annotations[util.DataUploadNameAnnotation]; !ok {
 				logger.Warnf("PVC doesn't have a DataUpload for data mover. Return.")
+				keepExistingPVC()
 				return &velero.RestoreItemActionExecuteOutput{
 					UpdatedItem: input.Item,
 				}, nil
 			}
 
+			// The data is downloaded with the data mover and configuration it was uploaded with. In hybrid mode,
+			// the local snapshot is faster to restore from when it still exists, the data is only needed without it.
+			dataMover, dataMoverConfig, err := util.GetRecordedDataMover(&pvcFromBackup)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			dataUploadResult, resultErr := getDataUploadResult(ctx, input.Restore, backup, &pvcFromBackup, p.Client, p.VeleroClient)
+			if resultErr != nil {
+				resultErr = errors.Wrapf(resultErr, "fail get DataUploadResult for restore: %s", input.Restore.Name)
+				localVSC, err := util.GetLocalSnapshotContent(ctx, &pvcFromBackup, p.SnapshotClient.SnapshotV1())
+				if err != nil || localVSC == nil {
+					logger.Errorf("Fail to restore from DataUploadResult: %s", resultErr.Error())
+					util.RecordPVCEventf(p.EventRecorder, &pvc, input.Restore, corev1api.EventTypeWarning, util.EventReasonRestoreFailed,
+						"Failed to restore from the data uploaded by backup %s: %s", backup.Name, resultErr.Error())
+					return nil, errors.WithStack(resultErr)
+				}
+			}
+
+			if err := applyExistingPVCPolicy(); err != nil {
+				return nil, err
+			}
+
+			restored, err := p.restoreFromLocalSnapshot(ctx, &pvc, &pvcFromBackup, input.Restore, logger)
+			if err != nil {
+				logger.WithError(err).Warn("Fail to restore from the local snapshot, downloading the data instead")
+			} else if restored {
+				pvcMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pvc)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				return &velero.RestoreItemActionExecuteOutput{
+					UpdatedItem: &unstructured.Unstructured{Object: pvcMap},
+				}, nil
+			}
+			if resultErr != nil {
+				logger.Errorf("Fail to restore from DataUploadResult: %s", resultErr.Error())
+				util.RecordPVCEventf(p.EventRecorder, &pvc, input.Restore, corev1api.EventTypeWarning, util.EventReasonRestoreFailed,
+					"Failed to restore from the data uploaded by backup %s: %s", backup.Name, resultErr.Error())
+				return nil, errors.WithStack(resultErr)
+			}
+
 			operationID = label.GetValidName(string(velerov1api.AsyncOperationIDPrefixDataDownload) + string(input.Restore.UID) + "." + string(pvcFromBackup.UID))
-			dataDownload, err := restoreFromDataUploadResult(context.Background(), input.Restore, backup, &pvc,
-				operationID, pvcFromBackup.Namespace, p.Client, p.VeleroClient)
+			dataDownload, err := restoreFromDataUploadResult(ctx, input.Restore, backup, &pvc, operationID, dataUploadResult,
+				dataMover, dataMoverConfig, p.getNodeAffinity(ctx, &pvc, logger), p.VeleroClient, logger)
 			if err != nil {
 				logger.Errorf("Fail to restore from DataUploadResult: %s", err.Error())
+				util.RecordPVCEventf(p.EventRecorder, &pvc, input.Restore, corev1api.EventTypeWarning, util.EventReasonRestoreFailed,
+					"Failed to restore from the data uploaded by backup %s: %s", backup.Name, err.Error())
 				return nil, errors.WithStack(err)
 			}
 			logger.Infof("DataDownload %s/%s is created successfully.", dataDownload.Namespace, dataDownload.Name)
@@ -192,15 +302,30 @@ func (p *PVCRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInp
 			volumeSnapshotName, ok := pvcFromBackup.Annotations[util.VolumeSnapshotLabel]
 			if !ok {
 				logger.Info("Skipping PVCRestoreItemAction for PVC , PVC does not have a CSI volumesnapshot.")
+				keepExistingPVC()
 				// Make no change in the input PVC.
 				return &velero.RestoreItemActionExecuteOutput{
 					UpdatedItem: input.Item,
 				}, nil
 			}
-			if err := restoreFromVolumeSnapshot(&pvc, p.SnapshotClient, volumeSnapshotName, logger); err != nil {
+			if util.IsVolumeSnapshotExpired(backup, pvcFromBackup.Namespace, volumeSnapshotName) {
+				logger.Errorf("VolumeSnapshot %s was removed by the snapshot retention of backup %s.", volumeSnapshotName, backup.Name)
+				util.RecordPVCEventf(p.EventRecorder, &pvc, input.Restore, corev1api.EventTypeWarning, util.EventReasonRestoreFailed,
+					"Volumesnapshot %s was removed by the snapshot retention of backup %s", volumeSnapshotName, backup.Name)
+				return nil, errors.Errorf("VolumeSnapshot %s/%s of PVC %s/%s was removed by the snapshot retention of backup %s",
+					pvcFromBackup.Namespace, volumeSnapshotName, pvc.Namespace, pvc.Name, backup.Name)
+			}
+			if err := restoreFromVolumeSnapshot(ctx, &pvc, p.SnapshotClient, volumeSnapshotName, logger); err != nil {
 				logger.Errorf("Failed to restore PVC from VolumeSnapshot.")
+				util.RecordPVCEventf(p.EventRecorder, &pvc, input.Restore, corev1api.EventTypeWarning, util.EventReasonRestoreFailed,
+					"Failed to restore from volumesnapshot %s: %s", volumeSnapshotName, err.Error())
 				return nil, errors.WithStack(err)
 			}
+			if err := applyExistingPVCPolicy(); err != nil {
+				return nil, err
+			}
+			util.RecordPVCEventf(p.EventRecorder, &pvc, input.Restore, corev1api.EventTypeNormal, util.EventReasonRestoredFromSnapshot,
+				"Restoring from volumesnapshot %s", volumeSnapshotName)
 		}
 	}
 
@@ -232,7 +357,14 @@ func (p *PVCRestoreItemAction) Progress(operationID string, restore *velerov1api
 		"Namespace":   restore.Namespace,
 	})
 
-	dataDownload, err := getDataDownload(context.Background(), restore.Namespace, operationID, p.VeleroClient)
+	ctx, cancel := util.NewResourceContext(restore.Annotations, logger)
+	defer cancel()
+
+	if namespace, name, ok := parseReleasedPVCOperationID(operationID); ok {
+		return p.releasedPVCProgress(ctx, namespace, name, logger)
+	}
+
+	dataDownload, err := getDataDownload(ctx, restore.Namespace, operationID, p.VeleroClient)
 	if err != nil {
 		logger.Errorf("fail to get DataDownload: %s", err.Error())
 		return progress, err
@@ -266,6 +398,38 @@ func (p *PVCRestoreItemAction) Progress(operationID string, restore *velerov1api
 		progress.Err = dataDownload.Status.Message
 	}
 
+	// The workloads released for the PVC are scaled up before the failure policy may delete the PVC recording them.
+	if progress.Completed {
+		if err := p.scaleUpDataDownloadWorkloads(ctx, dataDownload, logger); err != nil {
+			logger.WithError(err).Warn("Fail to scale up the workloads released for the PVC")
+			if progress.Err != "" {
+				progress.Err += "; "
+			}
+			progress.Err += "fail to scale up the workloads released for the PVC: " + err.Error()
+		}
+	}
+
+	// The PVC of a failed DataDownload waits for a PV that never comes, the failure policy decides what happens to it.
+	if progress.Completed && progress.Err != "" {
+		action, err := p.handleFailedDataDownload(ctx, dataDownload, restore, logger)
+		if err != nil {
+			logger.WithError(err).Warn("Fail to apply the DataDownload failure policy")
+			action = "fail to apply the DataDownload failure policy: " + err.Error()
+		}
+		progress.Err += "; " + action
+	}
+
+	if progress.Completed {
+		pvc := util.PVCEventObject(dataDownload.Spec.TargetVolume.Namespace, dataDownload.Spec.TargetVolume.PVC)
+		if progress.Err == "" {
+			util.RecordPVCEventf(p.EventRecorder, pvc, restore, corev1api.EventTypeNormal, util.EventReasonRestoredFromSnapshot,
+				"Restored from DataDownload %s/%s", dataDownload.Namespace, dataDownload.Name)
+		} else {
+			util.RecordPVCEventf(p.EventRecorder, pvc, restore, corev1api.EventTypeWarning, util.EventReasonRestoreFailed,
+				"DataDownload %s/%s failed: %s", dataDownload.Namespace, dataDownload.Name, progress.Err)
+		}
+	}
+
 	return progress, nil
 }
 
@@ -279,54 +443,56 @@ func (p *PVCRestoreItemAction) Cancel(operationID string, restore *velerov1api.R
 		"Namespace":   restore.Namespace,
 	})
 
-	dataDownload, err := getDataDownload(context.Background(), restore.Namespace, operationID, p.VeleroClient)
+	ctx, cancel := util.NewResourceContext(restore.Annotations, logger)
+	defer cancel()
+
+	if namespace, name, ok := parseReleasedPVCOperationID(operationID); ok {
+		pvc, err := p.Client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "fail to get PVC %s/%s to scale up its workloads", namespace, name)
+		}
+		return p.scaleUpReleasedWorkloads(ctx, pvc, logger)
+	}
+
+	dataDownload, err := getDataDownload(ctx, restore.Namespace, operationID, p.VeleroClient)
 	if err != nil {
 		logger.Errorf("fail to get DataDownload: %s", err.Error())
 		return err
 	}
 
-	err = cancelDataDownload(context.Background(), p.VeleroClient, dataDownload)
+	err = cancelDataDownload(ctx, p.VeleroClient, dataDownload)
 	if err != nil {
 		logger.Errorf("fail to cancel DataDownload %s: %s", dataDownload.Name, err.Error())
-	}
-	return err
-}
-
-func (p *PVCRestoreItemAction) AreAdditionalItemsReady(additionalItems []velero.ResourceIdentifier, restore *velerov1api.Restore) (bool, error) {
-	return true, nil
-}
-
-func getDataUploadResult(ctx context.Context, restore *velerov1api.Restore, pvc *corev1api.PersistentVolumeClaim,
-	sourceNamespace string, kubeClient kubernetes.Interface) (*velerov2alpha1.DataUploadResult, error) {
-	labelSelector := fmt.Sprintf("%s=%s,%s=%s,%s=%s", velerov1api.PVCNamespaceNameLabel, label.GetValidName(sourceNamespace+"."+pvc.Name),
-		velerov1api.RestoreUIDLabel, label.GetValidName(string(restore.UID)),
-		velerov1api.ResourceUsageLabel, label.GetValidName(string(velerov1api.VeleroResourceUsageDataUploadResult)),
-	)
-	cmList, err := kubeClient.CoreV1().ConfigMaps(restore.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
-	if err != nil {
-		return nil, errors.Wrapf(err, "error to get DataUpload result cm with labels %s", labelSelector)
+		return err
 	}
 
-	if len(cmList.Items) == 0 {
-		return nil, errors.Errorf("no DataUpload result cm found with labels %s", labelSelector)
+	// Velero doesn't check the progress of a canceled operation anymore, so the released workloads are scaled up here.
+	if err := p.scaleUpDataDownloadWorkloads(ctx, dataDownload, logger); err != nil {
+		logger.WithError(err).Warn("Fail to scale up the workloads released for the PVC")
 	}
 
-	if len(cmList.Items) > 1 {
-		return nil, errors.Errorf("multiple DataUpload result cms found with labels %s", labelSelector)
+	// Velero doesn't check the progress of a canceled operation anymore, so the failure policy is applied here,
+	// once node-agent stopped the DataDownload and can no longer bind a volume to the PVC.
+	stopped, err := waitForStoppedDataDownload(ctx, dataDownload, p.VeleroClient)
+	if err != nil {
+		logger.WithError(err).Warn("The DataDownload failure policy is not applied, the PVC is left as is")
+		return nil
 	}
-
-	jsonBytes, exist := cmList.Items[0].Data[string(restore.UID)]
-	if !exist {
-		return nil, errors.Errorf("no DataUpload result found with restore key %s, restore %s", string(restore.UID), restore.Name)
+	if stopped.Status.Phase == velerov2alpha1.DataDownloadPhaseCompleted {
+		logger.Infof("DataDownload %s completed before it was canceled", dataDownload.Name)
+		return nil
 	}
-
-	result := velerov2alpha1.DataUploadResult{}
-	err = json.Unmarshal([]byte(jsonBytes), &result)
+	action, err := p.handleFailedDataDownload(ctx, stopped, restore, logger)
 	if err != nil {
-		return nil, errors.Errorf("error to unmarshal DataUploadResult, restore UID %s, restore name %s", string(restore.UID), restore.Name)
+		logger.WithError(err).Warn("Fail to apply the DataDownload failure policy")
+		return nil
 	}
+	logger.Infof("DataDownload %s is %s, %s", dataDownload.Name, stopped.Status.Phase, action)
+	return nil
+}
 
-	return &result, nil
+func (p *PVCRestoreItemAction) AreAdditionalItemsReady(additionalItems []velero.ResourceIdentifier, restore *velerov1api.Restore) (bool, error) {
+	return true, nil
 }
 
 func getDataDownload(ctx context.Context, namespace string, operationID string, veleroClient veleroClientSet.Interface) (*velerov2alpha1.DataDownload, error) {
@@ -374,7 +540,7 @@ func cancelDataDownload(ctx context.Context, veleroClient veleroClientSet.Interf
 }
 
 func newDataDownload(restore *velerov1api.Restore, backup *velerov1api.Backup, dataUploadResult *velerov2alpha1.DataUploadResult,
-	pvc *corev1api.PersistentVolumeClaim, operationID string) *velerov2alpha1.DataDownload {
+	pvc *corev1api.PersistentVolumeClaim, operationID string, dataMoverConfig map[string]string) *velerov2alpha1.DataDownload {
 	dataDownload := &velerov2alpha1.DataDownload{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: velerov2alpha1.SchemeGroupVersion.String(),
@@ -410,13 +576,148 @@ func newDataDownload(restore *velerov1api.Restore, backup *velerov1api.Backup, d
 			OperationTimeout:      backup.Spec.CSISnapshotTimeout,
 		},
 	}
+	if len(dataMoverConfig) > 0 {
+		dataDownload.Spec.DataMoverConfig = dataMoverConfig
+	}
 
 	return dataDownload
 }
 
-func restoreFromVolumeSnapshot(pvc *corev1api.PersistentVolumeClaim, snapClient snapshotterClientSet.Interface,
+// localSnapshotName returns the name of the volumesnapshot and volumesnapshotcontent the restore creates to restore the PVC
+// from its local snapshot. Like the restored volumesnapshotcontents, it is derived from the UIDs of the restore and of the
+// backed-up PVC, so running the restore again finds the ones it created before.
+func localSnapshotName(restore *velerov1api.Restore, pvcFromBackup *corev1api.PersistentVolumeClaim) string {
+	source := string(pvcFromBackup.UID)
+	if source == "" {
+		source = label.GetValidName(pvcFromBackup.Namespace + "." + pvcFromBackup.Name)
+	}
+	return fmt.Sprintf("velero-%s-%s", restore.UID, source)
+}
+
+// restoreFromLocalSnapshot restores the PVC from the local snapshot kept by the backup in hybrid mode. It returns false
+// when the backup has no local snapshot, or it doesn't exist in the cluster, and the data must be downloaded instead.
+// The volumesnapshot and volumesnapshotcontent it creates are removed when it fails.
+func (p *PVCRestoreItemAction) restoreFromLocalSnapshot(ctx context.Context, pvc, pvcFromBackup *corev1api.PersistentVolumeClaim,
+	restore *velerov1api.Restore, logger logrus.FieldLogger) (restored bool, err error) {
+	localVSC, err := util.GetLocalSnapshotContent(ctx, pvcFromBackup, p.SnapshotClient.SnapshotV1())
+	if err != nil || localVSC == nil {
+		return false, err
+	}
+
+	// Bind a new static volumesnapshotcontent to a volumesnapshot the PVC is restored from. It is retained, the storage
+	// snapshot is removed with the backup.
+	name := localSnapshotName(restore, pvcFromBackup)
+	vsc := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				velerov1api.RestoreNameLabel: label.GetValidName(restore.Name),
+			},
+		},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{
+			DeletionPolicy:          snapshotv1api.VolumeSnapshotContentRetain,
+			Driver:                  localVSC.Spec.Driver,
+			VolumeSnapshotClassName: localVSC.Spec.VolumeSnapshotClassName,
+			VolumeSnapshotRef: corev1api.ObjectReference{
+				Kind:      util.VolumeSnapshotKindName,
+				Namespace: pvc.Namespace,
+				Name:      name,
+			},
+			Source: snapshotv1api.VolumeSnapshotContentSource{
+				SnapshotHandle: localVSC.Spec.Source.SnapshotHandle,
+			},
+		},
+	}
+	vsc, vscCreated, err := getOrCreateVolumeSnapshotContent(ctx, p.SnapshotClient, vsc)
+	if err != nil {
+		return false, errors.Wrap(err, "fail to create volumesnapshotcontent for the local snapshot")
+	}
+	vsCreated := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if vsCreated {
+			if err := p.SnapshotClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				logger.WithError(err).Warnf("Fail to delete volumesnapshot %s/%s", pvc.Namespace, name)
+			}
+		}
+		if vscCreated {
+			if err := p.SnapshotClient.SnapshotV1().VolumeSnapshotContents().Delete(ctx, vsc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				logger.WithError(err).Warnf("Fail to delete volumesnapshotcontent %s", vsc.Name)
+			}
+		}
+	}()
+
+	vs := &snapshotv1api.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pvc.Namespace,
+			Labels: map[string]string{
+				velerov1api.RestoreNameLabel: label.GetValidName(restore.Name),
+			},
+			Annotations: map[string]string{},
+		},
+		Spec: snapshotv1api.VolumeSnapshotSpec{
+			Source: snapshotv1api.VolumeSnapshotSource{
+				VolumeSnapshotContentName: &vsc.Name,
+			},
+			VolumeSnapshotClassName: localVSC.Spec.VolumeSnapshotClassName,
+		},
+	}
+	if restoreSize, ok := pvcFromBackup.Annotations[util.VolumeSnapshotRestoreSize]; ok {
+		vs.Annotations[util.VolumeSnapshotRestoreSize] = restoreSize
+	}
+	_, err = p.SnapshotClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Create(ctx, vs, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// A previous run of the restore created it, it is adopted when it uses the volumesnapshotcontent.
+		existing, getErr := p.SnapshotClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if getErr != nil {
+			return false, errors.Wrapf(getErr, "fail to get volumesnapshot %s/%s", pvc.Namespace, name)
+		}
+		if existing.Spec.Source.VolumeSnapshotContentName == nil || *existing.Spec.Source.VolumeSnapshotContentName != vsc.Name {
+			return false, errors.Errorf("volumesnapshot %s/%s already exists and doesn't use volumesnapshotcontent %s", pvc.Namespace, name, vsc.Name)
+		}
+		err = nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "fail to create volumesnapshot for the local snapshot")
+	} else {
+		vsCreated = true
+	}
+
+	if err = restoreFromVolumeSnapshot(ctx, pvc, p.SnapshotClient, name, logger); err != nil {
+		return false, err
+	}
+	logger.Infof("Restoring from the local snapshot of volumesnapshotcontent %s", localVSC.Name)
+	util.RecordPVCEventf(p.EventRecorder, pvc, restore, corev1api.EventTypeNormal, util.EventReasonRestoredFromSnapshot,
+		"Restoring from the local snapshot of volumesnapshotcontent %s", localVSC.Name)
+	return true, nil
+}
+
+// getNodeAffinity returns the nodes the PV provisioned for the restored PVC will be accessible from, according
+// to the allowed topologies of its storage class, or nil when they are not restricted.
+func (p *PVCRestoreItemAction) getNodeAffinity(ctx context.Context, pvc *corev1api.PersistentVolumeClaim, logger logrus.FieldLogger) *corev1api.NodeSelector {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return nil
+	}
+
+	var storageClass *storagev1api.StorageClass
+	var err error
+	if p.Cache == nil {
+		storageClass, err = p.Client.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	} else {
+		storageClass, err = p.Cache.GetStorageClass(ctx, *pvc.Spec.StorageClassName, logger)
+	}
+	if err != nil {
+		logger.WithError(err).Warnf("Fail to get storage class %s, the data mover pods are not restricted to its topology", *pvc.Spec.StorageClassName)
+		return nil
+	}
+	return util.GetStorageClassNodeAffinity(storageClass)
+}
+
+func restoreFromVolumeSnapshot(ctx context.Context, pvc *corev1api.PersistentVolumeClaim, snapClient snapshotterClientSet.Interface,
 	volumeSnapshotName string, logger logrus.FieldLogger) error {
-	vs, err := snapClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Get(context.TODO(), volumeSnapshotName, metav1.GetOptions{})
+	vs, err := snapClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Get(ctx, volumeSnapshotName, metav1.GetOptions{})
 	if err != nil {
 		return errors.Wrapf(err, fmt.Sprintf("Failed to get Volumesnapshot %s/%s to restore PVC %s/%s", pvc.Namespace, volumeSnapshotName, pvc.Namespace, pvc.Name))
 	}
@@ -442,11 +743,8 @@ func restoreFromVolumeSnapshot(pvc *corev1api.PersistentVolumeClaim, snapClient
 }
 
 func restoreFromDataUploadResult(ctx context.Context, restore *velerov1api.Restore, backup *velerov1api.Backup, pvc *corev1api.PersistentVolumeClaim,
-	operationID string, sourceNamespace string, kubeClient kubernetes.Interface, veleroClient veleroClientSet.Interface) (*velerov2alpha1.DataDownload, error) {
-	dataUploadResult, err := getDataUploadResult(ctx, restore, pvc, sourceNamespace, kubeClient)
-	if err != nil {
-		return nil, errors.Wrapf(err, "fail get DataUploadResult for restore: %s", restore.Name)
-	}
+	operationID string, dataUploadResult *velerov2alpha1.DataUploadResult, dataMover string, dataMoverConfig map[string]string,
+	nodeAffinity *corev1api.NodeSelector, veleroClient veleroClientSet.Interface, log logrus.FieldLogger) (*velerov2alpha1.DataDownload, error) {
 	pvc.Spec.VolumeName = ""
 	if pvc.Spec.Selector == nil {
 		pvc.Spec.Selector = &metav1.LabelSelector{}
@@ -456,8 +754,24 @@ func restoreFromDataUploadResult(ctx context.Context, restore *velerov1api.Resto
 	}
 	pvc.Spec.Selector.MatchLabels[util.DynamicPVRestoreLabel] = label.GetValidName(fmt.Sprintf("%s.%s.%s", pvc.Namespace, pvc.Name, utilrand.String(GenerateNameRandomLength)))
 
-	dataDownload := newDataDownload(restore, backup, dataUploadResult, pvc, operationID)
-	_, err = veleroClient.VeleroV2alpha1().DataDownloads(restore.Namespace).Create(ctx, dataDownload, metav1.CreateOptions{})
+	// The DataUploadResult names the data mover that uploaded the data. The one recorded on the PVC covers results without it.
+	if dataUploadResult.DataMover == "" {
+		dataUploadResult.DataMover = dataMover
+	}
+	if nodeAffinity != nil && util.IsBuiltInDataMover(dataUploadResult.DataMover) {
+		log.Warn("Velero's built-in data mover ignores the node affinity of the volume, its pods are placed by the scheduler")
+		nodeAffinity = nil
+	}
+	config := map[string]string{}
+	if err := util.SetDataMoverNodeAffinity(config, nodeAffinity); err != nil {
+		return nil, err
+	}
+	// The configuration set by the user wins over the one derived from the volume.
+	for k, v := range dataMoverConfig {
+		config[k] = v
+	}
+	dataDownload := newDataDownload(restore, backup, dataUploadResult, pvc, operationID, config)
+	_, err := veleroClient.VeleroV2alpha1().DataDownloads(restore.Namespace).Create(ctx, dataDownload, metav1.CreateOptions{})
 	if err != nil {
 		return nil, errors.Wrapf(err, "fail to create DataDownload")
 	}
@@ -465,14 +779,20 @@ func restoreFromDataUploadResult(ctx context.Context, restore *velerov1api.Resto
 	return dataDownload, nil
 }
 
-func (p *PVCRestoreItemAction) isResourceExist(pvc corev1api.PersistentVolumeClaim, restore velerov1api.Restore) bool {
+// getExistingPVC returns the PVC with the same name in the namespace the PVC is restored into,
+// or nil if there is none.
+func (p *PVCRestoreItemAction) getExistingPVC(ctx context.Context, pvc corev1api.PersistentVolumeClaim, restore velerov1api.Restore) (*corev1api.PersistentVolumeClaim, error) {
 	// get target namespace to restore into, if different from source namespace
 	targetNamespace := pvc.Namespace
 	if target, ok := restore.Spec.NamespaceMapping[pvc.Namespace]; ok {
 		targetNamespace = target
 	}
-	if _, err := p.Client.CoreV1().PersistentVolumeClaims(targetNamespace).Get(context.Background(), pvc.Name, metav1.GetOptions{}); err == nil {
-		return true
+	existing, err := p.Client.CoreV1().PersistentVolumeClaims(targetNamespace).Get(ctx, pvc.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "fail to get PVC %s/%s", targetNamespace, pvc.Name)
 	}
-	return false
+	return existing, nil
 }
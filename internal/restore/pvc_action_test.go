@@ -394,7 +394,7 @@ func TestProgress(t *testing.T) {
 			operationID: "testing",
 			expectedProgress: velero.OperationProgress{
 				Completed:      true,
-				Err:            "Testing error",
+				Err:            "Testing error; PVC / no longer exists",
 				NCompleted:     1000,
 				NTotal:         1000,
 				OperationUnits: "Bytes",
@@ -409,6 +409,7 @@ func TestProgress(t *testing.T) {
 		t.Run(tc.name, func(*testing.T) {
 			pvcRIA := PVCRestoreItemAction{
 				Log:          logrus.New(),
+				Client:       fake.NewSimpleClientset(),
 				VeleroClient: velerofake.NewSimpleClientset(),
 			}
 			if tc.dataDownload != nil {
@@ -429,6 +430,11 @@ func TestProgress(t *testing.T) {
 }
 
 func TestCancel(t *testing.T) {
+	defer func(timeout, interval time.Duration) {
+		dataDownloadCancelTimeout, dataDownloadCancelInterval = timeout, interval
+	}(dataDownloadCancelTimeout, dataDownloadCancelInterval)
+	dataDownloadCancelTimeout, dataDownloadCancelInterval = 50*time.Millisecond, 10*time.Millisecond
+
 	tests := []struct {
 		name                 string
 		restore              *velerov1api.Restore
@@ -501,6 +507,7 @@ func TestCancel(t *testing.T) {
 		t.Run(tc.name, func(*testing.T) {
 			pvcRIA := PVCRestoreItemAction{
 				Log:          logrus.New(),
+				Client:       fake.NewSimpleClientset(),
 				VeleroClient: velerofake.NewSimpleClientset(),
 			}
 			if tc.dataDownload != nil {
@@ -535,6 +542,7 @@ func TestExecute(t *testing.T) {
 		expectedDataDownload *velerov2alpha1.DataDownload
 		expectedPVC          *corev1api.PersistentVolumeClaim
 		preCreatePVC         bool
+		expectPVCKept        bool
 	}{
 		{
 			name:        "Don't restore PV",
@@ -567,6 +575,24 @@ func TestExecute(t *testing.T) {
 			vs:          builder.ForVolumeSnapshot("velero", "testVS").ObjectMeta(builder.WithAnnotations(util.VolumeSnapshotRestoreSize, "10Gi")).Result(),
 			expectedPVC: builder.ForPersistentVolumeClaim("velero", "testPVC").Result(),
 		},
+		{
+			name:        "VolumeSnapshot removed by snapshot retention",
+			backup:      builder.ForBackup("velero", "testBackup").ObjectMeta(builder.WithAnnotations(util.ExpiredVolumeSnapshotsAnnotation, "velero/testVS")).Result(),
+			restore:     builder.ForRestore("velero", "testRestore").Backup("testBackup").Result(),
+			pvc:         builder.ForPersistentVolumeClaim("velero", "testPVC").ObjectMeta(builder.WithAnnotations(util.VolumeSnapshotLabel, "testVS")).Result(),
+			vs:          builder.ForVolumeSnapshot("velero", "testVS").ObjectMeta(builder.WithAnnotations(util.VolumeSnapshotRestoreSize, "10Gi")).Result(),
+			expectedErr: "VolumeSnapshot velero/testVS of PVC velero/testPVC was removed by the snapshot retention of backup testBackup",
+		},
+		{
+			name:   "Existing PVC is kept when the VolumeSnapshot to replace it from was removed",
+			backup: builder.ForBackup("velero", "testBackup").ObjectMeta(builder.WithAnnotations(util.ExpiredVolumeSnapshotsAnnotation, "velero/testVS")).Result(),
+			restore: builder.ForRestore("velero", "testRestore").Backup("testBackup").ExistingResourcePolicy("update").
+				ObjectMeta(builder.WithAnnotations(util.ExistingPVCPolicyAnnotation, "replace")).Result(),
+			pvc:           builder.ForPersistentVolumeClaim("velero", "testPVC").ObjectMeta(builder.WithAnnotations(util.VolumeSnapshotLabel, "testVS")).Result(),
+			preCreatePVC:  true,
+			expectPVCKept: true,
+			expectedErr:   "VolumeSnapshot velero/testVS of PVC velero/testPVC was removed by the snapshot retention of backup testBackup",
+		},
 		{
 			name:        "Restore from VolumeSnapshot without volume-snapshot-name annotation",
 			backup:      builder.ForBackup("velero", "testBackup").Result(),
@@ -581,7 +607,9 @@ func TestExecute(t *testing.T) {
 			restore:     builder.ForRestore("velero", "testRestore").Backup("testBackup").Result(),
 			pvc:         builder.ForPersistentVolumeClaim("velero", "testPVC").ObjectMeta(builder.WithAnnotations(util.VolumeSnapshotRestoreSize, "10Gi", util.DataUploadNameAnnotation, "velero/")).Result(),
 			expectedPVC: builder.ForPersistentVolumeClaim("velero", "testPVC").Result(),
-			expectedErr: "fail get DataUploadResult for restore: testRestore: no DataUpload result cm found with labels velero.io/pvc-namespace-name=velero.testPVC,velero.io/restore-uid=,velero.io/resource-usage=DataUpload",
+			expectedErr: "fail get DataUploadResult for restore: testRestore: no DataUpload result cm found for PVC velero/testPVC with labels " +
+				"velero.io/restore-uid=,velero.io/resource-usage=DataUpload,velero.io/pvc-namespace-name=velero.testPVC (DataUpload result cms of the restore: none), " +
+				"and no result from its DataUpload: the PVC has no DataUpload name in the velero.io/data-upload-name annotation",
 		},
 		{
 			name:             "Restore from DataUploadResult",
@@ -665,6 +693,10 @@ func TestExecute(t *testing.T) {
 			}
 
 			output, err := pvcRIA.Execute(input)
+			if tc.expectPVCKept {
+				_, getErr := pvcRIA.Client.CoreV1().PersistentVolumeClaims(tc.pvc.Namespace).Get(context.Background(), tc.pvc.Name, metav1.GetOptions{})
+				require.NoError(t, getErr)
+			}
 			if tc.expectedErr != "" {
 				require.Equal(t, tc.expectedErr, err.Error())
 				return
@@ -693,3 +725,94 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestRestoreFromLocalSnapshot(t *testing.T) {
+	handle := "snap-handle"
+	localVSC := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "velero-local-uid",
+			Annotations: map[string]string{util.LocalSnapshotSourceAnnotation: "velero/testPVC"},
+		},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{
+			Driver: "driver",
+			Source: snapshotv1api.VolumeSnapshotContentSource{SnapshotHandle: &handle},
+		},
+	}
+	newPVCFromBackup := func(restoreSize string) *corev1api.PersistentVolumeClaim {
+		return builder.ForPersistentVolumeClaim("velero", "testPVC").ObjectMeta(builder.WithUID("pvc-uid"), builder.WithAnnotations(
+			util.LocalSnapshotContentAnnotation, localVSC.Name,
+			util.VolumeSnapshotHandleAnnotation, handle,
+			util.VolumeSnapshotRestoreSize, restoreSize,
+		)).Result()
+	}
+	restore := builder.ForRestore("velero", "testRestore").Backup("testBackup").ObjectMeta(builder.WithUID("restore-uid")).Result()
+
+	tests := []struct {
+		name            string
+		pvcFromBackup   *corev1api.PersistentVolumeClaim
+		existingVSC     *snapshotv1api.VolumeSnapshotContent
+		expectedErr     string
+		expectRestored  bool
+		expectVSCreated bool
+	}{
+		{
+			name:            "local snapshot exists",
+			pvcFromBackup:   newPVCFromBackup("10Gi"),
+			existingVSC:     localVSC,
+			expectRestored:  true,
+			expectVSCreated: true,
+		},
+		{
+			name:          "local snapshot is gone",
+			pvcFromBackup: newPVCFromBackup("10Gi"),
+		},
+		{
+			name:          "failed restore removes the created volumesnapshot and volumesnapshotcontent",
+			pvcFromBackup: newPVCFromBackup("invalid"),
+			existingVSC:   localVSC,
+			expectedErr:   "Failed to parse invalid from annotation on Volumesnapshot velero/velero-restore-uid-pvc-uid into restore size",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			snapshotClient := snapshotfake.NewSimpleClientset()
+			if tc.existingVSC != nil {
+				_, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), tc.existingVSC, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+			pvcRIA := PVCRestoreItemAction{
+				Log:            logrus.New(),
+				Client:         fake.NewSimpleClientset(),
+				SnapshotClient: snapshotClient,
+				VeleroClient:   velerofake.NewSimpleClientset(),
+			}
+			pvc := tc.pvcFromBackup.DeepCopy()
+
+			restored, err := pvcRIA.restoreFromLocalSnapshot(context.Background(), pvc, tc.pvcFromBackup, restore, logrus.New())
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expectRestored, restored)
+
+			vsList, err := snapshotClient.SnapshotV1().VolumeSnapshots("velero").List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			vscList, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			if !tc.expectVSCreated {
+				require.Empty(t, vsList.Items)
+				for _, vsc := range vscList.Items {
+					require.Equal(t, localVSC.Name, vsc.Name)
+				}
+				return
+			}
+			require.Len(t, vsList.Items, 1)
+			require.Equal(t, "velero-restore-uid-pvc-uid", vsList.Items[0].Name)
+			require.Len(t, vscList.Items, 2)
+			require.NotNil(t, pvc.Spec.DataSource)
+			require.Equal(t, vsList.Items[0].Name, pvc.Spec.DataSource.Name)
+		})
+	}
+}
@@ -18,16 +18,22 @@ package restore
 
 import (
 	"context"
+	"fmt"
 
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/metrics"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/label"
@@ -38,7 +44,10 @@ import (
 
 // VolumeSnapshotRestoreItemAction is a Velero restore item action plugin for VolumeSnapshots
 type VolumeSnapshotRestoreItemAction struct {
-	Log logrus.FieldLogger
+	Log            logrus.FieldLogger
+	Client         kubernetes.Interface
+	SnapshotClient snapshotterClientSet.Interface
+	VeleroClient   veleroClientSet.Interface
 }
 
 // AppliesTo returns information indicating that VolumeSnapshotRestoreItemAction should be invoked while restoring
@@ -60,11 +69,73 @@ func resetVolumeSnapshotAnnotation(vs *snapshotv1api.VolumeSnapshot) {
 	vs.ObjectMeta.Annotations[util.CSIVSCDeletionPolicy] = string(snapshotv1api.VolumeSnapshotContentRetain)
 }
 
+// getRestoredDeletionPolicy returns the DeletionPolicy of the volumesnapshotcontent restored for the volumesnapshot.
+// A volumesnapshot created outside Velero keeps the DeletionPolicy recorded at backup, any other one is restored
+// with Retain so deleting the restored volumesnapshot doesn't delete the storage snapshot of the backup.
+func getRestoredDeletionPolicy(vs *snapshotv1api.VolumeSnapshot) snapshotv1api.DeletionPolicy {
+	if vs.Annotations[util.ExternalSnapshotAnnotation] != "true" {
+		return snapshotv1api.VolumeSnapshotContentRetain
+	}
+	switch policy := snapshotv1api.DeletionPolicy(vs.Annotations[util.CSIVSCDeletionPolicy]); policy {
+	case snapshotv1api.VolumeSnapshotContentDelete, snapshotv1api.VolumeSnapshotContentRetain:
+		return policy
+	default:
+		return snapshotv1api.VolumeSnapshotContentRetain
+	}
+}
+
+// restoredVolumeSnapshotContentName returns the name of the volumesnapshotcontent the restore creates for the
+// volumesnapshot from the backup. The name is derived from the UIDs of the restore and of the backed-up volumesnapshot,
+// so running the restore again finds the volumesnapshotcontent it created before.
+func restoredVolumeSnapshotContentName(input *velero.RestoreItemActionExecuteInput) string {
+	item := input.ItemFromBackup
+	if item == nil {
+		item = input.Item
+	}
+	vsFromBackup := &unstructured.Unstructured{Object: item.UnstructuredContent()}
+	source := string(vsFromBackup.GetUID())
+	if source == "" {
+		source = label.GetValidName(vsFromBackup.GetNamespace() + "." + vsFromBackup.GetName())
+	}
+	return fmt.Sprintf("velero-%s-%s", input.Restore.UID, source)
+}
+
+// getOrCreateVolumeSnapshotContent creates the volumesnapshotcontent, or adopts the one with the same name when it
+// binds the same storage snapshot to the same volumesnapshot. It returns whether the volumesnapshotcontent was created.
+func getOrCreateVolumeSnapshotContent(ctx context.Context, snapshotClient snapshotterClientSet.Interface,
+	vsc *snapshotv1api.VolumeSnapshotContent) (*snapshotv1api.VolumeSnapshotContent, bool, error) {
+	existing, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, vsc.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		created, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Create(ctx, vsc, metav1.CreateOptions{})
+		if err == nil {
+			return created, true, nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return nil, false, errors.Wrapf(err, "failed to create volumesnapshotcontents %s", vsc.Name)
+		}
+		existing, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, vsc.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "failed to get volumesnapshotcontents %s", vsc.Name)
+	}
+
+	if existing.Spec.Driver != vsc.Spec.Driver || existing.Spec.Source.SnapshotHandle == nil ||
+		*existing.Spec.Source.SnapshotHandle != *vsc.Spec.Source.SnapshotHandle ||
+		existing.Spec.VolumeSnapshotRef.Namespace != vsc.Spec.VolumeSnapshotRef.Namespace ||
+		existing.Spec.VolumeSnapshotRef.Name != vsc.Spec.VolumeSnapshotRef.Name {
+		return nil, false, errors.Errorf("volumesnapshotcontents %s already exists and doesn't bind snapshot %s to volumesnapshot %s/%s",
+			vsc.Name, *vsc.Spec.Source.SnapshotHandle, vsc.Spec.VolumeSnapshotRef.Namespace, vsc.Spec.VolumeSnapshotRef.Name)
+	}
+	return existing, false, nil
+}
+
 // Execute uses the data such as CSI driver name, storage snapshot handle, snapshot deletion secret (if any) from the annotations
 // to recreate a volumesnapshotcontent object and statically bind the Volumesnapshot object being restored.
 func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
 	p.Log.Info("Starting VolumeSnapshotRestoreItemAction")
-	if boolptr.IsSetToFalse(input.Restore.Spec.RestorePVs) {
+	// A snapshots-only restore recreates the volumesnapshots to create PVCs from them later, without restoring the PVCs.
+	snapshotsOnly := util.IsSnapshotsOnlyRestore(input.Restore)
+	if boolptr.IsSetToFalse(input.Restore.Spec.RestorePVs) && !snapshotsOnly {
 		p.Log.Infof("Restore did not request for PVs to be restored %s/%s", input.Restore.Namespace, input.Restore.Name)
 		return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
 	}
@@ -74,18 +145,30 @@ func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActio
 		return &velero.RestoreItemActionExecuteOutput{}, errors.Wrapf(err, "failed to convert input.Item from unstructured")
 	}
 
+	ctx, cancel := util.NewResourceContext(input.Restore.Annotations, p.Log)
+	defer cancel()
+
+	util.SweepSnapshotsOnce(ctx, input.Restore.Namespace, p.Client, p.SnapshotClient.SnapshotV1(), p.VeleroClient, p.Log)
+
+	// The storage snapshot of a volumesnapshot removed by the snapshot retention is gone, don't bind a volumesnapshotcontent to it.
+	if _, expiresAtExists := vs.Annotations[util.SnapshotExpiresAtAnnotation]; expiresAtExists {
+		backup, err := p.VeleroClient.VeleroV1().Backups(input.Restore.Namespace).Get(ctx, input.Restore.Spec.BackupName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to get backup %s for restore", input.Restore.Spec.BackupName)
+		}
+		if util.IsVolumeSnapshotExpired(backup, vs.Namespace, vs.Name) {
+			p.Log.Infof("Volumesnapshot %s/%s was removed by the snapshot retention of backup %s, skip restoring it", vs.Namespace, vs.Name, backup.Name)
+			return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
+		}
+	}
+
 	// If cross-namespace restore is configured, change the namespace
 	// for VolumeSnapshot object to be restored
 	if val, ok := input.Restore.Spec.NamespaceMapping[vs.GetNamespace()]; ok {
 		vs.SetNamespace(val)
 	}
 
-	_, snapClient, err := util.GetClients()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	if !util.IsVolumeSnapshotExists(&vs, snapClient.SnapshotV1()) {
+	if !util.IsVolumeSnapshotExists(ctx, &vs, p.SnapshotClient.SnapshotV1()) {
 		snapHandle, exists := vs.Annotations[util.VolumeSnapshotHandleAnnotation]
 		if !exists {
 			return nil, errors.Errorf("Volumesnapshot %s/%s does not have a %s annotation", vs.Namespace, vs.Name, util.VolumeSnapshotHandleAnnotation)
@@ -96,19 +179,28 @@ func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActio
 			return nil, errors.Errorf("Volumesnapshot %s/%s does not have a %s annotation", vs.Namespace, vs.Name, util.CSIDriverNameAnnotation)
 		}
 
-		p.Log.Debugf("Set VolumeSnapshotContent %s/%s DeletionPolicy to Retain to make sure VS deletion in namespace will not delete Snapshot on cloud provider.",
-			vs.Namespace, vs.Name)
+		deletionPolicy := getRestoredDeletionPolicy(&vs)
+		if deletionPolicy == snapshotv1api.VolumeSnapshotContentRetain {
+			p.Log.Debugf("Set VolumeSnapshotContent %s/%s DeletionPolicy to Retain to make sure VS deletion in namespace will not delete Snapshot on cloud provider.",
+				vs.Namespace, vs.Name)
+		} else {
+			p.Log.Infof("Keep DeletionPolicy %s of volumesnapshot %s/%s created outside Velero", deletionPolicy, vs.Namespace, vs.Name)
+		}
+
+		vscLabels := map[string]string{
+			velerov1api.RestoreNameLabel: label.GetValidName(input.Restore.Name),
+		}
+		if snapshotsOnly {
+			vscLabels[util.SnapshotsOnlyRestoreUIDLabel] = string(input.Restore.UID)
+		}
 
-		// TODO: generated name will be like velero-velero-something. Fix that.
 		vsc := snapshotv1api.VolumeSnapshotContent{
 			ObjectMeta: metav1.ObjectMeta{
-				GenerateName: "velero-" + vs.Name + "-",
-				Labels: map[string]string{
-					velerov1api.RestoreNameLabel: label.GetValidName(input.Restore.Name),
-				},
+				Name:   restoredVolumeSnapshotContentName(input),
+				Labels: vscLabels,
 			},
 			Spec: snapshotv1api.VolumeSnapshotContentSpec{
-				DeletionPolicy: snapshotv1api.VolumeSnapshotContentRetain,
+				DeletionPolicy: deletionPolicy,
 				Driver:         csiDriverName,
 				VolumeSnapshotRef: core_v1.ObjectReference{
 					Kind:      util.VolumeSnapshotKindName,
@@ -121,23 +213,49 @@ func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActio
 			},
 		}
 
+		// The CSI driver deletes the storage snapshot of the volumesnapshotcontent with the secret the backup recorded.
+		// The secret is restored into the mapped namespace, and it has to exist for the snapshot to be deletable.
+		if util.IsVolumeSnapshotHasVSCDeleteSecret(&vs) {
+			secretName := vs.Annotations[util.CSIDeleteSnapshotSecretName]
+			secretNamespace := util.MapSnapshotSecretNamespace(vs.Annotations[util.CSIDeleteSnapshotSecretNamespace], input.Restore)
+			if err := util.CheckSnapshotSecretExists(ctx, p.Client, secretNamespace, secretName); err != nil {
+				return nil, errors.Wrapf(err, "fail to restore volumesnapshot %s/%s", vs.Namespace, vs.Name)
+			}
+			vsc.Annotations = map[string]string{
+				util.PrefixedSnapshotterSecretNameKey:      secretName,
+				util.PrefixedSnapshotterSecretNamespaceKey: secretNamespace,
+			}
+		}
 		// we create the volumesnapshotcontent here instead of relying on the restore flow because we want to statically
 		// bind this volumesnapshot with a volumesnapshotcontent that will be used as its source for pre-populating the
 		// volume that will be created as a result of the restore. To perform this static binding, a bi-didrectional link
 		// between the volumesnapshotcontent and volumesnapshot objects have to be setup.
 		// Further, it is disallowed to convert a dynamically created volumesnapshotcontent for static binding.
 		// See: https://github.com/kubernetes-csi/external-snapshotter/issues/274
-		vscupd, err := snapClient.SnapshotV1().VolumeSnapshotContents().Create(context.TODO(), &vsc, metav1.CreateOptions{})
+		vscupd, created, err := getOrCreateVolumeSnapshotContent(ctx, p.SnapshotClient, &vsc)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to create volumesnapshotcontents %s", vsc.GenerateName)
+			return nil, err
+		}
+		if created {
+			p.Log.Infof("Created VolumesnapshotContents %s with static binding to volumesnapshot %s/%s", vscupd.Name, vs.Namespace, vs.Name)
+			metrics.RecordRestoreVolumeSnapshotContent(csiDriverName)
+			metrics.Export(p.Log)
+		} else {
+			p.Log.Infof("Adopted VolumesnapshotContents %s created by a previous run of the restore for volumesnapshot %s/%s", vscupd.Name, vs.Namespace, vs.Name)
 		}
-		p.Log.Infof("Created VolumesnapshotContents %s with static binding to volumesnapshot %s/%s", vscupd, vs.Namespace, vs.Name)
 
 		// Reset Spec to convert the volumesnapshot from using the dyanamic volumesnapshotcontent to the static one.
 		resetVolumeSnapshotSpecForRestore(&vs, &vscupd.Name)
 
 		// Reset VolumeSnapshot annotation. By now, only change DeletionPolicy to Retain.
-		resetVolumeSnapshotAnnotation(&vs)
+		if deletionPolicy == snapshotv1api.VolumeSnapshotContentRetain {
+			resetVolumeSnapshotAnnotation(&vs)
+		}
+
+		// The volumesnapshots of a snapshots-only restore are removed once the restore is deleted.
+		if snapshotsOnly {
+			util.AddLabels(&vs.ObjectMeta, map[string]string{util.SnapshotsOnlyRestoreUIDLabel: string(input.Restore.UID)})
+		}
 	}
 
 	vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&vs)
@@ -17,12 +17,26 @@ limitations under the License.
 package restore
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 )
 
 var (
@@ -88,3 +102,250 @@ func TestResetVolumeSnapshotSpecForRestore(t *testing.T) {
 		})
 	}
 }
+
+func TestVolumeSnapshotExecuteSnapshotsOnly(t *testing.T) {
+	restore := builder.ForRestore("velero", "restore").RestorePVs(false).
+		ObjectMeta(builder.WithUID("restore-uid"), builder.WithAnnotations(util.SnapshotsOnlyRestoreAnnotation, "true")).Result()
+	vs := builder.ForVolumeSnapshot("ns", "vs-1").ObjectMeta(builder.WithAnnotations(
+		util.VolumeSnapshotHandleAnnotation, "handle", util.CSIDriverNameAnnotation, "driver")).Result()
+	vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vs)
+	require.NoError(t, err)
+
+	snapshotClient := snapshotfake.NewSimpleClientset()
+	action := VolumeSnapshotRestoreItemAction{
+		Log:            logrus.New(),
+		SnapshotClient: snapshotClient,
+		VeleroClient:   velerofake.NewSimpleClientset(restore),
+	}
+	output, err := action.Execute(&velero.RestoreItemActionExecuteInput{
+		Item:    &unstructured.Unstructured{Object: vsMap},
+		Restore: restore,
+	})
+	require.NoError(t, err)
+	require.False(t, output.SkipRestore)
+
+	restored := new(snapshotv1api.VolumeSnapshot)
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.UnstructuredContent(), restored))
+	assert.Equal(t, "restore-uid", restored.Labels[util.SnapshotsOnlyRestoreUIDLabel])
+
+	vscList, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, vscList.Items, 1)
+	assert.Equal(t, "restore-uid", vscList.Items[0].Labels[util.SnapshotsOnlyRestoreUIDLabel])
+	assert.Equal(t, snapshotv1api.VolumeSnapshotContentRetain, vscList.Items[0].Spec.DeletionPolicy)
+	assert.Equal(t, vscList.Items[0].Name, *restored.Spec.Source.VolumeSnapshotContentName)
+}
+
+func TestVolumeSnapshotExecuteDeletionPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		annotations    []string
+		expectedPolicy snapshotv1api.DeletionPolicy
+	}{
+		{
+			name:           "volumesnapshot created by the backup is restored with Retain",
+			annotations:    []string{util.CSIVSCDeletionPolicy, "Delete"},
+			expectedPolicy: snapshotv1api.VolumeSnapshotContentRetain,
+		},
+		{
+			name:           "volumesnapshot created outside Velero keeps its DeletionPolicy",
+			annotations:    []string{util.CSIVSCDeletionPolicy, "Delete", util.ExternalSnapshotAnnotation, "true"},
+			expectedPolicy: snapshotv1api.VolumeSnapshotContentDelete,
+		},
+		{
+			name:           "volumesnapshot created outside Velero without DeletionPolicy is restored with Retain",
+			annotations:    []string{util.ExternalSnapshotAnnotation, "true"},
+			expectedPolicy: snapshotv1api.VolumeSnapshotContentRetain,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			restore := builder.ForRestore("velero", "restore").Result()
+			annotations := append([]string{util.VolumeSnapshotHandleAnnotation, "handle", util.CSIDriverNameAnnotation, "driver"}, tc.annotations...)
+			vs := builder.ForVolumeSnapshot("ns", "vs-1").ObjectMeta(builder.WithAnnotations(annotations...)).Result()
+			vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vs)
+			require.NoError(t, err)
+
+			snapshotClient := snapshotfake.NewSimpleClientset()
+			action := VolumeSnapshotRestoreItemAction{
+				Log:            logrus.New(),
+				SnapshotClient: snapshotClient,
+				VeleroClient:   velerofake.NewSimpleClientset(restore),
+			}
+			output, err := action.Execute(&velero.RestoreItemActionExecuteInput{
+				Item:    &unstructured.Unstructured{Object: vsMap},
+				Restore: restore,
+			})
+			require.NoError(t, err)
+
+			restored := new(snapshotv1api.VolumeSnapshot)
+			require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.UnstructuredContent(), restored))
+			assert.Equal(t, "vs-1", restored.Name)
+			assert.Equal(t, string(tc.expectedPolicy), restored.Annotations[util.CSIVSCDeletionPolicy])
+
+			vscList, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, vscList.Items, 1)
+			assert.Equal(t, tc.expectedPolicy, vscList.Items[0].Spec.DeletionPolicy)
+		})
+	}
+}
+
+func TestVolumeSnapshotExecuteIdempotent(t *testing.T) {
+	restore := builder.ForRestore("velero", "restore").ObjectMeta(builder.WithUID("restore-uid")).Result()
+	vs := builder.ForVolumeSnapshot("ns", "vs-1").ObjectMeta(builder.WithUID("vs-uid"), builder.WithAnnotations(
+		util.VolumeSnapshotHandleAnnotation, "handle", util.CSIDriverNameAnnotation, "driver")).Result()
+	vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vs)
+	require.NoError(t, err)
+	handle, otherHandle := "handle", "other-handle"
+
+	tests := []struct {
+		name        string
+		existing    *snapshotv1api.VolumeSnapshotContent
+		expectedErr string
+	}{
+		{
+			name: "volumesnapshotcontent is created",
+		},
+		{
+			name: "volumesnapshotcontent of a previous run is adopted",
+			existing: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "velero-restore-uid-vs-uid"},
+				Spec: snapshotv1api.VolumeSnapshotContentSpec{
+					Driver:            "driver",
+					VolumeSnapshotRef: corev1api.ObjectReference{Namespace: "ns", Name: "vs-1"},
+					Source:            snapshotv1api.VolumeSnapshotContentSource{SnapshotHandle: &handle},
+				},
+			},
+		},
+		{
+			name: "volumesnapshotcontent of another snapshot",
+			existing: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "velero-restore-uid-vs-uid"},
+				Spec: snapshotv1api.VolumeSnapshotContentSpec{
+					Driver:            "driver",
+					VolumeSnapshotRef: corev1api.ObjectReference{Namespace: "ns", Name: "vs-1"},
+					Source:            snapshotv1api.VolumeSnapshotContentSource{SnapshotHandle: &otherHandle},
+				},
+			},
+			expectedErr: "volumesnapshotcontents velero-restore-uid-vs-uid already exists and doesn't bind snapshot handle to volumesnapshot ns/vs-1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			snapshotClient := snapshotfake.NewSimpleClientset()
+			if tc.existing != nil {
+				snapshotClient = snapshotfake.NewSimpleClientset(tc.existing)
+			}
+			action := VolumeSnapshotRestoreItemAction{
+				Log:            logrus.New(),
+				SnapshotClient: snapshotClient,
+				VeleroClient:   velerofake.NewSimpleClientset(restore),
+			}
+			input := &velero.RestoreItemActionExecuteInput{
+				Item:           &unstructured.Unstructured{Object: runtime.DeepCopyJSON(vsMap)},
+				ItemFromBackup: &unstructured.Unstructured{Object: runtime.DeepCopyJSON(vsMap)},
+				Restore:        restore,
+			}
+
+			output, err := action.Execute(input)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			restored := new(snapshotv1api.VolumeSnapshot)
+			require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.UnstructuredContent(), restored))
+			assert.Equal(t, "velero-restore-uid-vs-uid", *restored.Spec.Source.VolumeSnapshotContentName)
+
+			// The restore runs again after a partial failure.
+			input.Item = &unstructured.Unstructured{Object: runtime.DeepCopyJSON(vsMap)}
+			_, err = action.Execute(input)
+			require.NoError(t, err)
+
+			vscList, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			require.Len(t, vscList.Items, 1)
+			assert.Equal(t, "velero-restore-uid-vs-uid", vscList.Items[0].Name)
+		})
+	}
+}
+
+func TestVolumeSnapshotExecuteDeleteSecret(t *testing.T) {
+	restore := builder.ForRestore("velero", "restore").NamespaceMappings("secrets", "mapped-secrets").Result()
+	vs := builder.ForVolumeSnapshot("ns", "vs-1").ObjectMeta(builder.WithAnnotations(
+		util.VolumeSnapshotHandleAnnotation, "handle", util.CSIDriverNameAnnotation, "driver",
+		util.CSIDeleteSnapshotSecretName, "secret", util.CSIDeleteSnapshotSecretNamespace, "secrets")).Result()
+	vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vs)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		secret      *corev1api.Secret
+		expectedErr string
+	}{
+		{
+			name:   "restored volumesnapshotcontent references the secret in the mapped namespace",
+			secret: builder.ForSecret("mapped-secrets", "secret").Result(),
+		},
+		{
+			name:        "missing secret",
+			secret:      builder.ForSecret("secrets", "secret").Result(),
+			expectedErr: "fail to restore volumesnapshot ns/vs-1: snapshot secret mapped-secrets/secret doesn't exist",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			snapshotClient := snapshotfake.NewSimpleClientset()
+			action := VolumeSnapshotRestoreItemAction{
+				Log:            logrus.New(),
+				Client:         fake.NewSimpleClientset(tc.secret),
+				SnapshotClient: snapshotClient,
+				VeleroClient:   velerofake.NewSimpleClientset(restore),
+			}
+			_, err := action.Execute(&velero.RestoreItemActionExecuteInput{
+				Item:    &unstructured.Unstructured{Object: runtime.DeepCopyJSON(vsMap)},
+				Restore: restore,
+			})
+
+			vscList, listErr := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, listErr)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				assert.Empty(t, vscList.Items)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, vscList.Items, 1)
+			assert.Equal(t, "secret", vscList.Items[0].Annotations[util.PrefixedSnapshotterSecretNameKey])
+			assert.Equal(t, "mapped-secrets", vscList.Items[0].Annotations[util.PrefixedSnapshotterSecretNamespaceKey])
+		})
+	}
+}
+
+func TestVolumeSnapshotContentExecuteDeleteSecret(t *testing.T) {
+	restore := builder.ForRestore("velero", "restore").NamespaceMappings("secrets", "mapped-secrets").Result()
+	vsc := builder.ForVolumeSnapshotContent("vsc-1").Result()
+	vsc.Annotations = map[string]string{util.PrefixedSnapshotterSecretNameKey: "secret", util.PrefixedSnapshotterSecretNamespaceKey: "secrets"}
+	vscMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vsc)
+	require.NoError(t, err)
+
+	action := VolumeSnapshotContentRestoreItemAction{Log: logrus.New()}
+	output, err := action.Execute(&velero.RestoreItemActionExecuteInput{
+		Item:    &unstructured.Unstructured{Object: vscMap},
+		Restore: restore,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []velero.ResourceIdentifier{{
+		GroupResource: schema.GroupResource{Resource: "secrets"},
+		Namespace:     "secrets",
+		Name:          "secret",
+	}}, output.AdditionalItems)
+	restored := new(snapshotv1api.VolumeSnapshotContent)
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.UnstructuredContent(), restored))
+	assert.Equal(t, "mapped-secrets", restored.Annotations[util.PrefixedSnapshotterSecretNamespaceKey])
+}
@@ -47,7 +47,7 @@ func (p *VolumeSnapshotClassRestoreItemAction) AppliesTo() (velero.ResourceSelec
 // Execute restores volumesnapshotclass objects returning any snapshotlister secret as additional items to restore
 func (p *VolumeSnapshotClassRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
 	p.Log.Info("Starting VolumeSnapshotClassRestoreItemAction")
-	if boolptr.IsSetToFalse(input.Restore.Spec.RestorePVs) {
+	if boolptr.IsSetToFalse(input.Restore.Spec.RestorePVs) && !util.IsSnapshotsOnlyRestore(input.Restore) {
 		p.Log.Infof("Restore did not request for PVs to be restored %s/%s", input.Restore.Namespace, input.Restore.Name)
 		return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
 	}
@@ -21,6 +21,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
@@ -44,8 +45,8 @@ func (p *VolumeSnapshotContentRestoreItemAction) AppliesTo() (velero.ResourceSel
 	}, nil
 }
 
-// Execute restores a volumesnapshotcontent object without modification returning the snapshot lister secret, if any, as
-// additional items to restore.
+// Execute restores a volumesnapshotcontent object returning the snapshot deletion secret, if any, as additional items
+// to restore. The namespace of the secret is mapped like the namespace of the restored secret.
 func (p *VolumeSnapshotContentRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
 	p.Log.Info("Starting VolumeSnapshotContentRestoreItemAction")
 	var snapCont snapshotv1api.VolumeSnapshotContent
@@ -56,21 +57,39 @@ func (p *VolumeSnapshotContentRestoreItemAction) Execute(input *velero.RestoreIt
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), &snapCont); err != nil {
 		return &velero.RestoreItemActionExecuteOutput{}, errors.Wrapf(err, "failed to convert input.Item from unstructured")
 	}
+	// The volumesnapshots of a snapshots-only restore are bound to volumesnapshotcontents created by their restore.
+	if util.IsSnapshotsOnlyRestore(input.Restore) {
+		p.Log.Infof("Skipping volumesnapshotcontent %s in a snapshots-only restore", snapCont.Name)
+		return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
+	}
+	// The local snapshot is only used where it was taken, and the PVC restore binds it there.
+	if util.IsLocalSnapshotContent(&snapCont) {
+		p.Log.Infof("Skipping volumesnapshotcontent %s of a local snapshot", snapCont.Name)
+		return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
+	}
 
 	additionalItems := []velero.ResourceIdentifier{}
 	if util.IsVolumeSnapshotContentHasDeleteSecret(&snapCont) {
+		// The secret is looked up in the backup under its namespace there, and restored into the mapped namespace.
+		secretNamespace := snapCont.Annotations[util.PrefixedSnapshotterSecretNamespaceKey]
 		additionalItems = append(additionalItems,
 			velero.ResourceIdentifier{
 				GroupResource: schema.GroupResource{Group: "", Resource: "secrets"},
-				Name:          snapCont.Annotations[util.CSIDeleteSnapshotSecretName],
-				Namespace:     snapCont.Annotations[util.CSIDeleteSnapshotSecretNamespace],
+				Name:          snapCont.Annotations[util.PrefixedSnapshotterSecretNameKey],
+				Namespace:     secretNamespace,
 			},
 		)
+		snapCont.Annotations[util.PrefixedSnapshotterSecretNamespaceKey] = util.MapSnapshotSecretNamespace(secretNamespace, input.Restore)
+	}
+
+	snapContMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&snapCont)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
 	p.Log.Infof("Returning from VolumeSnapshotContentRestoreItemAction with %d additionalItems", len(additionalItems))
 	return &velero.RestoreItemActionExecuteOutput{
-		UpdatedItem:     input.Item,
+		UpdatedItem:     &unstructured.Unstructured{Object: snapContMap},
 		AdditionalItems: additionalItems,
 	}, nil
 }
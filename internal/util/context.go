@@ -0,0 +1,63 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+)
+
+const defaultResourceTimeout = 10 * time.Minute
+
+// GetResourceTimeout returns the resource timeout Velero passes to the plugin in the
+// velero.io/resource-timeout annotation of the backup or restore, or 10 minutes when it is not set.
+func GetResourceTimeout(annotations map[string]string, log logrus.FieldLogger) time.Duration {
+	value, ok := annotations[ResourceTimeoutAnnotation]
+	if !ok {
+		return defaultResourceTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Warnf("fail to parse resource timeout annotation %s, using %s", value, defaultResourceTimeout)
+		return defaultResourceTimeout
+	}
+	return timeout
+}
+
+// GetCSISnapshotTimeout returns how long the backup waits for a CSI snapshot, or 10 minutes when it is not set.
+func GetCSISnapshotTimeout(backup *velerov1api.Backup) time.Duration {
+	if backup.Spec.CSISnapshotTimeout.Duration > 0 {
+		return backup.Spec.CSISnapshotTimeout.Duration
+	}
+	return defaultCSISnapshotTimeout
+}
+
+// NewBackupContext returns the context of an action processing an item of the backup. It lasts for the
+// CSI snapshot timeout plus the resource timeout, so a snapshot wait times out on its own before the context expires.
+func NewBackupContext(backup *velerov1api.Backup, log logrus.FieldLogger) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), GetCSISnapshotTimeout(backup)+GetResourceTimeout(backup.Annotations, log))
+}
+
+// NewResourceContext returns the context of an action call that only reads or updates resources, such as a
+// Progress, Cancel, restore or delete. It lasts for the resource timeout in the annotations of the backup or restore.
+func NewResourceContext(annotations map[string]string, log logrus.FieldLogger) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), GetResourceTimeout(annotations, log))
+}
@@ -0,0 +1,80 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetResourceTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    time.Duration
+	}{
+		{
+			name:     "not set",
+			expected: 10 * time.Minute,
+		},
+		{
+			name:        "set",
+			annotations: map[string]string{ResourceTimeoutAnnotation: "3m"},
+			expected:    3 * time.Minute,
+		},
+		{
+			name:        "invalid",
+			annotations: map[string]string{ResourceTimeoutAnnotation: "soon"},
+			expected:    10 * time.Minute,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, GetResourceTimeout(tc.annotations, logrus.New()))
+		})
+	}
+}
+
+func TestGetVolumeSnapshotContentForVolumeSnapshotCanceled(t *testing.T) {
+	vs := &snapshotv1api.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs-unbound"},
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(vs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	vsc, err := GetVolumeSnapshotContentForVolumeSnapshot(ctx, vs, snapshotClient.SnapshotV1(), logrus.New(), true, time.Minute)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, vsc)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
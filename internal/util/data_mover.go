@@ -0,0 +1,88 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+)
+
+// GetDataMover returns the data mover uploading the data of the PVC: the one chosen by the PVC's
+// annotation, or else the data mover of the backup.
+func GetDataMover(pvc *corev1api.PersistentVolumeClaim, backup *velerov1api.Backup) string {
+	if dataMover := pvc.Annotations[DataMoverAnnotation]; dataMover != "" {
+		return dataMover
+	}
+	return backup.Spec.DataMover
+}
+
+// GetDataMoverConfig returns the data mover configuration of the PVC: the configuration in the backup's
+// annotation, with the keys in the PVC's annotation overriding it.
+func GetDataMoverConfig(pvc *corev1api.PersistentVolumeClaim, backup *velerov1api.Backup) (map[string]string, error) {
+	config, err := parseDataMoverConfig(backup.Annotations[DataMoverConfigAnnotation])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s annotation of backup %s", DataMoverConfigAnnotation, backup.Name)
+	}
+	pvcConfig, err := parseDataMoverConfig(pvc.Annotations[DataMoverConfigAnnotation])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s annotation of PVC %s/%s", DataMoverConfigAnnotation, pvc.Namespace, pvc.Name)
+	}
+	for k, v := range pvcConfig {
+		config[k] = v
+	}
+	return config, nil
+}
+
+// RecordDataMover records on the backed-up PVC the data mover and configuration its data is uploaded with,
+// so the restore downloads it with the same data mover.
+func RecordDataMover(annotations map[string]string, dataMover string, config map[string]string) error {
+	if dataMover != "" {
+		annotations[BackupDataMoverAnnotation] = dataMover
+	}
+	if len(config) > 0 {
+		data, err := json.Marshal(config)
+		if err != nil {
+			return errors.Wrap(err, "fail to marshal data mover config")
+		}
+		annotations[BackupDataMoverConfigAnnotation] = string(data)
+	}
+	return nil
+}
+
+// GetRecordedDataMover returns the data mover and configuration recorded by RecordDataMover on the backed-up PVC.
+func GetRecordedDataMover(pvc *corev1api.PersistentVolumeClaim) (string, map[string]string, error) {
+	config, err := parseDataMoverConfig(pvc.Annotations[BackupDataMoverConfigAnnotation])
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "invalid %s annotation of PVC %s/%s", BackupDataMoverConfigAnnotation, pvc.Namespace, pvc.Name)
+	}
+	return pvc.Annotations[BackupDataMoverAnnotation], config, nil
+}
+
+func parseDataMoverConfig(value string) (map[string]string, error) {
+	config := map[string]string{}
+	if value == "" {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return nil, errors.Wrapf(err, "fail to parse data mover config %s", value)
+	}
+	return config, nil
+}
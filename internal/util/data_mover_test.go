@@ -0,0 +1,86 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vmware-tanzu/velero/pkg/builder"
+)
+
+func TestGetDataMoverConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		backupAnnotations map[string]string
+		pvcAnnotations    map[string]string
+		expectedMover     string
+		expectedConfig    map[string]string
+		expectErr         bool
+	}{
+		{
+			name:           "no data mover settings",
+			expectedMover:  "velero",
+			expectedConfig: map[string]string{},
+		},
+		{
+			name:              "PVC overrides the backup",
+			backupAnnotations: map[string]string{DataMoverConfigAnnotation: `{"parallelism":"2","compression":"none"}`},
+			pvcAnnotations: map[string]string{
+				DataMoverAnnotation:       "vendor-mover",
+				DataMoverConfigAnnotation: `{"parallelism":"8"}`,
+			},
+			expectedMover:  "vendor-mover",
+			expectedConfig: map[string]string{"parallelism": "8", "compression": "none"},
+		},
+		{
+			name:           "invalid PVC config",
+			pvcAnnotations: map[string]string{DataMoverConfigAnnotation: "parallelism=8"},
+			expectedMover:  "velero",
+			expectErr:      true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotationsMap(tc.backupAnnotations)).DataMover("velero").Result()
+			pvc := builder.ForPersistentVolumeClaim("ns", "pvc").ObjectMeta(builder.WithAnnotationsMap(tc.pvcAnnotations)).Result()
+
+			assert.Equal(t, tc.expectedMover, GetDataMover(pvc, backup))
+			config, err := GetDataMoverConfig(pvc, backup)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedConfig, config)
+		})
+	}
+}
+
+func TestRecordDataMover(t *testing.T) {
+	annotations := map[string]string{}
+	require.NoError(t, RecordDataMover(annotations, "vendor-mover", map[string]string{"parallelism": "8"}))
+
+	pvc := builder.ForPersistentVolumeClaim("ns", "pvc").ObjectMeta(builder.WithAnnotationsMap(annotations)).Result()
+	dataMover, config, err := GetRecordedDataMover(pvc)
+	require.NoError(t, err)
+	assert.Equal(t, "vendor-mover", dataMover)
+	assert.Equal(t, map[string]string{"parallelism": "8"}, config)
+}
@@ -0,0 +1,37 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"strconv"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+)
+
+// IsDeletionDryRun returns whether the deletion of the backup only reports the snapshots it would delete.
+func IsDeletionDryRun(backup *velerov1api.Backup) bool {
+	dryRun, err := strconv.ParseBool(backup.Annotations[DeletionDryRunAnnotation])
+	return err == nil && dryRun
+}
+
+// IsVolumeSnapshotContentProtected returns whether the volumesnapshotcontent is protected from the deletion of backups.
+func IsVolumeSnapshotContentProtected(vsc *snapshotv1api.VolumeSnapshotContent) bool {
+	protected, err := strconv.ParseBool(vsc.Labels[SnapshotProtectedLabel])
+	return err == nil && protected
+}
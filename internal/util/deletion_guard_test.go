@@ -0,0 +1,31 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vmware-tanzu/velero/pkg/builder"
+)
+
+func TestIsDeletionDryRun(t *testing.T) {
+	assert.False(t, IsDeletionDryRun(builder.ForBackup("velero", "backup").Result()))
+	assert.True(t, IsDeletionDryRun(builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(DeletionDryRunAnnotation, "true")).Result()))
+	assert.False(t, IsDeletionDryRun(builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(DeletionDryRunAnnotation, "false")).Result()))
+}
@@ -0,0 +1,231 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+// DeletionReport is the record of what deleting the backup would do with one of its VolumeSnapshotContents.
+type DeletionReport struct {
+	VolumeSnapshotContent string `json:"volumeSnapshotContent"`
+	// VolumeSnapshot is the namespace/name of the VolumeSnapshot bound to the VolumeSnapshotContent.
+	VolumeSnapshot string `json:"volumeSnapshot,omitempty"`
+	SnapshotHandle string `json:"snapshotHandle,omitempty"`
+	// KeptReason explains why deleting the backup would keep the snapshot. It is empty when the snapshot would be deleted.
+	KeptReason string `json:"keptReason,omitempty"`
+}
+
+// DeletionReportConfigMapName returns the name of the ConfigMap holding the deletion report of the backup.
+func DeletionReportConfigMapName(backupName string) string {
+	return backupName + "-csi-deletion-dry-run"
+}
+
+// WriteDeletionReports writes the deletion report of each backup in namespace annotated with DeletionDryRunAnnotation.
+// The report lists, per VolumeSnapshotContent of the backup, the VolumeSnapshot and storage snapshot that deleting the
+// backup would delete, or why they would be kept. Nothing is deleted, and the backup doesn't have to be deleted to get it.
+func WriteDeletionReports(ctx context.Context, namespace string, kubeClient kubernetes.Interface, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface, log logrus.FieldLogger) error {
+	backupList, err := veleroClient.VeleroV1().Backups(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error listing backups")
+	}
+
+	var holds *LegalHolds
+	errs := []error{}
+	for i := range backupList.Items {
+		backup := &backupList.Items[i]
+		if !IsDeletionDryRun(backup) {
+			continue
+		}
+		if holds == nil {
+			if holds, err = GetLegalHolds(ctx, namespace, kubeClient); err != nil {
+				return err
+			}
+		}
+		if err := writeDeletionReport(ctx, backup, holds, kubeClient, snapshotClient); err != nil {
+			errs = append(errs, errors.Wrapf(err, "fail to write deletion report of backup %s", backup.Name))
+			continue
+		}
+		log.Infof("Wrote deletion report %s/%s of backup %s", backup.Namespace, DeletionReportConfigMapName(backup.Name), backup.Name)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func writeDeletionReport(ctx context.Context, backup *velerov1api.Backup, holds *LegalHolds, kubeClient kubernetes.Interface,
+	snapshotClient snapshotter.SnapshotV1Interface) error {
+	vscList, err := snapshotClient.VolumeSnapshotContents().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", velerov1api.BackupNameLabel, label.GetValidName(backup.Name)),
+	})
+	if err != nil {
+		return errors.Wrap(err, "error listing volumesnapshotcontents")
+	}
+
+	cm := newDeletionReportConfigMap(backup)
+	for i := range vscList.Items {
+		report, err := NewDeletionReport(ctx, backup, &vscList.Items[i], holds, snapshotClient)
+		if err != nil {
+			return err
+		}
+		if cm.Data[deletionReportKey(report)], err = marshalDeletionReport(report); err != nil {
+			return err
+		}
+	}
+
+	existing, err := kubeClient.CoreV1().ConfigMaps(backup.Namespace).Get(ctx, cm.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = kubeClient.CoreV1().ConfigMaps(backup.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	existing.Data = cm.Data
+	_, err = kubeClient.CoreV1().ConfigMaps(backup.Namespace).Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
+
+// NewDeletionReport returns what deleting the backup would do with the volumesnapshotcontent and the volumesnapshot
+// bound to it.
+func NewDeletionReport(ctx context.Context, backup *velerov1api.Backup, vsc *snapshotv1api.VolumeSnapshotContent, holds *LegalHolds,
+	snapshotClient snapshotter.SnapshotV1Interface) (DeletionReport, error) {
+	report := DeletionReport{
+		VolumeSnapshotContent: vsc.Name,
+		SnapshotHandle:        getVolumeSnapshotContentHandle(vsc),
+	}
+	heldObjects := []*metav1.ObjectMeta{&vsc.ObjectMeta}
+	if ref := vsc.Spec.VolumeSnapshotRef; ref.Name != "" {
+		report.VolumeSnapshot = ref.Namespace + "/" + ref.Name
+		vs, err := snapshotClient.VolumeSnapshots(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return report, errors.Wrapf(err, "error getting volumesnapshot %s", report.VolumeSnapshot)
+		}
+		if err == nil {
+			heldObjects = append(heldObjects, &vs.ObjectMeta)
+		}
+	}
+	if reason := holds.HoldReason(backup, report.SnapshotHandle, heldObjects...); reason != "" {
+		report.KeptReason = "under legal hold: " + reason
+	} else if IsVolumeSnapshotContentProtected(vsc) {
+		report.KeptReason = "protected by label " + SnapshotProtectedLabel
+	}
+	return report, nil
+}
+
+// AddDeletionReport adds the report to the deletion report of the backup. The delete item actions add the report of
+// each item of a backup deleted with DeletionDryRunAnnotation, instead of deleting it.
+func AddDeletionReport(ctx context.Context, backup *velerov1api.Backup, report DeletionReport, kubeClient kubernetes.Interface) error {
+	data, err := marshalDeletionReport(report)
+	if err != nil {
+		return err
+	}
+	configMaps := kubeClient.CoreV1().ConfigMaps(backup.Namespace)
+	// The volumesnapshot and volumesnapshotcontent actions run concurrently, both may create or update the configmap.
+	err = retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		cm, err := configMaps.Get(ctx, DeletionReportConfigMapName(backup.Name), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = newDeletionReportConfigMap(backup)
+			cm.Data[deletionReportKey(report)] = data
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[deletionReportKey(report)] = data
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fail to add deletion report of %s to backup %s", deletionReportKey(report), backup.Name)
+	}
+	return nil
+}
+
+// deletionReportKey returns the configmap key of the report: the name of the volumesnapshotcontent, or the namespace and
+// name of the volumesnapshot when it isn't bound to one.
+func deletionReportKey(report DeletionReport) string {
+	if report.VolumeSnapshotContent != "" {
+		return report.VolumeSnapshotContent
+	}
+	return strings.ReplaceAll(report.VolumeSnapshot, "/", ".")
+}
+
+func marshalDeletionReport(report DeletionReport) (string, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", errors.Wrapf(err, "fail to marshal deletion report of %s", deletionReportKey(report))
+	}
+	return string(data), nil
+}
+
+// GetDeletionReports returns the deletion report of the backup keyed by VolumeSnapshotContent name.
+func GetDeletionReports(ctx context.Context, kubeClient kubernetes.Interface, backup *velerov1api.Backup) (map[string]DeletionReport, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(backup.Namespace).Get(ctx, DeletionReportConfigMapName(backup.Name), metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get deletion report configmap of backup %s", backup.Name)
+	}
+
+	reports := map[string]DeletionReport{}
+	for key, data := range cm.Data {
+		report := DeletionReport{}
+		if err := json.Unmarshal([]byte(data), &report); err != nil {
+			return nil, errors.Wrapf(err, "fail to unmarshal deletion report %s", key)
+		}
+		reports[key] = report
+	}
+	return reports, nil
+}
+
+// newDeletionReportConfigMap returns the configmap of the deletion report. It isn't owned by the backup, as Velero deletes
+// the backup even when its deletion is a dry run.
+func newDeletionReportConfigMap(backup *velerov1api.Backup) *corev1api.ConfigMap {
+	return &corev1api.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: backup.Namespace,
+			Name:      DeletionReportConfigMapName(backup.Name),
+			Labels: map[string]string{
+				velerov1api.BackupNameLabel: label.GetValidName(backup.Name),
+				velerov1api.BackupUIDLabel:  string(backup.UID),
+			},
+		},
+		Data: map[string]string{},
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+)
+
+func TestWriteDeletionReports(t *testing.T) {
+	newVSC := func(name, backupName, handle string, labels ...string) *snapshotv1api.VolumeSnapshotContent {
+		vsc := builder.ForVolumeSnapshotContent(name).DeletionPolicy(snapshotv1api.VolumeSnapshotContentRetain).
+			VolumeSnapshotRef("ns", "vs-"+name).Status(&snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle}).Result()
+		vsc.Labels = map[string]string{velerov1api.BackupNameLabel: backupName}
+		for i := 0; i+1 < len(labels); i += 2 {
+			vsc.Labels[labels[i]] = labels[i+1]
+		}
+		return vsc
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		newVSC("vsc-1", "backup", "snap-1"),
+		newVSC("vsc-2", "backup", "snap-2", SnapshotProtectedLabel, "true"),
+		newVSC("vsc-3", "backup", "snap-3"),
+		newVSC("vsc-4", "other", "snap-4"),
+		builder.ForVolumeSnapshot("ns", "vs-vsc-3").ObjectMeta(builder.WithAnnotations(LegalHoldLabel, "case-1234")).Result(),
+	)
+	kubeClient := fake.NewSimpleClientset()
+	veleroClient := velerofake.NewSimpleClientset(
+		builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(DeletionDryRunAnnotation, "true")).Result(),
+		builder.ForBackup("velero", "other").Result(),
+	)
+
+	require.NoError(t, WriteDeletionReports(context.Background(), "velero", kubeClient, snapshotClient.SnapshotV1(), veleroClient, logrus.New()))
+
+	reports, err := GetDeletionReports(context.Background(), kubeClient, builder.ForBackup("velero", "backup").Result())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]DeletionReport{
+		"vsc-1": {VolumeSnapshotContent: "vsc-1", VolumeSnapshot: "ns/vs-vsc-1", SnapshotHandle: "snap-1"},
+		"vsc-2": {VolumeSnapshotContent: "vsc-2", VolumeSnapshot: "ns/vs-vsc-2", SnapshotHandle: "snap-2",
+			KeptReason: "protected by label velero.io/csi-snapshot-protected"},
+		"vsc-3": {VolumeSnapshotContent: "vsc-3", VolumeSnapshot: "ns/vs-vsc-3", SnapshotHandle: "snap-3",
+			KeptReason: "under legal hold: vs-vsc-3 is annotated velero.io/csi-legal-hold: case-1234"},
+	}, reports)
+
+	// A backup without the annotation gets no report.
+	_, err = kubeClient.CoreV1().ConfigMaps("velero").Get(context.Background(), DeletionReportConfigMapName("other"), metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// The report is refreshed, and nothing is deleted.
+	require.NoError(t, snapshotClient.SnapshotV1().VolumeSnapshotContents().Delete(context.Background(), "vsc-1", metav1.DeleteOptions{}))
+	require.NoError(t, WriteDeletionReports(context.Background(), "velero", kubeClient, snapshotClient.SnapshotV1(), veleroClient, logrus.New()))
+	reports, err = GetDeletionReports(context.Background(), kubeClient, builder.ForBackup("velero", "backup").Result())
+	require.NoError(t, err)
+	assert.Len(t, reports, 2)
+	vscList, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, vscList.Items, 3)
+}
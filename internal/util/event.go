@@ -0,0 +1,106 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+)
+
+// EventComponent is the source component of the events emitted by the plugin.
+const EventComponent = "velero-plugin-for-csi"
+
+// Reasons of the events emitted by the plugin.
+const (
+	EventReasonSnapshotCreated       = "SnapshotCreated"
+	EventReasonSnapshotReady         = "SnapshotReady"
+	EventReasonSnapshotFailed        = "SnapshotFailed"
+	EventReasonSnapshotSkipped       = "SnapshotSkipped"
+	EventReasonRestoredFromSnapshot  = "RestoredFromSnapshot"
+	EventReasonRestoreFailed         = "RestoreFromSnapshotFailed"
+	EventReasonDataUploadResubmitted = "DataUploadResubmitted"
+	EventReasonDataUploadReused      = "DataUploadReused"
+)
+
+var (
+	eventRecorder     record.EventRecorder
+	eventRecorderOnce sync.Once
+)
+
+// NewEventRecorder returns an event recorder that sends the events of the plugin through kubeClient.
+func NewEventRecorder(kubeClient kubernetes.Interface, log logrus.FieldLogger) record.EventRecorder {
+	scheme := runtime.NewScheme()
+	_ = kubescheme.AddToScheme(scheme)
+	_ = velerov1api.AddToScheme(scheme)
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	broadcaster.StartLogging(log.Debugf)
+
+	return broadcaster.NewRecorder(scheme, corev1api.EventSource{Component: EventComponent})
+}
+
+// GetEventRecorder returns the event recorder of the plugin process, built from the clients of GetFullClients.
+// If the clients cannot be created, it returns nil and the events are dropped.
+func GetEventRecorder(log logrus.FieldLogger) record.EventRecorder {
+	eventRecorderOnce.Do(func() {
+		client, _, _, err := GetFullClients()
+		if err != nil {
+			log.WithError(err).Warn("Fail to create event recorder, events will not be emitted")
+			return
+		}
+		eventRecorder = NewEventRecorder(client, log)
+	})
+	return eventRecorder
+}
+
+// PVCEventObject returns an object to record events about the PVC when only its namespace and name are known.
+func PVCEventObject(namespace, name string) *corev1api.PersistentVolumeClaim {
+	return &corev1api.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+}
+
+// RecordPVCEventf emits the event on the PVC and on the backup or restore processing it.
+// Nothing is emitted when recorder is nil.
+func RecordPVCEventf(recorder record.EventRecorder, pvc *corev1api.PersistentVolumeClaim, owner runtime.Object,
+	eventtype, reason, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+	recorder.Eventf(pvc, eventtype, reason, messageFmt, args...)
+	if owner != nil {
+		recorder.Eventf(owner, eventtype, reason, "PVC %s/%s: "+messageFmt, append([]interface{}{pvc.Namespace, pvc.Name}, args...)...)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/vmware-tanzu/velero/pkg/builder"
+)
+
+func TestRecordPVCEventf(t *testing.T) {
+	testCases := []struct {
+		name     string
+		owner    runtime.Object
+		expected []string
+	}{
+		{
+			name:     "event is emitted on the PVC only without owner",
+			expected: []string{"Warning SnapshotFailed Failed to create volumesnapshot: boom"},
+		},
+		{
+			name:  "event is emitted on the PVC and the backup",
+			owner: builder.ForBackup("velero", "backup").Result(),
+			expected: []string{
+				"Warning SnapshotFailed Failed to create volumesnapshot: boom",
+				"Warning SnapshotFailed PVC ns/pvc: Failed to create volumesnapshot: boom",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			RecordPVCEventf(recorder, PVCEventObject("ns", "pvc"), tc.owner, corev1api.EventTypeWarning, EventReasonSnapshotFailed,
+				"Failed to create volumesnapshot: %s", "boom")
+
+			require.Len(t, recorder.Events, len(tc.expected))
+			for _, expected := range tc.expected {
+				assert.Equal(t, expected, <-recorder.Events)
+			}
+		})
+	}
+
+	// A nil recorder drops the event.
+	RecordPVCEventf(nil, PVCEventObject("ns", "pvc"), nil, corev1api.EventTypeNormal, EventReasonSnapshotReady, "ready")
+}
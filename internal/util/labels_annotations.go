@@ -52,4 +52,112 @@ const (
 
 	// DataUploadNameAnnotation is the label key for the DataUpload name
 	DataUploadNameAnnotation = "velero.io/data-upload-name"
+
+	// ExistingPVCPolicyAnnotation is the restore or PVC annotation key used to choose
+	// how a PVC that already exists in the cluster is handled on restore.
+	ExistingPVCPolicyAnnotation = "velero.io/csi-existing-pvc-policy"
+	// OriginalReplicasAnnotation records the replicas of a workload scaled down to
+	// release a PVC that is replaced on restore.
+	OriginalReplicasAnnotation = "velero.io/csi-original-replicas"
+	// ScaledDownWorkloadsAnnotation lists on the restored PVC the comma separated <kind>/<name> of the
+	// workloads scaled down to release the PVC it replaced. They are scaled up once the PVC is restored.
+	ScaledDownWorkloadsAnnotation = "velero.io/csi-scaled-down-workloads"
+	// RenamedFromPVCAnnotation records the name of the PVC that was renamed to keep
+	// its volume when a PVC is restored over it.
+	RenamedFromPVCAnnotation = "velero.io/csi-renamed-from-pvc"
+	// OriginalReclaimPolicyAnnotation records the reclaim policy of the PV of a renamed PVC while it is
+	// set to Retain to survive the rename. It is put back once the renamed PVC is bound.
+	OriginalReclaimPolicyAnnotation = "velero.io/csi-original-reclaim-policy"
+	// DataDownloadFailurePolicyAnnotation is the restore or PVC annotation key used to choose what happens
+	// to the restored PVC when its DataDownload fails or is canceled.
+	DataDownloadFailurePolicyAnnotation = "velero.io/csi-data-download-failure-policy"
+	// DataDownloadFailureActionAnnotation records on a PVC recreated after its DataDownload failed
+	// the policy that recreated it.
+	DataDownloadFailureActionAnnotation = "velero.io/csi-data-download-failure-action"
+
+	// SnapshotRetentionAnnotation is the backup annotation key holding how long the local
+	// CSI snapshots of the backup are kept, independent of the backup TTL.
+	SnapshotRetentionAnnotation = "velero.io/csi-snapshot-retention"
+	// SnapshotExpiresAtAnnotation records on the VolumeSnapshot and VolumeSnapshotContent
+	// the time after which the snapshot retention sweeper removes the snapshot.
+	SnapshotExpiresAtAnnotation = "velero.io/csi-snapshot-expires-at"
+	// SourceVolumeSnapshotAnnotation records on the VolumeSnapshotContent the namespace/name
+	// of the VolumeSnapshot it was created for.
+	SourceVolumeSnapshotAnnotation = "velero.io/csi-source-volumesnapshot"
+	// ExpiredVolumeSnapshotsAnnotation lists on the backup the comma separated namespace/name
+	// of the VolumeSnapshots removed by the snapshot retention sweeper.
+	ExpiredVolumeSnapshotsAnnotation = "velero.io/csi-expired-volumesnapshots"
+
+	// VolumeReportLabel marks the ConfigMap holding the per-volume report of a backup.
+	VolumeReportLabel = "velero.io/csi-volume-report"
+
+	// SnapshotCanceledAnnotation records on the VolumeSnapshot and VolumeSnapshotContent the time
+	// the backup operation of the snapshot was canceled, before they are removed.
+	SnapshotCanceledAnnotation = "velero.io/csi-snapshot-canceled"
+
+	// SnapshotErrorGracePeriodAnnotation is the backup annotation key holding how long after the start of
+	// a snapshot operation an error reported by the snapshot controller is retried before failing the operation.
+	SnapshotErrorGracePeriodAnnotation = "velero.io/csi-snapshot-error-grace-period"
+	// SnapshotReadyTimeoutAnnotation is the backup annotation key holding how long a snapshot operation
+	// waits for the snapshot to be ReadyToUse before failing.
+	SnapshotReadyTimeoutAnnotation = "velero.io/csi-snapshot-ready-timeout"
+
+	// DataMoverAnnotation is the PVC annotation key choosing the data mover of the PVC
+	// instead of the data mover of the backup.
+	DataMoverAnnotation = "velero.io/csi-data-mover"
+	// DataMoverConfigAnnotation is the backup or PVC annotation key holding the JSON object of the
+	// data mover configuration. The keys of the PVC override the keys of the backup.
+	DataMoverConfigAnnotation = "velero.io/csi-data-mover-config"
+	// BackupDataMoverAnnotation records on the backed-up PVC the data mover that uploaded its data.
+	BackupDataMoverAnnotation = "velero.io/csi-backup-data-mover"
+	// BackupDataMoverConfigAnnotation records on the backed-up PVC the JSON object of the data mover
+	// configuration its data was uploaded with.
+	BackupDataMoverConfigAnnotation = "velero.io/csi-backup-data-mover-config"
+
+	// KeepLocalSnapshotAnnotation is the backup or PVC annotation key asking to keep the CSI snapshot
+	// after the data mover uploaded its data. The annotation of the PVC overrides the one of the backup.
+	KeepLocalSnapshotAnnotation = "velero.io/csi-keep-local-snapshot"
+	// LocalSnapshotContentAnnotation records on the backed-up PVC the name of the VolumeSnapshotContent
+	// keeping its local snapshot.
+	LocalSnapshotContentAnnotation = "velero.io/csi-local-snapshot-content"
+	// LocalSnapshotSourceAnnotation marks the VolumeSnapshotContent keeping a local snapshot with the
+	// namespace/name of the PVC it was taken for.
+	LocalSnapshotSourceAnnotation = "velero.io/csi-local-snapshot-source"
+
+	// RetryOfBackupAnnotation is the backup annotation key holding the name of the previous backup it retries.
+	// A failed or canceled data upload of a PVC in the previous backup is resubmitted with its volumesnapshot
+	// instead of taking another snapshot.
+	RetryOfBackupAnnotation = "velero.io/csi-retry-of"
+
+	// SnapshotsOnlyRestoreAnnotation is the restore annotation key asking to recreate the VolumeSnapshots
+	// of the backup without restoring the PVCs.
+	SnapshotsOnlyRestoreAnnotation = "velero.io/csi-snapshots-only"
+	// SnapshotsOnlyRestoreUIDLabel is the label key holding the UID of the snapshots-only restore that
+	// recreated the VolumeSnapshot or VolumeSnapshotContent.
+	SnapshotsOnlyRestoreUIDLabel = "velero.io/csi-snapshots-only-restore-uid"
+	// CleanupRestoredSnapshotsAnnotation is the restore annotation key asking to remove the VolumeSnapshots and
+	// VolumeSnapshotContents the restore recreated once the PVCs provisioned from them are bound.
+	CleanupRestoredSnapshotsAnnotation = "velero.io/csi-cleanup-restored-snapshots"
+
+	// DeletionDryRunAnnotation is the backup annotation key asking the deletion of the backup to keep its VolumeSnapshots,
+	// VolumeSnapshotContents and storage snapshots, and to report the ones it would delete.
+	DeletionDryRunAnnotation = "velero.io/csi-deletion-dry-run"
+	// SnapshotProtectedLabel is the VolumeSnapshotContent label key that makes the deletion of the backup
+	// keep the VolumeSnapshotContent, its VolumeSnapshot and the storage snapshot.
+	SnapshotProtectedLabel = "velero.io/csi-snapshot-protected"
+
+	// LegalHoldLabel is the backup label key, and the label key of the hold ConfigMaps, putting the snapshots of
+	// backups under legal hold. It is also the VolumeSnapshot and VolumeSnapshotContent annotation key holding the
+	// reason of the legal hold of the snapshot.
+	LegalHoldLabel = "velero.io/csi-legal-hold"
+	// HeldAtDeletionAnnotation is the VolumeSnapshotContent annotation key holding the name of the backup whose deletion
+	// kept the VolumeSnapshotContent because of a legal hold. Velero deletes the backup all the same, so the
+	// VolumeSnapshotContent and its storage snapshot are deleted once the hold is released.
+	HeldAtDeletionAnnotation = "velero.io/csi-held-at-deletion"
+
+	// ExternalSnapshotPolicyAnnotation is the backup or VolumeSnapshot annotation key choosing how a VolumeSnapshot
+	// created outside Velero is verified before it is backed up. The annotation of the VolumeSnapshot overrides the one of the backup.
+	ExternalSnapshotPolicyAnnotation = "velero.io/csi-external-snapshot-policy"
+	// ExternalSnapshotAnnotation marks the backed-up VolumeSnapshot created outside Velero and verified by the backup.
+	ExternalSnapshotAnnotation = "velero.io/csi-external-snapshot"
 )
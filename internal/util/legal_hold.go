@@ -0,0 +1,295 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+const (
+	// legalHoldBackupPrefix and legalHoldSnapshotPrefix start the lines of a hold ConfigMap entry
+	// holding a backup by name or a storage snapshot by handle.
+	legalHoldBackupPrefix   = "backup:"
+	legalHoldSnapshotPrefix = "snapshot:"
+)
+
+// LegalHolds are the entries of the hold ConfigMaps in the Velero namespace. A hold ConfigMap is labeled with
+// velero.io/csi-legal-hold=true. Each data key names a hold, and its value lists one held item per line,
+// either backup:<backup name> or snapshot:<snapshot handle>.
+type LegalHolds struct {
+	// Backups maps the held backup names to the names of their holds.
+	Backups map[string]string
+	// Snapshots maps the held snapshot handles to the names of their holds.
+	Snapshots map[string]string
+}
+
+// GetLegalHolds reads the hold ConfigMaps in namespace.
+func GetLegalHolds(ctx context.Context, namespace string, kubeClient kubernetes.Interface) (*LegalHolds, error) {
+	cmList, err := kubeClient.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: LegalHoldLabel + "=true"})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing legal hold configmaps")
+	}
+
+	holds := &LegalHolds{Backups: map[string]string{}, Snapshots: map[string]string{}}
+	for _, cm := range cmList.Items {
+		for hold, items := range cm.Data {
+			holdName := cm.Name + "/" + hold
+			for _, line := range strings.Split(items, "\n") {
+				line = strings.TrimSpace(line)
+				switch {
+				case strings.HasPrefix(line, legalHoldBackupPrefix):
+					holds.Backups[strings.TrimSpace(strings.TrimPrefix(line, legalHoldBackupPrefix))] = holdName
+				case strings.HasPrefix(line, legalHoldSnapshotPrefix):
+					holds.Snapshots[strings.TrimSpace(strings.TrimPrefix(line, legalHoldSnapshotPrefix))] = holdName
+				}
+			}
+		}
+	}
+	return holds, nil
+}
+
+// HoldReason returns why the snapshot with the handle, taken by the backup and represented by the objects, is under
+// legal hold, or an empty string when it isn't.
+func (h *LegalHolds) HoldReason(backup *velerov1api.Backup, handle string, objects ...*metav1.ObjectMeta) string {
+	if isBackupHeldByLabel(backup) {
+		return fmt.Sprintf("backup %s is labeled %s", backup.Name, LegalHoldLabel)
+	}
+	if hold, ok := h.Backups[backup.Name]; ok {
+		return fmt.Sprintf("backup %s is held by %s", backup.Name, hold)
+	}
+	if hold, ok := h.Snapshots[handle]; ok && handle != "" {
+		return fmt.Sprintf("snapshot %s is held by %s", handle, hold)
+	}
+	for _, o := range objects {
+		if o == nil {
+			continue
+		}
+		if reason, ok := o.Annotations[LegalHoldLabel]; ok {
+			return fmt.Sprintf("%s is annotated %s: %s", o.Name, LegalHoldLabel, reason)
+		}
+	}
+	return ""
+}
+
+func isBackupHeldByLabel(backup *velerov1api.Backup) bool {
+	held, err := strconv.ParseBool(backup.Labels[LegalHoldLabel])
+	return err == nil && held
+}
+
+// ListHeldSnapshotHandles returns the sorted handles of all storage snapshots under legal hold: the snapshots of the held
+// backups, the snapshots held by the hold ConfigMaps in namespace, and the snapshots of the annotated VolumeSnapshots
+// and VolumeSnapshotContents.
+func ListHeldSnapshotHandles(ctx context.Context, namespace string, kubeClient kubernetes.Interface, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface) ([]string, error) {
+	holds, err := GetLegalHolds(ctx, namespace, kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	handles := map[string]struct{}{}
+	for handle := range holds.Snapshots {
+		handles[handle] = struct{}{}
+	}
+
+	// The volumesnapshotcontents of a backup carry the backup name label.
+	heldBackups := map[string]struct{}{}
+	for name := range holds.Backups {
+		heldBackups[label.GetValidName(name)] = struct{}{}
+	}
+	backupList, err := veleroClient.VeleroV1().Backups(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing backups")
+	}
+	for i := range backupList.Items {
+		if isBackupHeldByLabel(&backupList.Items[i]) {
+			heldBackups[label.GetValidName(backupList.Items[i].Name)] = struct{}{}
+		}
+	}
+
+	vscList, err := snapshotClient.VolumeSnapshotContents().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing volumesnapshotcontents")
+	}
+	vscHandles := map[string]string{}
+	for i := range vscList.Items {
+		vsc := &vscList.Items[i]
+		handle := getVolumeSnapshotContentHandle(vsc)
+		if handle == "" {
+			continue
+		}
+		vscHandles[vsc.Name] = handle
+		_, backupHeld := heldBackups[vsc.Labels[velerov1api.BackupNameLabel]]
+		_, annotated := vsc.Annotations[LegalHoldLabel]
+		if backupHeld || annotated {
+			handles[handle] = struct{}{}
+		}
+	}
+
+	vsList, err := snapshotClient.VolumeSnapshots("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing volumesnapshots")
+	}
+	for _, vs := range vsList.Items {
+		if _, annotated := vs.Annotations[LegalHoldLabel]; !annotated {
+			continue
+		}
+		if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil && vscHandles[*vs.Status.BoundVolumeSnapshotContentName] != "" {
+			handles[vscHandles[*vs.Status.BoundVolumeSnapshotContentName]] = struct{}{}
+		} else if handle := vs.Annotations[VolumeSnapshotHandleAnnotation]; handle != "" {
+			handles[handle] = struct{}{}
+		}
+	}
+
+	result := make([]string, 0, len(handles))
+	for handle := range handles {
+		result = append(result, handle)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// MarkHeldAtDeletion marks the volumesnapshotcontent the deletion of the backup kept because of a legal hold, and copies
+// the reason of the hold into its velero.io/csi-legal-hold annotation unless it has one. Velero v1.12 only logs the errors
+// of the delete item actions and deletes the backup anyway, so a hold on the backup would go away with it, and nothing else
+// would delete the volumesnapshotcontent once the hold is released. The annotation keeps the hold, and
+// SweepReleasedSnapshots deletes the volumesnapshotcontent once it is removed.
+func MarkHeldAtDeletion(ctx context.Context, vsc *snapshotv1api.VolumeSnapshotContent, backupName, reason string,
+	snapshotClient snapshotter.SnapshotV1Interface) error {
+	annotations := map[string]string{HeldAtDeletionAnnotation: backupName}
+	if _, ok := vsc.Annotations[LegalHoldLabel]; !ok {
+		annotations[LegalHoldLabel] = reason
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal volumesnapshotcontent patch")
+	}
+	if _, err := snapshotClient.VolumeSnapshotContents().Patch(ctx, vsc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return errors.Wrapf(err, "fail to mark volumesnapshotcontent %s held at the deletion of backup %s", vsc.Name, backupName)
+	}
+	return nil
+}
+
+// SweepReleasedSnapshots deletes the volumesnapshotcontents kept by the deletion of their backup because of a legal hold,
+// together with their volumesnapshots and storage snapshots, once the backup is gone, the velero.io/csi-legal-hold
+// annotation copied by MarkHeldAtDeletion is removed, and ListHeldSnapshotHandles no longer lists their snapshot.
+// Protected volumesnapshotcontents are kept.
+func SweepReleasedSnapshots(ctx context.Context, namespace string, kubeClient kubernetes.Interface, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface, log logrus.FieldLogger) error {
+	vscList, err := snapshotClient.VolumeSnapshotContents().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error listing volumesnapshotcontents")
+	}
+
+	var held map[string]struct{}
+	errs := []error{}
+	for i := range vscList.Items {
+		vsc := &vscList.Items[i]
+		backupName, ok := vsc.Annotations[HeldAtDeletionAnnotation]
+		if !ok || IsVolumeSnapshotContentProtected(vsc) {
+			continue
+		}
+		if _, held := vsc.Annotations[LegalHoldLabel]; held {
+			continue
+		}
+		if _, err := veleroClient.VeleroV1().Backups(namespace).Get(ctx, backupName, metav1.GetOptions{}); err == nil {
+			continue
+		} else if !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "fail to get backup %s", backupName))
+			continue
+		}
+		handle := getVolumeSnapshotContentHandle(vsc)
+		if handle == "" {
+			log.Warnf("Volumesnapshotcontent %s kept by the deletion of backup %s has no snapshot handle, keep it", vsc.Name, backupName)
+			continue
+		}
+		if held == nil {
+			handles, err := ListHeldSnapshotHandles(ctx, namespace, kubeClient, snapshotClient, veleroClient)
+			if err != nil {
+				return err
+			}
+			held = map[string]struct{}{}
+			for _, h := range handles {
+				held[h] = struct{}{}
+			}
+		}
+		if _, ok := held[handle]; ok {
+			continue
+		}
+
+		log.Infof("Legal hold of snapshot %s of deleted backup %s is released, removing volumesnapshotcontent %s", handle, backupName, vsc.Name)
+		if err := deleteReleasedSnapshot(ctx, vsc, snapshotClient); err != nil {
+			errs = append(errs, errors.Wrapf(err, "fail to remove released volumesnapshotcontent %s", vsc.Name))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// deleteReleasedSnapshot deletes the volumesnapshotcontent with its storage snapshot, and the volumesnapshot bound to it.
+func deleteReleasedSnapshot(ctx context.Context, vsc *snapshotv1api.VolumeSnapshotContent, snapshotClient snapshotter.SnapshotV1Interface) error {
+	// Setting the DeletionPolicy to Delete makes the CSI snapshot controller delete the storage snapshot.
+	if err := SetVolumeSnapshotContentDeletionPolicy(ctx, vsc.Name, snapshotClient); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "fail to set DeletionPolicy of volumesnapshotcontent %s", vsc.Name)
+	}
+	if ref := vsc.Spec.VolumeSnapshotRef; ref.Name != "" {
+		vs, err := snapshotClient.VolumeSnapshots(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "fail to get volumesnapshot %s/%s", ref.Namespace, ref.Name)
+		}
+		if err == nil && boundVolumeSnapshotContentName(vs) == vsc.Name {
+			if err := snapshotClient.VolumeSnapshots(vs.Namespace).Delete(ctx, vs.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return errors.Wrapf(err, "fail to delete volumesnapshot %s/%s", vs.Namespace, vs.Name)
+			}
+		}
+	}
+	if err := snapshotClient.VolumeSnapshotContents().Delete(ctx, vsc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to delete volumesnapshotcontent %s", vsc.Name)
+	}
+	return nil
+}
+
+func getVolumeSnapshotContentHandle(vsc *snapshotv1api.VolumeSnapshotContent) string {
+	if vsc.Status != nil && vsc.Status.SnapshotHandle != nil {
+		return *vsc.Status.SnapshotHandle
+	}
+	if vsc.Spec.Source.SnapshotHandle != nil {
+		return *vsc.Spec.Source.SnapshotHandle
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+)
+
+func TestListHeldSnapshotHandles(t *testing.T) {
+	newVSC := func(name, backup, handle string, annotations ...string) *snapshotv1api.VolumeSnapshotContent {
+		vsc := builder.ForVolumeSnapshotContent(name).Status(&snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle}).Result()
+		vsc.Labels = map[string]string{velerov1api.BackupNameLabel: backup}
+		if len(annotations) > 0 {
+			vsc.Annotations = map[string]string{annotations[0]: annotations[1]}
+		}
+		return vsc
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		newVSC("vsc-labeled", "labeled", "snap-labeled"),
+		newVSC("vsc-listed", "listed", "snap-listed"),
+		newVSC("vsc-annotated", "free", "snap-annotated", LegalHoldLabel, "case-1234"),
+		newVSC("vsc-of-annotated-vs", "free", "snap-of-annotated-vs"),
+		newVSC("vsc-free", "free", "snap-free"),
+		builder.ForVolumeSnapshot("ns", "vs-annotated").ObjectMeta(builder.WithAnnotations(LegalHoldLabel, "case-1234")).
+			Status().BoundVolumeSnapshotContentName("vsc-of-annotated-vs").Result(),
+		builder.ForVolumeSnapshot("ns", "vs-free").Status().BoundVolumeSnapshotContentName("vsc-free").Result(),
+	)
+	veleroClient := velerofake.NewSimpleClientset(
+		builder.ForBackup("velero", "labeled").ObjectMeta(builder.WithLabels(LegalHoldLabel, "true")).Result(),
+		builder.ForBackup("velero", "free").ObjectMeta(builder.WithLabels(LegalHoldLabel, "false")).Result(),
+	)
+	kubeClient := fake.NewSimpleClientset(
+		builder.ForConfigMap("velero", "legal-holds").ObjectMeta(builder.WithLabels(LegalHoldLabel, "true")).
+			Data("case-1234", "backup:listed\nsnapshot:snap-gone\n", "case-5678", "  snapshot:snap-listed  \nsomething else").Result(),
+		// ConfigMaps without the legal hold label don't hold anything.
+		builder.ForConfigMap("velero", "other").Data("case", "backup:free").Result(),
+	)
+
+	handles, err := ListHeldSnapshotHandles(context.Background(), "velero", kubeClient, snapshotClient.SnapshotV1(), veleroClient)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"snap-annotated", "snap-gone", "snap-labeled", "snap-listed", "snap-of-annotated-vs"}, handles)
+}
+
+func TestLegalHoldsHoldReason(t *testing.T) {
+	holds := &LegalHolds{Backups: map[string]string{"listed": "legal-holds/case"}, Snapshots: map[string]string{"snap-1": "legal-holds/case"}}
+	vs := builder.ForVolumeSnapshot("ns", "vs-1").ObjectMeta(builder.WithAnnotations(LegalHoldLabel, "audit")).Result()
+
+	assert.Equal(t, "backup labeled is labeled velero.io/csi-legal-hold",
+		holds.HoldReason(builder.ForBackup("velero", "labeled").ObjectMeta(builder.WithLabels(LegalHoldLabel, "true")).Result(), ""))
+	assert.Equal(t, "backup listed is held by legal-holds/case", holds.HoldReason(builder.ForBackup("velero", "listed").Result(), ""))
+	assert.Equal(t, "snapshot snap-1 is held by legal-holds/case", holds.HoldReason(builder.ForBackup("velero", "backup").Result(), "snap-1"))
+	assert.Equal(t, "vs-1 is annotated velero.io/csi-legal-hold: audit", holds.HoldReason(builder.ForBackup("velero", "backup").Result(), "snap-2", nil, &vs.ObjectMeta))
+	assert.Empty(t, holds.HoldReason(builder.ForBackup("velero", "backup").Result(), "snap-2", nil))
+}
+
+func TestSweepReleasedSnapshots(t *testing.T) {
+	newVSC := func(name, heldAtDeletionOf string, labels ...string) *snapshotv1api.VolumeSnapshotContent {
+		handle := "snap-" + name
+		vsc := builder.ForVolumeSnapshotContent(name).DeletionPolicy(snapshotv1api.VolumeSnapshotContentRetain).VolumeSnapshotRef("ns", "vs-"+name).
+			Status(&snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle}).Result()
+		vsc.Labels = map[string]string{velerov1api.BackupNameLabel: heldAtDeletionOf}
+		for i := 0; i+1 < len(labels); i += 2 {
+			vsc.Labels[labels[i]] = labels[i+1]
+		}
+		vsc.Annotations = map[string]string{HeldAtDeletionAnnotation: heldAtDeletionOf}
+		return vsc
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		newVSC("released", "deleted"),
+		builder.ForVolumeSnapshot("ns", "vs-released").Status().BoundVolumeSnapshotContentName("released").Result(),
+		newVSC("still-held", "deleted"),
+		newVSC("protected", "deleted", SnapshotProtectedLabel, "true"),
+		newVSC("backup-exists", "live"),
+		newVSC("marked", "deleted"),
+	)
+	// The hold of a deleted backup stays on its volumesnapshotcontent until the annotation is removed.
+	marked, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "marked", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, MarkHeldAtDeletion(context.Background(), marked, "deleted", "backup deleted is labeled velero.io/csi-legal-hold",
+		snapshotClient.SnapshotV1()))
+	kubeClient := fake.NewSimpleClientset(builder.ForConfigMap("velero", "legal-holds").ObjectMeta(builder.WithLabels(LegalHoldLabel, "true")).
+		Data("case-1234", "snapshot:snap-still-held").Result())
+	veleroClient := velerofake.NewSimpleClientset(builder.ForBackup("velero", "live").Result())
+
+	require.NoError(t, SweepReleasedSnapshots(context.Background(), "velero", kubeClient, snapshotClient.SnapshotV1(), veleroClient, logrus.New()))
+
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "released", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-released", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	for _, name := range []string{"still-held", "protected", "backup-exists", "marked"} {
+		vsc, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err, name)
+		assert.Equal(t, snapshotv1api.VolumeSnapshotContentRetain, vsc.Spec.DeletionPolicy, name)
+	}
+
+	// Removing the annotation releases the hold.
+	marked, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "marked", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "backup deleted is labeled velero.io/csi-legal-hold", marked.Annotations[LegalHoldLabel])
+	delete(marked.Annotations, LegalHoldLabel)
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Update(context.Background(), marked, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, SweepReleasedSnapshots(context.Background(), "velero", kubeClient, snapshotClient.SnapshotV1(), veleroClient, logrus.New()))
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "marked", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
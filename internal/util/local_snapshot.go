@@ -0,0 +1,132 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strconv"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+// IsKeepLocalSnapshot returns whether the CSI snapshot of the PVC is kept after the data mover uploaded its data.
+func IsKeepLocalSnapshot(pvc *corev1api.PersistentVolumeClaim, backup *velerov1api.Backup) bool {
+	for _, annotations := range []map[string]string{pvc.Annotations, backup.Annotations} {
+		if value, ok := annotations[KeepLocalSnapshotAnnotation]; ok {
+			keep, err := strconv.ParseBool(value)
+			return err == nil && keep
+		}
+	}
+	return false
+}
+
+// KeepLocalSnapshot keeps the storage snapshot of the VolumeSnapshotContent bound to the data mover's VolumeSnapshot
+// once the data mover removes them. The VolumeSnapshotContent is retained, so removing it doesn't remove the storage
+// snapshot, and a static VolumeSnapshotContent labeled with the backup is created for the storage snapshot.
+// The static VolumeSnapshotContent is backed up, so the storage snapshot is removed with the backup.
+func KeepLocalSnapshot(ctx context.Context, vsc *snapshotv1api.VolumeSnapshotContent, pvc *corev1api.PersistentVolumeClaim,
+	backup *velerov1api.Backup, snapshotClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotContent, error) {
+	if vsc.Status == nil || vsc.Status.SnapshotHandle == nil {
+		return nil, errors.Errorf("volumesnapshotcontent %s has no snapshot handle", vsc.Name)
+	}
+
+	if _, err := snapshotClient.VolumeSnapshotContents().Patch(ctx, vsc.Name, types.MergePatchType,
+		[]byte(`{"spec":{"deletionPolicy":"Retain"}}`), metav1.PatchOptions{}); err != nil {
+		return nil, errors.Wrapf(err, "fail to retain volumesnapshotcontent %s", vsc.Name)
+	}
+
+	// The static volumesnapshotcontent is never bound, its volumesnapshot is only created on restore.
+	name := "velero-local-" + string(vsc.UID)
+	local := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				velerov1api.BackupNameLabel: label.GetValidName(backup.Name),
+			},
+			Annotations: map[string]string{
+				LocalSnapshotSourceAnnotation: pvc.Namespace + "/" + pvc.Name,
+			},
+		},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{
+			DeletionPolicy:          snapshotv1api.VolumeSnapshotContentRetain,
+			Driver:                  vsc.Spec.Driver,
+			VolumeSnapshotClassName: vsc.Spec.VolumeSnapshotClassName,
+			VolumeSnapshotRef: corev1api.ObjectReference{
+				Kind:      VolumeSnapshotKindName,
+				Namespace: pvc.Namespace,
+				Name:      name,
+			},
+			Source: snapshotv1api.VolumeSnapshotContentSource{
+				SnapshotHandle: vsc.Status.SnapshotHandle,
+			},
+		},
+	}
+	local, err := snapshotClient.VolumeSnapshotContents().Create(ctx, local, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to create volumesnapshotcontent %s for the local snapshot", name)
+	}
+	return local, nil
+}
+
+// ReleaseLocalSnapshot reverts KeepLocalSnapshot when the data mover didn't start, so removing the data mover's
+// VolumeSnapshot removes the storage snapshot again.
+func ReleaseLocalSnapshot(ctx context.Context, vsc, local *snapshotv1api.VolumeSnapshotContent, snapshotClient snapshotter.SnapshotV1Interface) error {
+	if err := snapshotClient.VolumeSnapshotContents().Delete(ctx, local.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to delete volumesnapshotcontent %s of the local snapshot", local.Name)
+	}
+	if err := SetVolumeSnapshotContentDeletionPolicy(ctx, vsc.Name, snapshotClient); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to set DeletionPolicy of volumesnapshotcontent %s", vsc.Name)
+	}
+	return nil
+}
+
+// IsLocalSnapshotContent returns whether the VolumeSnapshotContent keeps the local snapshot of a PVC.
+func IsLocalSnapshotContent(vsc *snapshotv1api.VolumeSnapshotContent) bool {
+	_, ok := vsc.Annotations[LocalSnapshotSourceAnnotation]
+	return ok
+}
+
+// GetLocalSnapshotContent returns the VolumeSnapshotContent keeping the local snapshot recorded on the backed-up PVC,
+// or nil when the PVC has none or it doesn't exist in the cluster, for example because the backup is restored in another cluster.
+func GetLocalSnapshotContent(ctx context.Context, pvc *corev1api.PersistentVolumeClaim, snapshotClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotContent, error) {
+	name, ok := pvc.Annotations[LocalSnapshotContentAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	vsc, err := snapshotClient.VolumeSnapshotContents().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "fail to get volumesnapshotcontent %s of the local snapshot", name)
+	}
+	if vsc.DeletionTimestamp != nil || !IsLocalSnapshotContent(vsc) || vsc.Spec.Source.SnapshotHandle == nil ||
+		*vsc.Spec.Source.SnapshotHandle != pvc.Annotations[VolumeSnapshotHandleAnnotation] {
+		return nil, nil
+	}
+	return vsc, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+)
+
+func TestIsKeepLocalSnapshot(t *testing.T) {
+	tests := []struct {
+		name              string
+		backupAnnotations map[string]string
+		pvcAnnotations    map[string]string
+		expected          bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:              "set on the backup",
+			backupAnnotations: map[string]string{KeepLocalSnapshotAnnotation: "true"},
+			expected:          true,
+		},
+		{
+			name:              "PVC overrides the backup",
+			backupAnnotations: map[string]string{KeepLocalSnapshotAnnotation: "true"},
+			pvcAnnotations:    map[string]string{KeepLocalSnapshotAnnotation: "false"},
+		},
+		{
+			name:           "invalid value",
+			pvcAnnotations: map[string]string{KeepLocalSnapshotAnnotation: "yes please"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotationsMap(tc.backupAnnotations)).Result()
+			pvc := builder.ForPersistentVolumeClaim("ns", "pvc").ObjectMeta(builder.WithAnnotationsMap(tc.pvcAnnotations)).Result()
+			assert.Equal(t, tc.expected, IsKeepLocalSnapshot(pvc, backup))
+		})
+	}
+}
+
+func TestKeepLocalSnapshot(t *testing.T) {
+	handle := "snap-handle"
+	vsc := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsc-1", UID: "vsc-uid"},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{
+			DeletionPolicy: snapshotv1api.VolumeSnapshotContentDelete,
+			Driver:         "driver",
+		},
+		Status: &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle},
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(vsc)
+	backup := builder.ForBackup("velero", "backup").Result()
+	pvc := builder.ForPersistentVolumeClaim("ns", "pvc").Result()
+
+	local, err := KeepLocalSnapshot(context.Background(), vsc, pvc, backup, snapshotClient.SnapshotV1())
+	require.NoError(t, err)
+	assert.True(t, IsLocalSnapshotContent(local))
+	assert.Equal(t, "backup", local.Labels[velerov1api.BackupNameLabel])
+	assert.Equal(t, snapshotv1api.VolumeSnapshotContentRetain, local.Spec.DeletionPolicy)
+	assert.Equal(t, handle, *local.Spec.Source.SnapshotHandle)
+
+	retained, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), vsc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, snapshotv1api.VolumeSnapshotContentRetain, retained.Spec.DeletionPolicy)
+
+	// The local snapshot is found from the annotations recorded on the backed-up PVC.
+	pvc.Annotations = map[string]string{LocalSnapshotContentAnnotation: local.Name, VolumeSnapshotHandleAnnotation: handle}
+	found, err := GetLocalSnapshotContent(context.Background(), pvc, snapshotClient.SnapshotV1())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, local.Name, found.Name)
+
+	pvc.Annotations[VolumeSnapshotHandleAnnotation] = "other-handle"
+	found, err = GetLocalSnapshotContent(context.Background(), pvc, snapshotClient.SnapshotV1())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	require.NoError(t, ReleaseLocalSnapshot(context.Background(), vsc, local, snapshotClient.SnapshotV1()))
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), local.Name, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	released, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), vsc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, snapshotv1api.VolumeSnapshotContentDelete, released.Spec.DeletionPolicy)
+
+	pvc.Annotations[VolumeSnapshotHandleAnnotation] = handle
+	found, err = GetLocalSnapshotContent(context.Background(), pvc, snapshotClient.SnapshotV1())
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// operationIDVersion is the version of the encoded operation IDs returned by the backup item actions.
+	operationIDVersion = 1
+	// operationIDPrefix starts the encoded operation IDs. The base64 URL alphabet has no slash,
+	// so an encoded operation ID is never mistaken for a legacy one.
+	operationIDPrefix = "csi.v1."
+)
+
+// OperationID is the state of an asynchronous operation of the VolumeSnapshot and VolumeSnapshotContent backup
+// item actions. Velero stores it in the backup and passes it back to Progress and Cancel, possibly in another plugin process.
+type OperationID struct {
+	Version   int       `json:"v"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid,omitempty"`
+	Started   time.Time `json:"started"`
+	BackupUID types.UID `json:"backupUID,omitempty"`
+}
+
+// Encode returns the operation ID as the string passed to Velero.
+func (o OperationID) Encode() (string, error) {
+	o.Version = operationIDVersion
+	data, err := json.Marshal(o)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to marshal operation ID")
+	}
+	return operationIDPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeOperationID decodes an operation ID of the kind returned by Encode. It also accepts the legacy formats
+// <namespace>/<volumesnapshot-name>/<started-time> and <volumesnapshotcontent-name>/<started-time>,
+// which carry no UIDs.
+func DecodeOperationID(operationID, kind string) (*OperationID, error) {
+	if encoded := strings.TrimPrefix(operationID, operationIDPrefix); encoded != operationID {
+		data, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to decode operation ID %s", operationID)
+		}
+		id := &OperationID{}
+		if err := json.Unmarshal(data, id); err != nil {
+			return nil, errors.Wrapf(err, "fail to unmarshal operation ID %s", operationID)
+		}
+		if id.Version != operationIDVersion {
+			return nil, errors.Errorf("unsupported version %d of operation ID %s", id.Version, operationID)
+		}
+		if id.Kind != kind {
+			return nil, errors.Errorf("operation ID %s is for a %s, not a %s", operationID, id.Kind, kind)
+		}
+		return id, nil
+	}
+
+	return decodeLegacyOperationID(operationID, kind)
+}
+
+func decodeLegacyOperationID(operationID, kind string) (*OperationID, error) {
+	parts := strings.Split(operationID, "/")
+	id := &OperationID{Kind: kind}
+	var started string
+	switch {
+	case kind == VolumeSnapshotKindName && len(parts) == 3:
+		id.Namespace, id.Name, started = parts[0], parts[1], parts[2]
+	case kind == VolumeSnapshotContentKindName && len(parts) == 2:
+		id.Name, started = parts[0], parts[1]
+	default:
+		return nil, errors.Errorf("invalid %s operation ID %s", kind, operationID)
+	}
+
+	var err error
+	if id.Started, err = time.Parse(time.RFC3339, started); err != nil {
+		return nil, errors.Wrapf(err, "fail to parse StartedTime of operation ID %s", operationID)
+	}
+	return id, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeOperationID(t *testing.T) {
+	started := time.Date(2023, 6, 1, 10, 30, 0, 0, time.UTC)
+	encoded, err := OperationID{
+		Kind:      VolumeSnapshotKindName,
+		Namespace: "ns",
+		Name:      "vs-1",
+		UID:       "vs-uid",
+		Started:   started,
+		BackupUID: "backup-uid",
+	}.Encode()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		operationID string
+		kind        string
+		expected    *OperationID
+		expectErr   bool
+	}{
+		{
+			name:        "encoded volumesnapshot operation",
+			operationID: encoded,
+			kind:        VolumeSnapshotKindName,
+			expected: &OperationID{
+				Version:   operationIDVersion,
+				Kind:      VolumeSnapshotKindName,
+				Namespace: "ns",
+				Name:      "vs-1",
+				UID:       "vs-uid",
+				Started:   started,
+				BackupUID: "backup-uid",
+			},
+		},
+		{
+			name:        "encoded operation of another kind",
+			operationID: encoded,
+			kind:        VolumeSnapshotContentKindName,
+			expectErr:   true,
+		},
+		{
+			name:        "legacy volumesnapshot operation",
+			operationID: "ns/vs-1/2023-06-01T10:30:00Z",
+			kind:        VolumeSnapshotKindName,
+			expected:    &OperationID{Kind: VolumeSnapshotKindName, Namespace: "ns", Name: "vs-1", Started: started},
+		},
+		{
+			name:        "legacy volumesnapshotcontent operation",
+			operationID: "vsc-1/2023-06-01T10:30:00Z",
+			kind:        VolumeSnapshotContentKindName,
+			expected:    &OperationID{Kind: VolumeSnapshotContentKindName, Name: "vsc-1", Started: started},
+		},
+		{
+			name:        "legacy operation with the wrong number of parts",
+			operationID: "vsc-1/2023-06-01T10:30:00Z",
+			kind:        VolumeSnapshotKindName,
+			expectErr:   true,
+		},
+		{
+			name:        "legacy operation with an invalid time",
+			operationID: "ns/vs-1/yesterday",
+			kind:        VolumeSnapshotKindName,
+			expectErr:   true,
+		},
+		{
+			name:        "corrupted encoded operation",
+			operationID: operationIDPrefix + "not base64!",
+			kind:        VolumeSnapshotKindName,
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := DecodeOperationID(tc.operationID, tc.kind)
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tc.expected.Started.Equal(id.Started))
+			id.Started = tc.expected.Started
+			assert.Equal(t, tc.expected, id)
+		})
+	}
+}
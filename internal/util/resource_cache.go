@@ -0,0 +1,231 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
+	snapshotinformers "github.com/kubernetes-csi/external-snapshotter/client/v4/informers/externalversions"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podsByClaimIndex indexes pods by the namespace and name of the PVCs they mount.
+const podsByClaimIndex = "pvc"
+
+// cacheSyncTimeout is how long the ResourceCache waits for an informer to sync before reading from the API server instead.
+var cacheSyncTimeout = time.Minute
+
+// ResourceCache serves the StorageClasses, VolumeSnapshotClasses and Pods read by the plugin from shared informers.
+// Each informer is started on its first use, so a plugin process only watches what its actions read. Pods are watched
+// per namespace, only in the namespaces whose PVCs the actions look up.
+// When an informer cannot sync, the reads go to the API server.
+type ResourceCache struct {
+	kubeClient        kubernetes.Interface
+	snapshotClient    snapshotterClientSet.Interface
+	kubeInformers     informers.SharedInformerFactory
+	snapshotInformers snapshotinformers.SharedInformerFactory
+
+	lock         sync.Mutex
+	syncs        map[cache.SharedIndexInformer]*informerSync
+	podInformers map[string]*podInformer
+	stopCh       chan struct{}
+}
+
+// informerSync is the outcome of starting an informer, which is waited for once by the first read.
+type informerSync struct {
+	once   sync.Once
+	synced bool
+}
+
+// podInformer watches the pods of a namespace, indexed by the PVCs they mount.
+type podInformer struct {
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	indexErr error
+}
+
+var (
+	sharedResourceCache     *ResourceCache
+	sharedResourceCacheErr  error
+	sharedResourceCacheOnce sync.Once
+)
+
+// NewResourceCache returns a ResourceCache reading through the clients.
+func NewResourceCache(kubeClient kubernetes.Interface, snapshotClient snapshotterClientSet.Interface) *ResourceCache {
+	return &ResourceCache{
+		kubeClient:        kubeClient,
+		snapshotClient:    snapshotClient,
+		kubeInformers:     informers.NewSharedInformerFactory(kubeClient, 0),
+		snapshotInformers: snapshotinformers.NewSharedInformerFactory(snapshotClient, 0),
+		syncs:             map[cache.SharedIndexInformer]*informerSync{},
+		podInformers:      map[string]*podInformer{},
+		stopCh:            make(chan struct{}),
+	}
+}
+
+// GetResourceCache returns the ResourceCache shared by all actions of the plugin process, built from the clients of GetFullClients.
+func GetResourceCache() (*ResourceCache, error) {
+	sharedResourceCacheOnce.Do(func() {
+		client, snapshotClient, _, err := GetFullClients()
+		if err != nil {
+			sharedResourceCacheErr = err
+			return
+		}
+		sharedResourceCache = NewResourceCache(client, snapshotClient)
+	})
+
+	return sharedResourceCache, sharedResourceCacheErr
+}
+
+// GetStorageClass returns the StorageClass with the name.
+func (c *ResourceCache) GetStorageClass(ctx context.Context, name string, log logrus.FieldLogger) (*storagev1api.StorageClass, error) {
+	informer := c.kubeInformers.Storage().V1().StorageClasses()
+	if c.waitForSync(informer.Informer(), c.kubeInformers.Start, log) {
+		storageClass, err := informer.Lister().Get(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting storage class %s", name)
+		}
+		return storageClass, nil
+	}
+
+	storageClass, err := c.kubeClient.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting storage class %s", name)
+	}
+	return storageClass, nil
+}
+
+// ListVolumeSnapshotClasses returns all VolumeSnapshotClasses.
+func (c *ResourceCache) ListVolumeSnapshotClasses(ctx context.Context, log logrus.FieldLogger) (*snapshotv1api.VolumeSnapshotClassList, error) {
+	informer := c.snapshotInformers.Snapshot().V1().VolumeSnapshotClasses()
+	if c.waitForSync(informer.Informer(), c.snapshotInformers.Start, log) {
+		classes, err := informer.Lister().List(labels.Everything())
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing volumesnapshot classes")
+		}
+		list := &snapshotv1api.VolumeSnapshotClassList{}
+		for _, class := range classes {
+			list.Items = append(list.Items, *class)
+		}
+		return list, nil
+	}
+
+	list, err := c.snapshotClient.SnapshotV1().VolumeSnapshotClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing volumesnapshot classes")
+	}
+	return list, nil
+}
+
+// GetPodsUsingPVC returns the pods in the namespace of the PVC that mount it.
+// The pods are looked up in an index by PVC, so the cost doesn't grow with the number of pods in the namespace.
+func (c *ResourceCache) GetPodsUsingPVC(ctx context.Context, pvcNamespace, pvcName string, log logrus.FieldLogger) ([]corev1api.Pod, error) {
+	pods := c.getPodInformer(pvcNamespace)
+	if pods.indexErr != nil {
+		log.WithError(pods.indexErr).Warn("Fail to index pods by PVC, reading from the API server instead")
+		return GetPodsUsingPVC(ctx, pvcNamespace, pvcName, c.kubeClient.CoreV1())
+	}
+	if !c.waitForSync(pods.informer, pods.factory.Start, log) {
+		return GetPodsUsingPVC(ctx, pvcNamespace, pvcName, c.kubeClient.CoreV1())
+	}
+
+	informer := pods.informer
+	objs, err := informer.GetIndexer().ByIndex(podsByClaimIndex, pvcNamespace+"/"+pvcName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting pods using PVC %s/%s", pvcNamespace, pvcName)
+	}
+	podsUsingPVC := []corev1api.Pod{}
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1api.Pod)
+		if !ok {
+			continue
+		}
+		podsUsingPVC = append(podsUsingPVC, *pod)
+	}
+	return podsUsingPVC, nil
+}
+
+// getPodInformer returns the informer of the pods in the namespace, creating it on the first call for the namespace.
+func (c *ResourceCache) getPodInformer(namespace string) *podInformer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if pods, ok := c.podInformers[namespace]; ok {
+		return pods
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(c.kubeClient, 0, informers.WithNamespace(namespace))
+	informer := factory.Core().V1().Pods().Informer()
+	// Indexers must be added before the informer is started.
+	pods := &podInformer{
+		factory:  factory,
+		informer: informer,
+		indexErr: informer.AddIndexers(cache.Indexers{podsByClaimIndex: podClaimIndexFunc}),
+	}
+	c.podInformers[namespace] = pods
+	return pods
+}
+
+// podClaimIndexFunc returns the namespace/name keys of the PVCs mounted by the pod.
+func podClaimIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1api.Pod)
+	if !ok {
+		return nil, nil
+	}
+
+	keys := []string{}
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			keys = append(keys, pod.Namespace+"/"+v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return keys, nil
+}
+
+// waitForSync starts the informer if needed and returns whether its cache synced. The lock only guards the lookup,
+// so reads of other informers don't wait for this one to sync.
+func (c *ResourceCache) waitForSync(informer cache.SharedIndexInformer, start func(<-chan struct{}), log logrus.FieldLogger) bool {
+	c.lock.Lock()
+	state, ok := c.syncs[informer]
+	if !ok {
+		state = &informerSync{}
+		c.syncs[informer] = state
+	}
+	c.lock.Unlock()
+
+	state.once.Do(func() {
+		start(c.stopCh)
+		ctx, cancel := context.WithTimeout(context.Background(), cacheSyncTimeout)
+		defer cancel()
+		state.synced = cache.WaitForCacheSync(ctx.Done(), informer.HasSynced)
+		if !state.synced {
+			log.Warn("Timed out waiting for the informer cache to sync, reading from the API server instead")
+		}
+	})
+	return state.synced
+}
@@ -0,0 +1,169 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestResourceCache(t *testing.T) {
+	podUsingPVC := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod-1"},
+		Spec: corev1api.PodSpec{
+			Volumes: []corev1api.Volume{
+				{
+					Name: "data",
+					VolumeSource: corev1api.VolumeSource{
+						PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-1"},
+					},
+				},
+			},
+		},
+	}
+	podNotUsingPVC := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod-2"},
+	}
+	podInOtherNamespace := podUsingPVC.DeepCopy()
+	podInOtherNamespace.Namespace = "other-ns"
+
+	kubeClient := fake.NewSimpleClientset(
+		&storagev1api.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "sc-1"}, Provisioner: "hostpath.csi.k8s.io"},
+		podUsingPVC, podNotUsingPVC, podInOtherNamespace,
+	)
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		&snapshotv1api.VolumeSnapshotClass{ObjectMeta: metav1.ObjectMeta{Name: "vsclass-1"}, Driver: "hostpath.csi.k8s.io"},
+	)
+	resourceCache := NewResourceCache(kubeClient, snapshotClient)
+
+	storageClass, err := resourceCache.GetStorageClass(context.Background(), "sc-1", logrus.New())
+	require.NoError(t, err)
+	assert.Equal(t, "hostpath.csi.k8s.io", storageClass.Provisioner)
+
+	_, err = resourceCache.GetStorageClass(context.Background(), "missing", logrus.New())
+	assert.Error(t, err)
+
+	classes, err := resourceCache.ListVolumeSnapshotClasses(context.Background(), logrus.New())
+	require.NoError(t, err)
+	require.Len(t, classes.Items, 1)
+	assert.Equal(t, "vsclass-1", classes.Items[0].Name)
+
+	pods, err := resourceCache.GetPodsUsingPVC(context.Background(), "ns", "pvc-1", logrus.New())
+	require.NoError(t, err)
+	require.Len(t, pods, 1)
+	assert.Equal(t, "pod-1", pods[0].Name)
+	// The pods are only watched in the namespaces looked up.
+	assert.Len(t, resourceCache.podInformers["ns"].informer.GetStore().List(), 2)
+
+	pods, err = resourceCache.GetPodsUsingPVC(context.Background(), "other-ns", "pvc-1", logrus.New())
+	require.NoError(t, err)
+	require.Len(t, pods, 1)
+	assert.Equal(t, "other-ns", pods[0].Namespace)
+	assert.Len(t, resourceCache.podInformers["other-ns"].informer.GetStore().List(), 1)
+}
+
+func TestSetClientRateLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		qps           string
+		burst         string
+		expectedQPS   float32
+		expectedBurst int
+		expectError   bool
+	}{
+		{
+			name:          "unset keeps the config",
+			expectedQPS:   5,
+			expectedBurst: 10,
+		},
+		{
+			name:          "set from the environment",
+			qps:           "50",
+			burst:         "100",
+			expectedQPS:   50,
+			expectedBurst: 100,
+		},
+		{
+			name:        "invalid QPS",
+			qps:         "-1",
+			expectError: true,
+		},
+		{
+			name:        "invalid burst",
+			burst:       "many",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(ClientQPSEnv, tc.qps)
+			t.Setenv(ClientBurstEnv, tc.burst)
+
+			clientConfig := &rest.Config{QPS: 5, Burst: 10}
+			err := setClientRateLimits(clientConfig)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedQPS, clientConfig.QPS)
+			assert.Equal(t, tc.expectedBurst, clientConfig.Burst)
+		})
+	}
+}
+
+func TestPodClaimIndexFunc(t *testing.T) {
+	pod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod-1"},
+		Spec: corev1api.PodSpec{
+			Volumes: []corev1api.Volume{
+				{
+					Name:         "data",
+					VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-1"}},
+				},
+				{
+					Name:         "config",
+					VolumeSource: corev1api.VolumeSource{ConfigMap: &corev1api.ConfigMapVolumeSource{}},
+				},
+				{
+					Name:         "logs",
+					VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-2"}},
+				},
+			},
+		},
+	}
+
+	keys, err := podClaimIndexFunc(pod)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns/pvc-1", "ns/pvc-2"}, keys)
+
+	keys, err = podClaimIndexFunc(&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "pod-2"}})
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
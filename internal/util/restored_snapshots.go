@@ -0,0 +1,167 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+	"github.com/vmware-tanzu/velero/pkg/label"
+)
+
+// IsCleanupRestoredSnapshots returns whether the restore asks to remove the volumesnapshots it recreated once
+// the PVCs provisioned from them are bound.
+func IsCleanupRestoredSnapshots(restore *velerov1api.Restore) bool {
+	cleanup, err := strconv.ParseBool(restore.Annotations[CleanupRestoredSnapshotsAnnotation])
+	return err == nil && cleanup
+}
+
+// SweepRestoredSnapshots removes the volumesnapshots recreated by the finished restores in restoreNamespace that ask for it,
+// together with their volumesnapshotcontents, once every PVC provisioned from a volumesnapshot is bound. The sweep is scoped
+// by the restore name label Velero puts on the restored volumesnapshots. Only volumesnapshotcontents retaining their storage
+// snapshot are removed, so the storage snapshots, which belong to the backups, are kept.
+func SweepRestoredSnapshots(ctx context.Context, restoreNamespace string, kubeClient kubernetes.Interface, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface, log logrus.FieldLogger) error {
+	restoreList, err := veleroClient.VeleroV1().Restores(restoreNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error listing restores")
+	}
+
+	errs := []error{}
+	for i := range restoreList.Items {
+		restore := &restoreList.Items[i]
+		if !IsCleanupRestoredSnapshots(restore) || !isRestoreFinished(restore) {
+			continue
+		}
+		restoreLabel := label.GetValidName(restore.Name)
+		vsList, err := snapshotClient.VolumeSnapshots("").List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", velerov1api.RestoreNameLabel, restoreLabel),
+		})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "error listing volumesnapshots of restore %s", restore.Name))
+			continue
+		}
+
+		for j := range vsList.Items {
+			vs := &vsList.Items[j]
+			// The volumesnapshots of a snapshots-only restore are what the restore is for.
+			if _, ok := vs.Labels[SnapshotsOnlyRestoreUIDLabel]; ok {
+				continue
+			}
+			if err := cleanupRestoredSnapshot(ctx, vs, restoreLabel, kubeClient, snapshotClient, log); err != nil {
+				errs = append(errs, errors.Wrapf(err, "fail to clean up volumesnapshot %s/%s of restore %s", vs.Namespace, vs.Name, restore.Name))
+			}
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func isRestoreFinished(restore *velerov1api.Restore) bool {
+	switch restore.Status.Phase {
+	case velerov1api.RestorePhaseCompleted, velerov1api.RestorePhasePartiallyFailed, velerov1api.RestorePhaseFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// cleanupRestoredSnapshot removes the restored volumesnapshot and its volumesnapshotcontent when the PVCs provisioned
+// from the volumesnapshot are all bound.
+func cleanupRestoredSnapshot(ctx context.Context, vs *snapshotv1api.VolumeSnapshot, restoreLabel string, kubeClient kubernetes.Interface,
+	snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) error {
+	pvcList, err := kubeClient.CoreV1().PersistentVolumeClaims(vs.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error listing PVCs in namespace %s", vs.Namespace)
+	}
+	provisioned := 0
+	for _, pvc := range pvcList.Items {
+		if !isPVCProvisionedFromVolumeSnapshot(&pvc, vs.Name) {
+			continue
+		}
+		if pvc.Status.Phase != corev1api.ClaimBound {
+			log.Debugf("PVC %s/%s provisioned from volumesnapshot %s is not bound yet", pvc.Namespace, pvc.Name, vs.Name)
+			return nil
+		}
+		provisioned++
+	}
+	// A volumesnapshot no PVC is provisioned from was restored for its own sake.
+	if provisioned == 0 {
+		return nil
+	}
+
+	vscName := boundVolumeSnapshotContentName(vs)
+	var vsc *snapshotv1api.VolumeSnapshotContent
+	if vscName != "" {
+		vsc, err = snapshotClient.VolumeSnapshotContents().Get(ctx, vscName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error getting volumesnapshotcontent %s", vscName)
+		}
+		if err == nil && vsc.Spec.DeletionPolicy != snapshotv1api.VolumeSnapshotContentRetain {
+			log.Warnf("Volumesnapshotcontent %s of restored volumesnapshot %s/%s doesn't retain its storage snapshot, keep them", vscName, vs.Namespace, vs.Name)
+			return nil
+		}
+		if err == nil && vsc.Labels[velerov1api.RestoreNameLabel] != restoreLabel {
+			vsc = nil
+		}
+	}
+
+	log.Infof("PVCs provisioned from restored volumesnapshot %s/%s are bound, removing the volumesnapshot", vs.Namespace, vs.Name)
+	if err := snapshotClient.VolumeSnapshots(vs.Namespace).Delete(ctx, vs.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error deleting volumesnapshot %s/%s", vs.Namespace, vs.Name)
+	}
+	if vsc != nil {
+		if err := snapshotClient.VolumeSnapshotContents().Delete(ctx, vsc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error deleting volumesnapshotcontent %s", vsc.Name)
+		}
+	}
+	return nil
+}
+
+// boundVolumeSnapshotContentName returns the name of the volumesnapshotcontent the volumesnapshot is bound to,
+// or statically binds to when it is not bound yet.
+func boundVolumeSnapshotContentName(vs *snapshotv1api.VolumeSnapshot) string {
+	if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil {
+		return *vs.Status.BoundVolumeSnapshotContentName
+	}
+	if vs.Spec.Source.VolumeSnapshotContentName != nil {
+		return *vs.Spec.Source.VolumeSnapshotContentName
+	}
+	return ""
+}
+
+// isPVCProvisionedFromVolumeSnapshot returns whether the PVC is provisioned from the volumesnapshot in its namespace.
+func isPVCProvisionedFromVolumeSnapshot(pvc *corev1api.PersistentVolumeClaim, vsName string) bool {
+	if ds := pvc.Spec.DataSource; ds != nil && ds.Kind == VolumeSnapshotKindName && ds.Name == vsName {
+		return true
+	}
+	ref := pvc.Spec.DataSourceRef
+	return ref != nil && ref.Kind == VolumeSnapshotKindName && ref.Name == vsName
+}
@@ -0,0 +1,109 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+)
+
+func TestSweepRestoredSnapshots(t *testing.T) {
+	newVS := func(name, restoreName string) *snapshotv1api.VolumeSnapshot {
+		vscName := "vsc-" + name
+		vs := builder.ForVolumeSnapshot("ns", name).ObjectMeta(builder.WithLabels(velerov1api.RestoreNameLabel, restoreName)).Result()
+		vs.Spec.Source.VolumeSnapshotContentName = &vscName
+		return vs
+	}
+	newVSC := func(name, restoreName string, policy snapshotv1api.DeletionPolicy) *snapshotv1api.VolumeSnapshotContent {
+		vsc := builder.ForVolumeSnapshotContent(name).DeletionPolicy(policy).Result()
+		vsc.Labels = map[string]string{velerov1api.RestoreNameLabel: restoreName}
+		return vsc
+	}
+	newPVC := func(name, vsName string, phase corev1api.PersistentVolumeClaimPhase) *corev1api.PersistentVolumeClaim {
+		return builder.ForPersistentVolumeClaim("ns", name).DataSource(&corev1api.TypedLocalObjectReference{
+			Kind: VolumeSnapshotKindName,
+			Name: vsName,
+		}).Phase(phase).Result()
+	}
+	newRestore := func(name string, cleanup bool, phase velerov1api.RestorePhase) *velerov1api.Restore {
+		restore := builder.ForRestore("velero", name).Phase(phase).Result()
+		if cleanup {
+			restore.Annotations = map[string]string{CleanupRestoredSnapshotsAnnotation: "true"}
+		}
+		return restore
+	}
+
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		newVS("vs-bound", "restore"), newVSC("vsc-vs-bound", "restore", snapshotv1api.VolumeSnapshotContentRetain),
+		newVS("vs-pending", "restore"), newVSC("vsc-vs-pending", "restore", snapshotv1api.VolumeSnapshotContentRetain),
+		newVS("vs-unused", "restore"), newVSC("vsc-vs-unused", "restore", snapshotv1api.VolumeSnapshotContentRetain),
+		newVS("vs-deleting", "restore"), newVSC("vsc-vs-deleting", "restore", snapshotv1api.VolumeSnapshotContentDelete),
+		newVS("vs-kept", "kept"), newVSC("vsc-vs-kept", "kept", snapshotv1api.VolumeSnapshotContentRetain),
+		newVS("vs-ongoing", "ongoing"), newVSC("vsc-vs-ongoing", "ongoing", snapshotv1api.VolumeSnapshotContentRetain),
+	)
+	kubeClient := fake.NewSimpleClientset(
+		newPVC("pvc-bound", "vs-bound", corev1api.ClaimBound),
+		newPVC("pvc-pending", "vs-pending", corev1api.ClaimPending),
+		newPVC("pvc-deleting", "vs-deleting", corev1api.ClaimBound),
+		newPVC("pvc-kept", "vs-kept", corev1api.ClaimBound),
+		newPVC("pvc-ongoing", "vs-ongoing", corev1api.ClaimBound),
+	)
+	veleroClient := velerofake.NewSimpleClientset(
+		newRestore("restore", true, velerov1api.RestorePhaseCompleted),
+		newRestore("kept", false, velerov1api.RestorePhaseCompleted),
+		newRestore("ongoing", true, velerov1api.RestorePhaseInProgress),
+	)
+
+	require.NoError(t, SweepRestoredSnapshots(context.Background(), "velero", kubeClient, snapshotClient.SnapshotV1(), veleroClient, logrus.New()))
+
+	tests := []struct {
+		vs      string
+		removed bool
+	}{
+		{vs: "vs-bound", removed: true},
+		// The PVC provisioned from the volumesnapshot is not bound yet.
+		{vs: "vs-pending"},
+		// No PVC is provisioned from the volumesnapshot.
+		{vs: "vs-unused"},
+		// The volumesnapshotcontent would delete the storage snapshot of the backup.
+		{vs: "vs-deleting"},
+		// The restore doesn't ask for the cleanup.
+		{vs: "vs-kept"},
+		// The restore is not finished.
+		{vs: "vs-ongoing"},
+	}
+	for _, tc := range tests {
+		_, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), tc.vs, metav1.GetOptions{})
+		assert.Equal(t, tc.removed, apierrors.IsNotFound(err), tc.vs)
+		_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-"+tc.vs, metav1.GetOptions{})
+		assert.Equal(t, tc.removed, apierrors.IsNotFound(err), tc.vs)
+	}
+}
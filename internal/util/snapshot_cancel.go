@@ -0,0 +1,122 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+// CancelVolumeSnapshot removes the VolumeSnapshot of a canceled backup operation when it is not ReadyToUse yet.
+// The CSI specification doesn't support canceling a snapshot creation, so the VolumeSnapshot is deleted with
+// a Delete DeletionPolicy on its VolumeSnapshotContent, which makes the CSI driver remove the storage snapshot.
+// A VolumeSnapshot that is already ReadyToUse is kept, it is removed with the backup. When uid is set, a VolumeSnapshot
+// with another UID was recreated under the same name after the operation started, and it is kept too.
+func CancelVolumeSnapshot(ctx context.Context, namespace, name string, uid types.UID, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) error {
+	vs, err := snapshotClient.VolumeSnapshots(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "fail to get volumesnapshot %s/%s", namespace, name)
+	}
+	if uid != "" && vs.UID != uid {
+		log.Infof("Volumesnapshot %s/%s is recreated with UID %s, keep it", namespace, name, vs.UID)
+		return nil
+	}
+	if vs.Status != nil && boolptr.IsSetToTrue(vs.Status.ReadyToUse) {
+		log.Infof("Volumesnapshot %s/%s is ready to use, keep it", namespace, name)
+		return nil
+	}
+
+	// Mark the volumesnapshot first, so Progress reports the operation as canceled while it is being deleted.
+	if _, err := snapshotClient.VolumeSnapshots(namespace).Patch(ctx, name, types.MergePatchType,
+		canceledPatch(""), metav1.PatchOptions{}); err != nil {
+		return errors.Wrapf(err, "fail to mark volumesnapshot %s/%s canceled", namespace, name)
+	}
+
+	CleanupVolumeSnapshot(ctx, vs, snapshotClient, log)
+	return nil
+}
+
+// CancelVolumeSnapshotContent removes the VolumeSnapshotContent of a canceled backup operation when it is not
+// ReadyToUse yet, together with its VolumeSnapshot and the storage snapshot. When uid is set, only the
+// VolumeSnapshotContent with this UID is removed.
+func CancelVolumeSnapshotContent(ctx context.Context, name string, uid types.UID, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) error {
+	vsc, err := snapshotClient.VolumeSnapshotContents().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "fail to get volumesnapshotcontent %s", name)
+	}
+	if uid != "" && vsc.UID != uid {
+		log.Infof("Volumesnapshotcontent %s is recreated with UID %s, keep it", name, vsc.UID)
+		return nil
+	}
+	if vsc.Status != nil && boolptr.IsSetToTrue(vsc.Status.ReadyToUse) {
+		log.Infof("Volumesnapshotcontent %s is ready to use, keep it", name)
+		return nil
+	}
+
+	if _, err := snapshotClient.VolumeSnapshotContents().Patch(ctx, name, types.MergePatchType,
+		canceledPatch(snapshotv1api.VolumeSnapshotContentDelete), metav1.PatchOptions{}); err != nil {
+		return errors.Wrapf(err, "fail to mark volumesnapshotcontent %s canceled", name)
+	}
+
+	ref := vsc.Spec.VolumeSnapshotRef
+	if vs, err := snapshotClient.VolumeSnapshots(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{}); err == nil {
+		if ref.UID == "" || vs.UID == ref.UID {
+			// Deleting the volumesnapshot makes the snapshot controller delete the volumesnapshotcontent.
+			return CancelVolumeSnapshot(ctx, ref.Namespace, ref.Name, ref.UID, snapshotClient, log)
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to get volumesnapshot %s/%s", ref.Namespace, ref.Name)
+	}
+
+	log.Infof("Deleting volumesnapshotcontent %s", name)
+	if err := snapshotClient.VolumeSnapshotContents().Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to delete volumesnapshotcontent %s", name)
+	}
+	return nil
+}
+
+// IsSnapshotCanceled returns whether the backup operation of the VolumeSnapshot or VolumeSnapshotContent was canceled.
+func IsSnapshotCanceled(o *metav1.ObjectMeta) bool {
+	_, ok := o.Annotations[SnapshotCanceledAnnotation]
+	return ok
+}
+
+func canceledPatch(deletionPolicy snapshotv1api.DeletionPolicy) []byte {
+	spec := ""
+	if deletionPolicy != "" {
+		spec = fmt.Sprintf(`,"spec":{"deletionPolicy":"%s"}`, deletionPolicy)
+	}
+	return []byte(fmt.Sprintf(`{"metadata":{"annotations":{"%s":"%s"}}%s}`,
+		SnapshotCanceledAnnotation, time.Now().UTC().Format(time.RFC3339), spec))
+}
@@ -0,0 +1,152 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+func TestCancelVolumeSnapshot(t *testing.T) {
+	vscName := "vsc-1"
+	tests := []struct {
+		name            string
+		ready           bool
+		expectVSDeleted bool
+		expectVSCPolicy snapshotv1api.DeletionPolicy
+	}{
+		{
+			name:            "not ready volumesnapshot is removed with its storage snapshot",
+			expectVSDeleted: true,
+			expectVSCPolicy: snapshotv1api.VolumeSnapshotContentDelete,
+		},
+		{
+			name:            "ready volumesnapshot is kept",
+			ready:           true,
+			expectVSCPolicy: snapshotv1api.VolumeSnapshotContentRetain,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vs := &snapshotv1api.VolumeSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs-1"},
+				Status: &snapshotv1api.VolumeSnapshotStatus{
+					BoundVolumeSnapshotContentName: &vscName,
+					ReadyToUse:                     boolptr.False(),
+				},
+			}
+			if tc.ready {
+				vs.Status.ReadyToUse = boolptr.True()
+			}
+			vsc := &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: vscName},
+				Spec:       snapshotv1api.VolumeSnapshotContentSpec{DeletionPolicy: snapshotv1api.VolumeSnapshotContentRetain},
+			}
+			snapshotClient := snapshotFake.NewSimpleClientset(vs, vsc)
+
+			require.NoError(t, CancelVolumeSnapshot(context.Background(), "ns", "vs-1", "", snapshotClient.SnapshotV1(), logrus.New()))
+
+			_, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-1", metav1.GetOptions{})
+			assert.Equal(t, tc.expectVSDeleted, apierrors.IsNotFound(err))
+			updatedVSC, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), vscName, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectVSCPolicy, updatedVSC.Spec.DeletionPolicy)
+		})
+	}
+
+	t.Run("recreated volumesnapshot is kept", func(t *testing.T) {
+		vs := &snapshotv1api.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs-1", UID: "new-uid"},
+			Status:     &snapshotv1api.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: &vscName},
+		}
+		snapshotClient := snapshotFake.NewSimpleClientset(vs)
+
+		require.NoError(t, CancelVolumeSnapshot(context.Background(), "ns", "vs-1", "old-uid", snapshotClient.SnapshotV1(), logrus.New()))
+
+		kept, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-1", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.False(t, IsSnapshotCanceled(&kept.ObjectMeta))
+	})
+
+	t.Run("missing volumesnapshot is ignored", func(t *testing.T) {
+		snapshotClient := snapshotFake.NewSimpleClientset()
+		assert.NoError(t, CancelVolumeSnapshot(context.Background(), "ns", "vs-1", "", snapshotClient.SnapshotV1(), logrus.New()))
+	})
+}
+
+func TestCancelVolumeSnapshotContent(t *testing.T) {
+	newVSC := func() *snapshotv1api.VolumeSnapshotContent {
+		return &snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "vsc-1"},
+			Spec: snapshotv1api.VolumeSnapshotContentSpec{
+				DeletionPolicy:    snapshotv1api.VolumeSnapshotContentRetain,
+				VolumeSnapshotRef: corev1api.ObjectReference{Namespace: "ns", Name: "vs-1"},
+			},
+		}
+	}
+
+	t.Run("volumesnapshotcontent without volumesnapshot is deleted", func(t *testing.T) {
+		snapshotClient := snapshotFake.NewSimpleClientset(newVSC())
+
+		require.NoError(t, CancelVolumeSnapshotContent(context.Background(), "vsc-1", "", snapshotClient.SnapshotV1(), logrus.New()))
+
+		_, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-1", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("volumesnapshot of the volumesnapshotcontent is deleted", func(t *testing.T) {
+		vscName := "vsc-1"
+		vs := &snapshotv1api.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs-1"},
+			Status:     &snapshotv1api.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: &vscName},
+		}
+		snapshotClient := snapshotFake.NewSimpleClientset(newVSC(), vs)
+
+		require.NoError(t, CancelVolumeSnapshotContent(context.Background(), "vsc-1", "", snapshotClient.SnapshotV1(), logrus.New()))
+
+		_, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-1", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+		vsc, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-1", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, snapshotv1api.VolumeSnapshotContentDelete, vsc.Spec.DeletionPolicy)
+		assert.True(t, IsSnapshotCanceled(&vsc.ObjectMeta))
+	})
+
+	t.Run("ready volumesnapshotcontent is kept", func(t *testing.T) {
+		vsc := newVSC()
+		vsc.Status = &snapshotv1api.VolumeSnapshotContentStatus{ReadyToUse: boolptr.True()}
+		snapshotClient := snapshotFake.NewSimpleClientset(vsc)
+
+		require.NoError(t, CancelVolumeSnapshotContent(context.Background(), "vsc-1", "", snapshotClient.SnapshotV1(), logrus.New()))
+
+		kept, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-1", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.False(t, IsSnapshotCanceled(&kept.ObjectMeta))
+	})
+}
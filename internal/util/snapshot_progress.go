@@ -0,0 +1,171 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"github.com/sirupsen/logrus"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+// Phases of a snapshot operation, reported in the Description of its progress.
+const (
+	SnapshotPhasePendingHandle = "PendingHandle"
+	SnapshotPhaseUploading     = "Uploading"
+	SnapshotPhaseReady         = "Ready"
+	SnapshotPhaseFailed        = "Failed"
+)
+
+const defaultSnapshotErrorGracePeriod = 5 * time.Minute
+
+// SnapshotState is the state of the snapshot of a backup operation, read from its VolumeSnapshot or VolumeSnapshotContent.
+type SnapshotState struct {
+	// HasHandle is whether the storage snapshot is cut, the storage may still be uploading it.
+	HasHandle  bool
+	ReadyToUse bool
+	// RestoreSize is the size of the snapshot in bytes, or 0 when the CSI driver doesn't report it.
+	RestoreSize int64
+	// Error is the message of the error reported by the snapshot controller, if HasError.
+	Error    string
+	HasError bool
+}
+
+// GetVolumeSnapshotState returns the state of the snapshot of the VolumeSnapshot.
+func GetVolumeSnapshotState(vs *snapshotv1api.VolumeSnapshot) SnapshotState {
+	state := SnapshotState{}
+	if vs.Status == nil {
+		return state
+	}
+	// The snapshot controller sets the creation time when the storage snapshot is cut.
+	state.HasHandle = vs.Status.CreationTime != nil
+	state.ReadyToUse = boolptr.IsSetToTrue(vs.Status.ReadyToUse)
+	if vs.Status.RestoreSize != nil {
+		state.RestoreSize = vs.Status.RestoreSize.Value()
+	}
+	if vs.Status.Error != nil {
+		state.HasError = true
+		if vs.Status.Error.Message != nil {
+			state.Error = *vs.Status.Error.Message
+		}
+	}
+	return state
+}
+
+// GetVolumeSnapshotContentState returns the state of the snapshot of the VolumeSnapshotContent.
+func GetVolumeSnapshotContentState(vsc *snapshotv1api.VolumeSnapshotContent) SnapshotState {
+	state := SnapshotState{}
+	if vsc.Status == nil {
+		return state
+	}
+	state.HasHandle = vsc.Status.SnapshotHandle != nil
+	state.ReadyToUse = boolptr.IsSetToTrue(vsc.Status.ReadyToUse)
+	if vsc.Status.RestoreSize != nil {
+		state.RestoreSize = *vsc.Status.RestoreSize
+	}
+	if vsc.Status.Error != nil {
+		state.HasError = true
+		if vsc.Status.Error.Message != nil {
+			state.Error = *vsc.Status.Error.Message
+		}
+	}
+	return state
+}
+
+// GetSnapshotErrorGracePeriod returns how long after the start of a snapshot operation the errors of the
+// snapshot are retried, or 5 minutes when the backup doesn't set it.
+func GetSnapshotErrorGracePeriod(backup *velerov1api.Backup, log logrus.FieldLogger) time.Duration {
+	value, ok := backup.Annotations[SnapshotErrorGracePeriodAnnotation]
+	if !ok {
+		return defaultSnapshotErrorGracePeriod
+	}
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		log.Warnf("fail to parse snapshot error grace period %s, using %s", value, defaultSnapshotErrorGracePeriod)
+		return defaultSnapshotErrorGracePeriod
+	}
+	return grace
+}
+
+// GetSnapshotReadyTimeout returns how long a snapshot operation waits for the snapshot to be ReadyToUse: the duration
+// set by the backup's annotation, or else the CSI snapshot timeout of the backup. 0 disables it, and then only Velero's
+// item operation timeout applies.
+func GetSnapshotReadyTimeout(backup *velerov1api.Backup, log logrus.FieldLogger) time.Duration {
+	value, ok := backup.Annotations[SnapshotReadyTimeoutAnnotation]
+	if !ok {
+		return backup.Spec.CSISnapshotTimeout.Duration
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		log.Warnf("fail to parse snapshot ready timeout %s, using the CSI snapshot timeout %s", value, backup.Spec.CSISnapshotTimeout.Duration)
+		return backup.Spec.CSISnapshotTimeout.Duration
+	}
+	return timeout
+}
+
+// GetSnapshotProgress returns the progress of the snapshot operation started at started. The progress is measured in
+// bytes of the restore size, and Description holds the phase of the operation. An error of the snapshot fails the operation
+// only when it remains after the error grace period, because the snapshot controller retries it. A snapshot that isn't
+// ReadyToUse within the ready timeout fails the operation too.
+func GetSnapshotProgress(state SnapshotState, started time.Time, backup *velerov1api.Backup, log logrus.FieldLogger) velero.OperationProgress {
+	progress := velero.OperationProgress{
+		Started: started,
+		Updated: time.Now(),
+	}
+	if state.RestoreSize > 0 {
+		progress.OperationUnits = "Bytes"
+		progress.NTotal = state.RestoreSize
+	}
+
+	switch {
+	case state.ReadyToUse:
+		progress.Completed = true
+		progress.Description = SnapshotPhaseReady
+		progress.NCompleted = progress.NTotal
+		return progress
+	case state.HasHandle:
+		progress.Description = SnapshotPhaseUploading
+	default:
+		progress.Description = SnapshotPhasePendingHandle
+	}
+
+	elapsed := progress.Updated.Sub(started)
+	if state.HasError {
+		if grace := GetSnapshotErrorGracePeriod(backup, log); elapsed >= grace {
+			progress.Completed = true
+			progress.Description = SnapshotPhaseFailed
+			progress.Err = state.Error
+			if progress.Err == "" {
+				progress.Err = fmt.Sprintf("snapshot failed after %s", elapsed.Round(time.Second))
+			}
+			return progress
+		}
+		log.Warnf("Snapshot has a temporary error %s. Snapshot controller will retry later.", state.Error)
+	}
+
+	if timeout := GetSnapshotReadyTimeout(backup, log); timeout > 0 && elapsed >= timeout {
+		progress.Completed = true
+		progress.Description = SnapshotPhaseFailed
+		progress.Err = fmt.Sprintf("snapshot is not ready to use after %s", timeout)
+	}
+	return progress
+}
@@ -0,0 +1,156 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+func TestGetSnapshotProgress(t *testing.T) {
+	tests := []struct {
+		name             string
+		state            SnapshotState
+		elapsed          time.Duration
+		annotations      map[string]string
+		snapshotTimeout  time.Duration
+		expectPhase      string
+		expectCompleted  bool
+		expectErr        string
+		expectNTotal     int64
+		expectNCompleted int64
+		expectUnits      string
+	}{
+		{
+			name:        "waiting for the snapshot handle",
+			expectPhase: SnapshotPhasePendingHandle,
+		},
+		{
+			name:         "uploading",
+			state:        SnapshotState{HasHandle: true, RestoreSize: 1024},
+			expectPhase:  SnapshotPhaseUploading,
+			expectNTotal: 1024,
+			expectUnits:  "Bytes",
+		},
+		{
+			name:             "ready",
+			state:            SnapshotState{HasHandle: true, ReadyToUse: true, RestoreSize: 1024},
+			expectPhase:      SnapshotPhaseReady,
+			expectCompleted:  true,
+			expectNTotal:     1024,
+			expectNCompleted: 1024,
+			expectUnits:      "Bytes",
+		},
+		{
+			name:        "error within the grace period is retried",
+			state:       SnapshotState{HasError: true, Error: "quota exceeded"},
+			elapsed:     time.Minute,
+			expectPhase: SnapshotPhasePendingHandle,
+		},
+		{
+			name:            "error after the grace period fails the operation",
+			state:           SnapshotState{HasError: true, Error: "quota exceeded"},
+			elapsed:         10 * time.Minute,
+			expectPhase:     SnapshotPhaseFailed,
+			expectCompleted: true,
+			expectErr:       "quota exceeded",
+		},
+		{
+			name:            "configured grace period",
+			state:           SnapshotState{HasError: true, Error: "quota exceeded"},
+			elapsed:         time.Minute,
+			annotations:     map[string]string{SnapshotErrorGracePeriodAnnotation: "0s"},
+			expectPhase:     SnapshotPhaseFailed,
+			expectCompleted: true,
+			expectErr:       "quota exceeded",
+		},
+		{
+			name:            "not ready after the ready timeout",
+			state:           SnapshotState{HasHandle: true},
+			elapsed:         2 * time.Hour,
+			annotations:     map[string]string{SnapshotReadyTimeoutAnnotation: "1h"},
+			expectPhase:     SnapshotPhaseFailed,
+			expectCompleted: true,
+			expectErr:       "snapshot is not ready to use after 1h0m0s",
+		},
+		{
+			name:            "ready timeout defaults to the CSI snapshot timeout",
+			state:           SnapshotState{HasHandle: true},
+			elapsed:         time.Hour,
+			snapshotTimeout: 10 * time.Minute,
+			expectPhase:     SnapshotPhaseFailed,
+			expectCompleted: true,
+			expectErr:       "snapshot is not ready to use after 10m0s",
+		},
+		{
+			name:            "ready timeout of 0 disables it",
+			state:           SnapshotState{HasHandle: true},
+			elapsed:         time.Hour,
+			annotations:     map[string]string{SnapshotReadyTimeoutAnnotation: "0s"},
+			snapshotTimeout: 10 * time.Minute,
+			expectPhase:     SnapshotPhaseUploading,
+		},
+		{
+			name:        "no ready timeout without a CSI snapshot timeout",
+			state:       SnapshotState{HasHandle: true},
+			elapsed:     24 * time.Hour,
+			expectPhase: SnapshotPhaseUploading,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotationsMap(tc.annotations)).
+				CSISnapshotTimeout(tc.snapshotTimeout).Result()
+			started := time.Now().Add(-tc.elapsed)
+
+			progress := GetSnapshotProgress(tc.state, started, backup, logrus.New())
+			assert.Equal(t, tc.expectPhase, progress.Description)
+			assert.Equal(t, tc.expectCompleted, progress.Completed)
+			assert.Equal(t, tc.expectErr, progress.Err)
+			assert.Equal(t, tc.expectNTotal, progress.NTotal)
+			assert.Equal(t, tc.expectNCompleted, progress.NCompleted)
+			assert.Equal(t, tc.expectUnits, progress.OperationUnits)
+			assert.Equal(t, started, progress.Started)
+			assert.False(t, progress.Updated.Before(started))
+		})
+	}
+}
+
+func TestGetVolumeSnapshotState(t *testing.T) {
+	message := "failed"
+	size := resource.MustParse("1Gi")
+	vs := &snapshotv1api.VolumeSnapshot{
+		Status: &snapshotv1api.VolumeSnapshotStatus{
+			CreationTime: &metav1.Time{Time: time.Now()},
+			ReadyToUse:   boolptr.False(),
+			RestoreSize:  &size,
+			Error:        &snapshotv1api.VolumeSnapshotError{Message: &message},
+		},
+	}
+	assert.Equal(t, SnapshotState{HasHandle: true, RestoreSize: 1 << 30, HasError: true, Error: message}, GetVolumeSnapshotState(vs))
+	assert.Equal(t, SnapshotState{}, GetVolumeSnapshotState(&snapshotv1api.VolumeSnapshot{}))
+}
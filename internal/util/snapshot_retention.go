@@ -0,0 +1,212 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+)
+
+// GetSnapshotRetention returns how long the local CSI snapshots of the backup are kept.
+// Zero means the snapshots are kept as long as the backup.
+func GetSnapshotRetention(backup *velerov1api.Backup) (time.Duration, error) {
+	value, ok := backup.Annotations[SnapshotRetentionAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	retention, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "fail to parse snapshot retention %s of backup %s", value, backup.Name)
+	}
+	if retention < 0 {
+		return 0, errors.Errorf("snapshot retention %s of backup %s is negative", value, backup.Name)
+	}
+
+	return retention, nil
+}
+
+// IsVolumeSnapshotExpired returns whether the snapshot retention sweeper removed the
+// VolumeSnapshot, identified by the namespace and name it had at backup time, from the backup.
+func IsVolumeSnapshotExpired(backup *velerov1api.Backup, namespace, name string) bool {
+	expired, ok := backup.Annotations[ExpiredVolumeSnapshotsAnnotation]
+	if !ok {
+		return false
+	}
+	return Contains(strings.Split(expired, ","), namespace+"/"+name)
+}
+
+// SweepExpiredVolumeSnapshots removes the VolumeSnapshots and VolumeSnapshotContents of the
+// backups in backupNamespace whose snapshot retention expired before now, together with the
+// storage snapshots. Every backup that lost a snapshot is marked, so restores don't try to use it.
+// Snapshots under legal hold and protected VolumeSnapshotContents are kept until the hold or protection is removed.
+// A snapshot that fails to be removed doesn't stop the sweep, the failures are returned.
+func SweepExpiredVolumeSnapshots(ctx context.Context, backupNamespace string, kubeClient kubernetes.Interface, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface, now time.Time, log logrus.FieldLogger) error {
+	vscList, err := snapshotClient.VolumeSnapshotContents().List(ctx, metav1.ListOptions{LabelSelector: velerov1api.BackupNameLabel})
+	if err != nil {
+		return errors.Wrap(err, "error listing volumesnapshotcontents")
+	}
+
+	var holds *LegalHolds
+	errs := []error{}
+	for _, vsc := range vscList.Items {
+		expiresAt, ok := vsc.Annotations[SnapshotExpiresAtAnnotation]
+		if !ok {
+			continue
+		}
+		expiry, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			log.Warnf("Fail to parse %s of volumesnapshotcontent %s: %s", SnapshotExpiresAtAnnotation, vsc.Name, err.Error())
+			continue
+		}
+		if now.Before(expiry) {
+			continue
+		}
+		if holds == nil {
+			if holds, err = GetLegalHolds(ctx, backupNamespace, kubeClient); err != nil {
+				return err
+			}
+		}
+
+		if err := deleteExpiredVolumeSnapshot(ctx, backupNamespace, vsc, holds, snapshotClient, veleroClient, log); err != nil {
+			errs = append(errs, errors.Wrapf(err, "fail to remove expired volumesnapshotcontent %s", vsc.Name))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func deleteExpiredVolumeSnapshot(ctx context.Context, backupNamespace string, vsc snapshotv1api.VolumeSnapshotContent, holds *LegalHolds,
+	snapshotClient snapshotter.SnapshotV1Interface, veleroClient veleroClientSet.Interface, log logrus.FieldLogger) error {
+	log = log.WithFields(logrus.Fields{
+		"VolumeSnapshotContent": vsc.Name,
+		"Backup":                vsc.Labels[velerov1api.BackupNameLabel],
+	})
+
+	backup, err := veleroClient.VeleroV1().Backups(backupNamespace).Get(ctx, vsc.Labels[velerov1api.BackupNameLabel], metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "fail to get backup %s", vsc.Labels[velerov1api.BackupNameLabel])
+		}
+		backup = nil
+	}
+
+	source := vsc.Annotations[SourceVolumeSnapshotAnnotation]
+	var vs *snapshotv1api.VolumeSnapshot
+	if parts := strings.SplitN(source, "/", 2); len(parts) == 2 {
+		vs, err = snapshotClient.VolumeSnapshots(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "fail to get volumesnapshot %s", source)
+		}
+		if err != nil || vs.Status == nil || vs.Status.BoundVolumeSnapshotContentName == nil ||
+			*vs.Status.BoundVolumeSnapshotContentName != vsc.Name {
+			vs = nil
+		}
+	}
+
+	// The retention of the backup doesn't override a legal hold or the protection of the volumesnapshotcontent.
+	heldBackup := backup
+	if heldBackup == nil {
+		heldBackup = &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: vsc.Labels[velerov1api.BackupNameLabel]}}
+	}
+	heldObjects := []*metav1.ObjectMeta{&vsc.ObjectMeta}
+	if vs != nil {
+		heldObjects = append(heldObjects, &vs.ObjectMeta)
+	}
+	if reason := holds.HoldReason(heldBackup, getVolumeSnapshotContentHandle(&vsc), heldObjects...); reason != "" {
+		log.Infof("Snapshot retention expired at %s, but the snapshot is under legal hold, keep it: %s", vsc.Annotations[SnapshotExpiresAtAnnotation], reason)
+		return nil
+	}
+	if IsVolumeSnapshotContentProtected(&vsc) {
+		log.Infof("Snapshot retention expired at %s, but the volumesnapshotcontent is protected by label %s, keep it",
+			vsc.Annotations[SnapshotExpiresAtAnnotation], SnapshotProtectedLabel)
+		return nil
+	}
+
+	log.Infof("Snapshot retention expired at %s, removing the snapshot", vsc.Annotations[SnapshotExpiresAtAnnotation])
+	// The backup is marked before the snapshot is removed, so a sweep cut short never leaves a backup pointing at a
+	// removed snapshot. A snapshot marked but not removed is removed by the next sweep.
+	if backup == nil {
+		log.Info("Backup of the volumesnapshotcontent is not found, the snapshot is removed without marking the backup")
+	} else if source != "" {
+		if err := markVolumeSnapshotExpired(ctx, backup.Namespace, backup.Name, source, veleroClient); err != nil {
+			return err
+		}
+	}
+	if vs != nil {
+		// DeleteVolumeSnapshot keeps the volumesnapshotcontent, which is removed with its storage snapshot below.
+		DeleteVolumeSnapshot(ctx, *vs, vsc, heldBackup, snapshotClient, log)
+	}
+
+	// Setting the DeletionPolicy to Delete makes the CSI snapshot controller delete the storage snapshot.
+	if err := SetVolumeSnapshotContentDeletionPolicy(ctx, vsc.Name, snapshotClient); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "fail to set DeletionPolicy of volumesnapshotcontent %s", vsc.Name)
+	}
+	if err := snapshotClient.VolumeSnapshotContents().Delete(ctx, vsc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "fail to delete volumesnapshotcontent %s", vsc.Name)
+	}
+	log.Info("Removed expired snapshot")
+
+	return nil
+}
+
+// markVolumeSnapshotExpired adds the namespace/name of the VolumeSnapshot to the expired VolumeSnapshots of the backup.
+// The backup is updated from its latest version, so concurrent sweeps don't drop each other's VolumeSnapshots.
+func markVolumeSnapshotExpired(ctx context.Context, namespace, backupName, source string, veleroClient veleroClientSet.Interface) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		backup, err := veleroClient.VeleroV1().Backups(namespace).Get(ctx, backupName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		expired := []string{}
+		if value := backup.Annotations[ExpiredVolumeSnapshotsAnnotation]; value != "" {
+			expired = strings.Split(value, ",")
+		}
+		if Contains(expired, source) {
+			return nil
+		}
+		if backup.Annotations == nil {
+			backup.Annotations = map[string]string{}
+		}
+		backup.Annotations[ExpiredVolumeSnapshotsAnnotation] = strings.Join(append(expired, source), ",")
+		_, err = veleroClient.VeleroV1().Backups(namespace).Update(ctx, backup, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fail to mark volumesnapshot %s expired on backup %s", source, backupName)
+	}
+
+	return nil
+}
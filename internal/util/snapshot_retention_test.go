@@ -0,0 +1,187 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+)
+
+func TestGetSnapshotRetention(t *testing.T) {
+	testCases := []struct {
+		name        string
+		backup      *velerov1api.Backup
+		expected    time.Duration
+		expectError bool
+	}{
+		{
+			name:     "backup without retention annotation",
+			backup:   builder.ForBackup("velero", "backup").Result(),
+			expected: 0,
+		},
+		{
+			name:     "backup with retention annotation",
+			backup:   builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(SnapshotRetentionAnnotation, "48h")).Result(),
+			expected: 48 * time.Hour,
+		},
+		{
+			name:        "backup with invalid retention annotation",
+			backup:      builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(SnapshotRetentionAnnotation, "2d")).Result(),
+			expectError: true,
+		},
+		{
+			name:        "backup with negative retention annotation",
+			backup:      builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(SnapshotRetentionAnnotation, "-1h")).Result(),
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := GetSnapshotRetention(tc.backup)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestIsVolumeSnapshotExpired(t *testing.T) {
+	backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(ExpiredVolumeSnapshotsAnnotation, "ns1/vs1,ns2/vs2")).Result()
+
+	assert.True(t, IsVolumeSnapshotExpired(backup, "ns2", "vs2"))
+	assert.False(t, IsVolumeSnapshotExpired(backup, "ns1", "vs2"))
+	assert.False(t, IsVolumeSnapshotExpired(builder.ForBackup("velero", "backup").Result(), "ns1", "vs1"))
+}
+
+func TestSweepExpiredVolumeSnapshots(t *testing.T) {
+	now := time.Now()
+	handle := "snap-handle"
+	newVSC := func(name string, annotations map[string]string) *snapshotv1api.VolumeSnapshotContent {
+		return &snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{velerov1api.BackupNameLabel: "backup"},
+				Annotations: annotations,
+			},
+			Spec: snapshotv1api.VolumeSnapshotContentSpec{
+				DeletionPolicy: snapshotv1api.VolumeSnapshotContentRetain,
+			},
+			Status: &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle},
+		}
+	}
+	expired := newVSC("expired-vsc", map[string]string{
+		SnapshotExpiresAtAnnotation:    now.Add(-time.Hour).Format(time.RFC3339),
+		SourceVolumeSnapshotAnnotation: "ns/vs",
+	})
+	notExpired := newVSC("not-expired-vsc", map[string]string{
+		SnapshotExpiresAtAnnotation:    now.Add(time.Hour).Format(time.RFC3339),
+		SourceVolumeSnapshotAnnotation: "ns/vs2",
+	})
+	noRetention := newVSC("no-retention-vsc", nil)
+	expiredAt := now.Add(-time.Hour).Format(time.RFC3339)
+	annotatedHold := newVSC("annotated-hold-vsc", map[string]string{SnapshotExpiresAtAnnotation: expiredAt, LegalHoldLabel: "case-1234"})
+	configMapHold := newVSC("configmap-hold-vsc", map[string]string{SnapshotExpiresAtAnnotation: expiredAt})
+	heldHandle := "held-handle"
+	configMapHold.Status.SnapshotHandle = &heldHandle
+	protected := newVSC("protected-vsc", map[string]string{SnapshotExpiresAtAnnotation: expiredAt})
+	protected.Labels[SnapshotProtectedLabel] = "true"
+	vs := builder.ForVolumeSnapshot("ns", "vs").ObjectMeta(builder.WithLabels(velerov1api.BackupNameLabel, "backup")).Status().BoundVolumeSnapshotContentName("expired-vsc").Result()
+	backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithAnnotations(ExpiredVolumeSnapshotsAnnotation, "other/vs")).Result()
+
+	snapshotClient := snapshotFake.NewSimpleClientset(expired, notExpired, noRetention, annotatedHold, configMapHold, protected, vs)
+	veleroClient := velerofake.NewSimpleClientset(backup)
+	kubeClient := fake.NewSimpleClientset(builder.ForConfigMap("velero", "legal-holds").ObjectMeta(builder.WithLabels(LegalHoldLabel, "true")).
+		Data("case-5678", "snapshot:held-handle").Result())
+
+	err := SweepExpiredVolumeSnapshots(context.Background(), "velero", kubeClient, snapshotClient.SnapshotV1(), veleroClient, now, logrus.New())
+	require.NoError(t, err)
+
+	vsList, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, vsList.Items)
+
+	vscList, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	remaining := []string{}
+	for _, vsc := range vscList.Items {
+		remaining = append(remaining, vsc.Name)
+	}
+	// Snapshots under legal hold and protected volumesnapshotcontents outlive their retention.
+	assert.ElementsMatch(t, []string{"not-expired-vsc", "no-retention-vsc", "annotated-hold-vsc", "configmap-hold-vsc", "protected-vsc"}, remaining)
+
+	updated, err := veleroClient.VeleroV1().Backups("velero").Get(context.TODO(), "backup", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "other/vs,ns/vs", updated.Annotations[ExpiredVolumeSnapshotsAnnotation])
+}
+
+func TestSweepExpiredVolumeSnapshotsMarksBackupFirst(t *testing.T) {
+	vsc := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "expired-vsc",
+			Labels: map[string]string{velerov1api.BackupNameLabel: "backup"},
+			Annotations: map[string]string{
+				SnapshotExpiresAtAnnotation:    time.Now().Add(-time.Hour).Format(time.RFC3339),
+				SourceVolumeSnapshotAnnotation: "ns/vs",
+			},
+		},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{DeletionPolicy: snapshotv1api.VolumeSnapshotContentRetain},
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(vsc)
+	snapshotClient.PrependReactor("delete", "volumesnapshotcontents", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	veleroClient := velerofake.NewSimpleClientset(builder.ForBackup("velero", "backup").Result())
+	// The first update conflicts, as if another sweep marked the backup in between.
+	conflicted := false
+	veleroClient.PrependReactor("update", "backups", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+		return true, nil, apierrors.NewConflict(velerov1api.Resource("backups"), "backup", errors.New("conflict"))
+	})
+
+	err := SweepExpiredVolumeSnapshots(context.Background(), "velero", fake.NewSimpleClientset(), snapshotClient.SnapshotV1(), veleroClient,
+		time.Now(), logrus.New())
+	require.Error(t, err)
+
+	// The deletion failed, but the backup no longer points at the snapshot.
+	updated, err := veleroClient.VeleroV1().Backups("velero").Get(context.TODO(), "backup", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "ns/vs", updated.Annotations[ExpiredVolumeSnapshotsAnnotation])
+}
@@ -0,0 +1,49 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+)
+
+// MapSnapshotSecretNamespace returns the namespace the snapshot secret from the backup namespace is restored into.
+// Velero restores the secret in the backup, like any other namespaced item, into the namespace mapped by the restore.
+func MapSnapshotSecretNamespace(namespace string, restore *velerov1api.Restore) string {
+	if mapped, ok := restore.Spec.NamespaceMapping[namespace]; ok {
+		return mapped
+	}
+	return namespace
+}
+
+// CheckSnapshotSecretExists returns an error when the snapshot secret doesn't exist in the cluster. The CSI driver
+// needs the secret to delete the storage snapshot, so a volumesnapshotcontent referencing a missing secret can't be deleted.
+func CheckSnapshotSecretExists(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	if _, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			return errors.Errorf("snapshot secret %s/%s doesn't exist", namespace, name)
+		}
+		return errors.Wrapf(err, "fail to get snapshot secret %s/%s", namespace, name)
+	}
+	return nil
+}
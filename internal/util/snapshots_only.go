@@ -0,0 +1,104 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strconv"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+)
+
+// IsSnapshotsOnlyRestore returns whether the restore recreates the VolumeSnapshots of the backup without restoring the PVCs.
+func IsSnapshotsOnlyRestore(restore *velerov1api.Restore) bool {
+	snapshotsOnly, err := strconv.ParseBool(restore.Annotations[SnapshotsOnlyRestoreAnnotation])
+	return err == nil && snapshotsOnly
+}
+
+// SweepSnapshotsOfDeletedRestores removes the VolumeSnapshots and VolumeSnapshotContents recreated by the snapshots-only
+// restores in restoreNamespace that no longer exist. The VolumeSnapshotContents are retained, so the storage snapshots,
+// which belong to the backups, are kept.
+func SweepSnapshotsOfDeletedRestores(ctx context.Context, restoreNamespace string, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface, log logrus.FieldLogger) error {
+	restoreList, err := veleroClient.VeleroV1().Restores(restoreNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error listing restores")
+	}
+	restoreUIDs := map[string]struct{}{}
+	for _, restore := range restoreList.Items {
+		restoreUIDs[string(restore.UID)] = struct{}{}
+	}
+
+	errs := []error{}
+	listOptions := metav1.ListOptions{LabelSelector: SnapshotsOnlyRestoreUIDLabel}
+	vsList, err := snapshotClient.VolumeSnapshots("").List(ctx, listOptions)
+	if err != nil {
+		return errors.Wrap(err, "error listing volumesnapshots")
+	}
+	for _, vs := range vsList.Items {
+		if _, ok := restoreUIDs[vs.Labels[SnapshotsOnlyRestoreUIDLabel]]; ok {
+			continue
+		}
+		// Deleting a volumesnapshot bound to a volumesnapshotcontent with the Delete policy deletes the storage snapshot.
+		if vscName := boundVolumeSnapshotContentName(&vs); vscName != "" {
+			vsc, err := snapshotClient.VolumeSnapshotContents().Get(ctx, vscName, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, errors.Wrapf(err, "error getting volumesnapshotcontent %s", vscName))
+				continue
+			}
+			if err == nil && vsc.Spec.DeletionPolicy != snapshotv1api.VolumeSnapshotContentRetain {
+				log.Warnf("Volumesnapshotcontent %s of volumesnapshot %s/%s of a deleted restore doesn't retain its storage snapshot, keep them",
+					vscName, vs.Namespace, vs.Name)
+				continue
+			}
+		}
+		log.Infof("Restore of volumesnapshot %s/%s is deleted, removing the volumesnapshot", vs.Namespace, vs.Name)
+		if err := snapshotClient.VolumeSnapshots(vs.Namespace).Delete(ctx, vs.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "fail to remove volumesnapshot %s/%s", vs.Namespace, vs.Name))
+		}
+	}
+
+	vscList, err := snapshotClient.VolumeSnapshotContents().List(ctx, listOptions)
+	if err != nil {
+		errs = append(errs, errors.Wrap(err, "error listing volumesnapshotcontents"))
+		return utilerrors.NewAggregate(errs)
+	}
+	for _, vsc := range vscList.Items {
+		if _, ok := restoreUIDs[vsc.Labels[SnapshotsOnlyRestoreUIDLabel]]; ok {
+			continue
+		}
+		if vsc.Spec.DeletionPolicy != snapshotv1api.VolumeSnapshotContentRetain {
+			log.Warnf("Volumesnapshotcontent %s of a deleted restore doesn't retain its storage snapshot, keep it", vsc.Name)
+			continue
+		}
+		log.Infof("Restore of volumesnapshotcontent %s is deleted, removing the volumesnapshotcontent", vsc.Name)
+		if err := snapshotClient.VolumeSnapshotContents().Delete(ctx, vsc.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "fail to remove volumesnapshotcontent %s", vsc.Name))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
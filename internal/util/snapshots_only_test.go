@@ -0,0 +1,75 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+)
+
+func TestIsSnapshotsOnlyRestore(t *testing.T) {
+	assert.True(t, IsSnapshotsOnlyRestore(builder.ForRestore("velero", "restore").ObjectMeta(builder.WithAnnotations(SnapshotsOnlyRestoreAnnotation, "true")).Result()))
+	assert.False(t, IsSnapshotsOnlyRestore(builder.ForRestore("velero", "restore").ObjectMeta(builder.WithAnnotations(SnapshotsOnlyRestoreAnnotation, "yes please")).Result()))
+	assert.False(t, IsSnapshotsOnlyRestore(builder.ForRestore("velero", "restore").Result()))
+}
+
+func TestSweepSnapshotsOfDeletedRestores(t *testing.T) {
+	newVS := func(name, restoreUID, vscName string) *snapshotv1api.VolumeSnapshot {
+		return builder.ForVolumeSnapshot("ns", name).ObjectMeta(builder.WithLabels(SnapshotsOnlyRestoreUIDLabel, restoreUID)).
+			Status().BoundVolumeSnapshotContentName(vscName).Result()
+	}
+	newVSC := func(name, restoreUID string, policy snapshotv1api.DeletionPolicy) *snapshotv1api.VolumeSnapshotContent {
+		vsc := builder.ForVolumeSnapshotContent(name).DeletionPolicy(policy).Result()
+		vsc.Labels = map[string]string{SnapshotsOnlyRestoreUIDLabel: restoreUID}
+		return vsc
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		newVS("vs-kept", "live-uid", "vsc-kept"), newVS("vs-swept", "deleted-uid", "vsc-swept"),
+		newVS("vs-deleting", "deleted-uid", "vsc-deleting"),
+		newVSC("vsc-kept", "live-uid", snapshotv1api.VolumeSnapshotContentRetain),
+		newVSC("vsc-swept", "deleted-uid", snapshotv1api.VolumeSnapshotContentRetain),
+		newVSC("vsc-deleting", "deleted-uid", snapshotv1api.VolumeSnapshotContentDelete),
+	)
+	veleroClient := velerofake.NewSimpleClientset(builder.ForRestore("velero", "live").ObjectMeta(builder.WithUID("live-uid")).Result())
+
+	require.NoError(t, SweepSnapshotsOfDeletedRestores(context.Background(), "velero", snapshotClient.SnapshotV1(), veleroClient, logrus.New()))
+
+	_, err := snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-kept", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-swept", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-kept", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-swept", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	// A volumesnapshotcontent deleting its storage snapshot is not removed, nor is its volumesnapshot, the snapshot belongs to the backup.
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.Background(), "vsc-deleting", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = snapshotClient.SnapshotV1().VolumeSnapshots("ns").Get(context.Background(), "vs-deleting", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+)
+
+var (
+	// sweepTimeout bounds a sweep, so it only delays the item that runs it by that much.
+	sweepTimeout = time.Minute
+	sweepOnce    sync.Once
+)
+
+// SweepSnapshotsOnce runs SweepSnapshots once per plugin process, bounded by sweepTimeout. Velero starts the plugin
+// process for each backup and restore, so the snapshots are swept at the latest on the next backup or restore. The sweep
+// runs before the item that calls it, as Velero stops the plugin process once the backup or restore is done.
+// A sweep cut short is picked up by the next one.
+func SweepSnapshotsOnce(ctx context.Context, namespace string, kubeClient kubernetes.Interface, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface, log logrus.FieldLogger) {
+	sweepOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(ctx, sweepTimeout)
+		defer cancel()
+		if err := SweepSnapshots(ctx, namespace, kubeClient, snapshotClient, veleroClient, time.Now(), log); err != nil {
+			log.WithError(err).Warn("Fail to sweep volumesnapshots")
+		}
+	})
+}
+
+// SweepSnapshots removes the snapshots whose retention expired, the snapshots recreated by deleted snapshots-only
+// restores, the restored snapshots whose PVCs are bound, and the snapshots of deleted backups whose legal hold is
+// released, and writes the deletion reports the backups ask for.
+// A failing sweeper doesn't stop the others, their errors are returned.
+func SweepSnapshots(ctx context.Context, namespace string, kubeClient kubernetes.Interface, snapshotClient snapshotter.SnapshotV1Interface,
+	veleroClient veleroClientSet.Interface, now time.Time, log logrus.FieldLogger) error {
+	errs := []error{}
+	if err := SweepExpiredVolumeSnapshots(ctx, namespace, kubeClient, snapshotClient, veleroClient, now, log); err != nil {
+		errs = append(errs, err)
+	}
+	if err := SweepSnapshotsOfDeletedRestores(ctx, namespace, snapshotClient, veleroClient, log); err != nil {
+		errs = append(errs, err)
+	}
+	if err := SweepRestoredSnapshots(ctx, namespace, kubeClient, snapshotClient, veleroClient, log); err != nil {
+		errs = append(errs, err)
+	}
+	if err := SweepReleasedSnapshots(ctx, namespace, kubeClient, snapshotClient, veleroClient, log); err != nil {
+		errs = append(errs, err)
+	}
+	if err := WriteDeletionReports(ctx, namespace, kubeClient, snapshotClient, veleroClient, log); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
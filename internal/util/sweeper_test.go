@@ -0,0 +1,61 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
+)
+
+func TestSweepSnapshotsReturnsFailures(t *testing.T) {
+	now := time.Now()
+	newVSC := func(name string) *snapshotv1api.VolumeSnapshotContent {
+		return &snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{velerov1api.BackupNameLabel: "backup"},
+				Annotations: map[string]string{SnapshotExpiresAtAnnotation: now.Add(-time.Hour).Format(time.RFC3339)},
+			},
+			Spec: snapshotv1api.VolumeSnapshotContentSpec{DeletionPolicy: snapshotv1api.VolumeSnapshotContentRetain},
+		}
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(newVSC("vsc-1"), newVSC("vsc-2"))
+	snapshotClient.PrependReactor("delete", "volumesnapshotcontents", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	err := SweepSnapshots(context.Background(), "velero", fake.NewSimpleClientset(), snapshotClient.SnapshotV1(),
+		velerofake.NewSimpleClientset(), now, logrus.New())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "fail to remove expired volumesnapshotcontent vsc-1")
+	assert.Contains(t, err.Error(), "fail to remove expired volumesnapshotcontent vsc-2")
+}
@@ -0,0 +1,136 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+)
+
+const (
+	// DataMoverNodeAffinityConfigKey is the DataMoverConfig key holding the JSON encoded NodeSelector
+	// the nodes of the data mover pods must match to access the volume.
+	DataMoverNodeAffinityConfigKey = "nodeAffinity"
+	// DataMoverNodeSelectorConfigKey is the DataMoverConfig key holding the JSON encoded node labels equivalent
+	// to the node affinity, when it is a single term with one value per label.
+	DataMoverNodeSelectorConfigKey = "nodeSelector"
+)
+
+// topologyLabels are the labels of a PV, set by the CSI driver or the cloud provider, which restrict the nodes it is accessible from.
+var topologyLabels = []string{
+	corev1api.LabelTopologyZone,
+	corev1api.LabelTopologyRegion,
+	corev1api.LabelFailureDomainBetaZone,
+	corev1api.LabelFailureDomainBetaRegion,
+}
+
+// GetPVNodeAffinity returns the nodes the volume of the PV is accessible from: the required node affinity of the PV
+// or, for PVs provisioned before it was set, its topology labels. It returns nil when the PV is accessible from any node.
+func GetPVNodeAffinity(pv *corev1api.PersistentVolume) *corev1api.NodeSelector {
+	if pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil && len(pv.Spec.NodeAffinity.Required.NodeSelectorTerms) > 0 {
+		return pv.Spec.NodeAffinity.Required.DeepCopy()
+	}
+
+	term := corev1api.NodeSelectorTerm{}
+	for _, key := range topologyLabels {
+		if value, ok := pv.Labels[key]; ok {
+			term.MatchExpressions = append(term.MatchExpressions, corev1api.NodeSelectorRequirement{
+				Key:      key,
+				Operator: corev1api.NodeSelectorOpIn,
+				Values:   []string{value},
+			})
+		}
+	}
+	if len(term.MatchExpressions) == 0 {
+		return nil
+	}
+	return &corev1api.NodeSelector{NodeSelectorTerms: []corev1api.NodeSelectorTerm{term}}
+}
+
+// GetStorageClassNodeAffinity returns the nodes the volumes provisioned by the storage class are accessible from,
+// according to its allowed topologies. It returns nil when the storage class doesn't restrict the topology.
+func GetStorageClassNodeAffinity(storageClass *storagev1api.StorageClass) *corev1api.NodeSelector {
+	affinity := &corev1api.NodeSelector{}
+	for _, topology := range storageClass.AllowedTopologies {
+		term := corev1api.NodeSelectorTerm{}
+		for _, expression := range topology.MatchLabelExpressions {
+			term.MatchExpressions = append(term.MatchExpressions, corev1api.NodeSelectorRequirement{
+				Key:      expression.Key,
+				Operator: corev1api.NodeSelectorOpIn,
+				Values:   append([]string(nil), expression.Values...),
+			})
+		}
+		if len(term.MatchExpressions) > 0 {
+			affinity.NodeSelectorTerms = append(affinity.NodeSelectorTerms, term)
+		}
+	}
+	if len(affinity.NodeSelectorTerms) == 0 {
+		return nil
+	}
+	return affinity
+}
+
+// IsBuiltInDataMover returns whether the data mover is Velero's built-in one, chosen by an empty name or "velero".
+// It ignores the DataMoverConfig, so it doesn't honor the node affinity recorded by SetDataMoverNodeAffinity.
+func IsBuiltInDataMover(dataMover string) bool {
+	return dataMover == "" || dataMover == "velero"
+}
+
+// SetDataMoverNodeAffinity records the node affinity in the DataMoverConfig of a DataUpload or DataDownload,
+// so a data mover other than the built-in one schedules its pods where the volume is accessible. A nil affinity
+// leaves the config unchanged.
+func SetDataMoverNodeAffinity(config map[string]string, affinity *corev1api.NodeSelector) error {
+	if affinity == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(affinity)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal node affinity")
+	}
+	config[DataMoverNodeAffinityConfigKey] = string(data)
+
+	if nodeSelector := nodeSelectorForAffinity(affinity); nodeSelector != nil {
+		if data, err = json.Marshal(nodeSelector); err != nil {
+			return errors.Wrap(err, "fail to marshal node selector")
+		}
+		config[DataMoverNodeSelectorConfigKey] = string(data)
+	}
+	return nil
+}
+
+// nodeSelectorForAffinity returns the node labels equivalent to the affinity, or nil when it can't be expressed as a node selector.
+func nodeSelectorForAffinity(affinity *corev1api.NodeSelector) map[string]string {
+	if len(affinity.NodeSelectorTerms) != 1 || len(affinity.NodeSelectorTerms[0].MatchFields) > 0 {
+		return nil
+	}
+
+	nodeSelector := map[string]string{}
+	for _, requirement := range affinity.NodeSelectorTerms[0].MatchExpressions {
+		if requirement.Operator != corev1api.NodeSelectorOpIn || len(requirement.Values) != 1 {
+			return nil
+		}
+		nodeSelector[requirement.Key] = requirement.Values[0]
+	}
+	if len(nodeSelector) == 0 {
+		return nil
+	}
+	return nodeSelector
+}
@@ -0,0 +1,137 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPVNodeAffinity(t *testing.T) {
+	zoneA := &corev1api.NodeSelector{NodeSelectorTerms: []corev1api.NodeSelectorTerm{{
+		MatchExpressions: []corev1api.NodeSelectorRequirement{{
+			Key:      corev1api.LabelTopologyZone,
+			Operator: corev1api.NodeSelectorOpIn,
+			Values:   []string{"zone-a"},
+		}},
+	}}}
+
+	tests := []struct {
+		name     string
+		pv       *corev1api.PersistentVolume
+		expected *corev1api.NodeSelector
+	}{
+		{
+			name: "node affinity of the PV",
+			pv: &corev1api.PersistentVolume{
+				Spec: corev1api.PersistentVolumeSpec{NodeAffinity: &corev1api.VolumeNodeAffinity{Required: zoneA}},
+			},
+			expected: zoneA,
+		},
+		{
+			name: "topology labels of the PV",
+			pv: &corev1api.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{corev1api.LabelTopologyZone: "zone-a", "app": "db"}},
+			},
+			expected: zoneA,
+		},
+		{
+			name: "PV accessible from any node",
+			pv:   &corev1api.PersistentVolume{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, GetPVNodeAffinity(tc.pv))
+		})
+	}
+}
+
+func TestGetStorageClassNodeAffinity(t *testing.T) {
+	storageClass := &storagev1api.StorageClass{
+		AllowedTopologies: []corev1api.TopologySelectorTerm{{
+			MatchLabelExpressions: []corev1api.TopologySelectorLabelRequirement{{
+				Key:    corev1api.LabelTopologyZone,
+				Values: []string{"zone-a", "zone-b"},
+			}},
+		}},
+	}
+
+	assert.Equal(t, &corev1api.NodeSelector{NodeSelectorTerms: []corev1api.NodeSelectorTerm{{
+		MatchExpressions: []corev1api.NodeSelectorRequirement{{
+			Key:      corev1api.LabelTopologyZone,
+			Operator: corev1api.NodeSelectorOpIn,
+			Values:   []string{"zone-a", "zone-b"},
+		}},
+	}}}, GetStorageClassNodeAffinity(storageClass))
+	assert.Nil(t, GetStorageClassNodeAffinity(&storagev1api.StorageClass{}))
+}
+
+func TestSetDataMoverNodeAffinity(t *testing.T) {
+	tests := []struct {
+		name               string
+		values             []string
+		expectNodeSelector bool
+	}{
+		{
+			name:               "single zone is also a node selector",
+			values:             []string{"zone-a"},
+			expectNodeSelector: true,
+		},
+		{
+			name:   "several zones are only a node affinity",
+			values: []string{"zone-a", "zone-b"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			affinity := &corev1api.NodeSelector{NodeSelectorTerms: []corev1api.NodeSelectorTerm{{
+				MatchExpressions: []corev1api.NodeSelectorRequirement{{
+					Key:      corev1api.LabelTopologyZone,
+					Operator: corev1api.NodeSelectorOpIn,
+					Values:   tc.values,
+				}},
+			}}}
+			config := map[string]string{}
+			require.NoError(t, SetDataMoverNodeAffinity(config, affinity))
+
+			assert.Contains(t, config, DataMoverNodeAffinityConfigKey)
+			if tc.expectNodeSelector {
+				assert.Equal(t, `{"topology.kubernetes.io/zone":"zone-a"}`, config[DataMoverNodeSelectorConfigKey])
+			} else {
+				assert.NotContains(t, config, DataMoverNodeSelectorConfigKey)
+			}
+		})
+	}
+
+	config := map[string]string{}
+	require.NoError(t, SetDataMoverNodeAffinity(config, nil))
+	assert.Empty(t, config)
+}
+
+func TestIsBuiltInDataMover(t *testing.T) {
+	assert.True(t, IsBuiltInDataMover(""))
+	assert.True(t, IsBuiltInDataMover("velero"))
+	assert.False(t, IsBuiltInDataMover("vendor.example.com/mover"))
+}
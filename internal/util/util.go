@@ -19,7 +19,10 @@ package util
 import (
 	"context"
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
@@ -34,8 +37,10 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/metrics"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
 	"github.com/vmware-tanzu/velero/pkg/label"
@@ -43,11 +48,16 @@ import (
 )
 
 const (
-	VolumeSnapshotKindName    = "VolumeSnapshot"
-	defaultCSISnapshotTimeout = 10 * time.Minute
+	VolumeSnapshotKindName        = "VolumeSnapshot"
+	VolumeSnapshotContentKindName = "VolumeSnapshotContent"
+	defaultCSISnapshotTimeout     = 10 * time.Minute
+
+	// ClientQPSEnv and ClientBurstEnv are the environment variables holding the QPS and burst of the plugin's API clients.
+	ClientQPSEnv   = "VELERO_CSI_CLIENT_QPS"
+	ClientBurstEnv = "VELERO_CSI_CLIENT_BURST"
 )
 
-func GetPVForPVC(pvc *corev1api.PersistentVolumeClaim, corev1 corev1client.PersistentVolumesGetter) (*corev1api.PersistentVolume, error) {
+func GetPVForPVC(ctx context.Context, pvc *corev1api.PersistentVolumeClaim, corev1 corev1client.PersistentVolumesGetter) (*corev1api.PersistentVolume, error) {
 	if pvc.Spec.VolumeName == "" {
 		return nil, errors.Errorf("PVC %s/%s has no volume backing this claim", pvc.Namespace, pvc.Name)
 	}
@@ -56,16 +66,16 @@ func GetPVForPVC(pvc *corev1api.PersistentVolumeClaim, corev1 corev1client.Persi
 		return nil, errors.Errorf("PVC %s/%s is in phase %v and is not bound to a volume", pvc.Namespace, pvc.Name, pvc.Status.Phase)
 	}
 	pvName := pvc.Spec.VolumeName
-	pv, err := corev1.PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
+	pv, err := corev1.PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get PV %s for PVC %s/%s", pvName, pvc.Namespace, pvc.Name)
 	}
 	return pv, nil
 }
 
-func GetPodsUsingPVC(pvcNamespace, pvcName string, corev1 corev1client.PodsGetter) ([]corev1api.Pod, error) {
+func GetPodsUsingPVC(ctx context.Context, pvcNamespace, pvcName string, corev1 corev1client.PodsGetter) ([]corev1api.Pod, error) {
 	podsUsingPVC := []corev1api.Pod{}
-	podList, err := corev1.Pods(pvcNamespace).List(context.TODO(), metav1.ListOptions{})
+	podList, err := corev1.Pods(pvcNamespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -99,12 +109,17 @@ func Contains(slice []string, key string) bool {
 	return false
 }
 
-func IsPVCDefaultToFSBackup(pvcNamespace, pvcName string, podClient corev1client.PodsGetter, defaultVolumesToFsBackup bool) (bool, error) {
-	pods, err := GetPodsUsingPVC(pvcNamespace, pvcName, podClient)
+func IsPVCDefaultToFSBackup(ctx context.Context, pvcNamespace, pvcName string, podClient corev1client.PodsGetter, defaultVolumesToFsBackup bool) (bool, error) {
+	pods, err := GetPodsUsingPVC(ctx, pvcNamespace, pvcName, podClient)
 	if err != nil {
 		return false, errors.WithStack(err)
 	}
 
+	return IsPVCDefaultToFSBackupForPods(pvcName, pods, defaultVolumesToFsBackup)
+}
+
+// IsPVCDefaultToFSBackupForPods returns whether one of the pods using the PVC backs it up with the FS uploader.
+func IsPVCDefaultToFSBackupForPods(pvcName string, pods []corev1api.Pod, defaultVolumesToFsBackup bool) (bool, error) {
 	for _, p := range pods {
 		vols, _ := podvolume.GetVolumesByPod(&p, defaultVolumesToFsBackup)
 		if len(vols) > 0 {
@@ -120,18 +135,29 @@ func IsPVCDefaultToFSBackup(pvcNamespace, pvcName string, podClient corev1client
 
 	return false, nil
 }
-func GetVolumeSnapshotClass(provisioner string, backup *velerov1api.Backup, pvc *corev1api.PersistentVolumeClaim, log logrus.FieldLogger, snapshotClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotClass, error) {
-	snapshotClasses, err := snapshotClient.VolumeSnapshotClasses().List(context.TODO(), metav1.ListOptions{})
+func GetVolumeSnapshotClass(ctx context.Context, provisioner string, backup *velerov1api.Backup, pvc *corev1api.PersistentVolumeClaim, log logrus.FieldLogger, snapshotClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotClass, error) {
+	snapshotClass, _, err := GetVolumeSnapshotClassWithSource(ctx, provisioner, backup, pvc, log, snapshotClient)
+	return snapshotClass, err
+}
+
+// GetVolumeSnapshotClassWithSource returns the VolumeSnapshotClass to snapshot the PVC with, and where the choice came from.
+func GetVolumeSnapshotClassWithSource(ctx context.Context, provisioner string, backup *velerov1api.Backup, pvc *corev1api.PersistentVolumeClaim, log logrus.FieldLogger, snapshotClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotClass, string, error) {
+	snapshotClasses, err := snapshotClient.VolumeSnapshotClasses().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, errors.Wrap(err, "error listing volumesnapshot classes")
+		return nil, "", errors.Wrap(err, "error listing volumesnapshot classes")
 	}
+	return ChooseVolumeSnapshotClass(provisioner, backup, pvc, log, snapshotClasses)
+}
+
+// ChooseVolumeSnapshotClass returns the VolumeSnapshotClass out of snapshotClasses to snapshot the PVC with, and where the choice came from.
+func ChooseVolumeSnapshotClass(provisioner string, backup *velerov1api.Backup, pvc *corev1api.PersistentVolumeClaim, log logrus.FieldLogger, snapshotClasses *snapshotv1api.VolumeSnapshotClassList) (*snapshotv1api.VolumeSnapshotClass, string, error) {
 	// If a snapshot class is sent for provider in PVC annotations, use that
 	snapshotClass, err := GetVolumeSnapshotClassFromPVCAnnotationsForDriver(pvc, provisioner, snapshotClasses)
 	if err != nil {
 		log.Debugf("Didn't find VolumeSnapshotClass from PVC annotations: %v", err)
 	}
 	if snapshotClass != nil {
-		return snapshotClass, nil
+		return snapshotClass, VolumeSnapshotClassSourcePVCAnnotation, nil
 	}
 
 	// If there is no annotation in PVC, attempt to fetch it from backup annotations
@@ -140,16 +166,19 @@ func GetVolumeSnapshotClass(provisioner string, backup *velerov1api.Backup, pvc
 		log.Debugf("Didn't find VolumeSnapshotClass from Backup annotations: %v", err)
 	}
 	if snapshotClass != nil {
-		return snapshotClass, nil
+		return snapshotClass, VolumeSnapshotClassSourceBackupAnnotation, nil
 	}
 
 	// fallback to default behaviour of fetching snapshot class based on label
 	snapshotClass, err = GetVolumeSnapshotClassForStorageClass(provisioner, snapshotClasses)
 	if err != nil || snapshotClass == nil {
-		return nil, errors.Wrap(err, "error getting volumesnapshotclass")
+		return nil, "", errors.Wrap(err, "error getting volumesnapshotclass")
+	}
+	if _, ok := snapshotClass.Labels[VolumeSnapshotClassSelectorLabel]; ok {
+		return snapshotClass, VolumeSnapshotClassSourceLabel, nil
 	}
 
-	return snapshotClass, nil
+	return snapshotClass, VolumeSnapshotClassSourceOnlyClass, nil
 }
 
 func GetVolumeSnapshotClassFromPVCAnnotationsForDriver(pvc *corev1api.PersistentVolumeClaim, provisioner string, snapshotClasses *snapshotv1api.VolumeSnapshotClassList) (*snapshotv1api.VolumeSnapshotClass, error) {
@@ -212,14 +241,15 @@ func GetVolumeSnapshotClassForStorageClass(provisioner string, snapshotClasses *
 	return nil, errors.Errorf("failed to get volumesnapshotclass for provisioner %s, ensure that the desired volumesnapshot class has the %s label", provisioner, VolumeSnapshotClassSelectorLabel)
 }
 
-// GetVolumeSnapshotContentForVolumeSnapshot returns the volumesnapshotcontent object associated with the volumesnapshot
-func GetVolumeSnapshotContentForVolumeSnapshot(volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger, shouldWait bool, csiSnapshotTimeout time.Duration) (*snapshotv1api.VolumeSnapshotContent, error) {
+// GetVolumeSnapshotContentForVolumeSnapshot returns the volumesnapshotcontent object associated with the volumesnapshot.
+// The wait is aborted when ctx is done.
+func GetVolumeSnapshotContentForVolumeSnapshot(ctx context.Context, volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger, shouldWait bool, csiSnapshotTimeout time.Duration) (*snapshotv1api.VolumeSnapshotContent, error) {
 	if !shouldWait {
 		if volSnap.Status == nil || volSnap.Status.BoundVolumeSnapshotContentName == nil {
 			// volumesnapshot hasn't been reconciled and we're not waiting for it.
 			return nil, nil
 		}
-		vsc, err := snapshotClient.VolumeSnapshotContents().Get(context.TODO(), *volSnap.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+		vsc, err := snapshotClient.VolumeSnapshotContents().Get(ctx, *volSnap.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
 		if err != nil {
 			return nil, errors.Wrap(err, "error getting volume snapshot content from API")
 		}
@@ -234,8 +264,8 @@ func GetVolumeSnapshotContentForVolumeSnapshot(volSnap *snapshotv1api.VolumeSnap
 	interval := 5 * time.Second
 	var snapshotContent *snapshotv1api.VolumeSnapshotContent
 
-	err := wait.PollImmediate(interval, timeout, func() (bool, error) {
-		vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(context.TODO(), volSnap.Name, metav1.GetOptions{})
+	err := wait.PollImmediateWithContext(ctx, interval, timeout, func(ctx context.Context) (bool, error) {
+		vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(ctx, volSnap.Name, metav1.GetOptions{})
 		if err != nil {
 			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name))
 		}
@@ -245,7 +275,7 @@ func GetVolumeSnapshotContentForVolumeSnapshot(volSnap *snapshotv1api.VolumeSnap
 			return false, nil
 		}
 
-		snapshotContent, err = snapshotClient.VolumeSnapshotContents().Get(context.TODO(), *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+		snapshotContent, err = snapshotClient.VolumeSnapshotContents().Get(ctx, *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
 		if err != nil {
 			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshotcontent %s for volumesnapshot %s/%s", *vs.Status.BoundVolumeSnapshotContentName, vs.Namespace, vs.Name))
 		}
@@ -265,8 +295,13 @@ func GetVolumeSnapshotContentForVolumeSnapshot(volSnap *snapshotv1api.VolumeSnap
 	})
 
 	if err != nil {
+		if ctx.Err() != nil {
+			log.Errorf("Stopped awaiting reconciliation of volumesnapshot %s/%s: %v", volSnap.Namespace, volSnap.Name, ctx.Err())
+			return nil, errors.Wrapf(ctx.Err(), "stopped waiting for volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name)
+		}
 		if err == wait.ErrWaitTimeout {
-			if snapshotContent.Status != nil && snapshotContent.Status.Error != nil {
+			metrics.RecordSnapshotWaitTimeout()
+			if snapshotContent != nil && snapshotContent.Status != nil && snapshotContent.Status.Error != nil {
 				log.Errorf("Timed out awaiting reconciliation of volumesnapshot, Volumesnapshotcontent %s has error: %v", snapshotContent.Name, snapshotContent.Status.Error.Message)
 			} else {
 				log.Errorf("Timed out awaiting reconciliation of volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name)
@@ -278,13 +313,46 @@ func GetVolumeSnapshotContentForVolumeSnapshot(volSnap *snapshotv1api.VolumeSnap
 	return snapshotContent, nil
 }
 
+// GetVolumeSnapshotDriver returns the CSI driver of the VolumeSnapshot, from the annotation recorded at backup
+// or from its VolumeSnapshotClass. It returns "unknown" when the driver cannot be found.
+func GetVolumeSnapshotDriver(ctx context.Context, vs *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface) string {
+	if driver, ok := vs.Annotations[CSIDriverNameAnnotation]; ok {
+		return driver
+	}
+	if vs.Spec.VolumeSnapshotClassName != nil {
+		class, err := snapshotClient.VolumeSnapshotClasses().Get(ctx, *vs.Spec.VolumeSnapshotClassName, metav1.GetOptions{})
+		if err == nil {
+			return class.Driver
+		}
+	}
+	return "unknown"
+}
+
 func GetClients() (*kubernetes.Clientset, snapshotterClientSet.Interface, error) {
 	client, snapshotterClient, _, err := GetFullClients()
 
 	return client, snapshotterClient, err
 }
 
+var (
+	sharedKubeClient     *kubernetes.Clientset
+	sharedSnapshotClient snapshotterClientSet.Interface
+	sharedVeleroClient   *veleroClientSet.Clientset
+	sharedClientsErr     error
+	sharedClientsOnce    sync.Once
+)
+
+// GetFullClients returns the clients shared by all actions of the plugin process. They are created
+// on first use, with the QPS and burst set by the ClientQPSEnv and ClientBurstEnv environment variables.
 func GetFullClients() (*kubernetes.Clientset, snapshotterClientSet.Interface, *veleroClientSet.Clientset, error) {
+	sharedClientsOnce.Do(func() {
+		sharedKubeClient, sharedSnapshotClient, sharedVeleroClient, sharedClientsErr = newFullClients()
+	})
+
+	return sharedKubeClient, sharedSnapshotClient, sharedVeleroClient, sharedClientsErr
+}
+
+func newFullClients() (*kubernetes.Clientset, snapshotterClientSet.Interface, *veleroClientSet.Clientset, error) {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	configOverrides := &clientcmd.ConfigOverrides{}
 	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
@@ -292,6 +360,9 @@ func GetFullClients() (*kubernetes.Clientset, snapshotterClientSet.Interface, *v
 	if err != nil {
 		return nil, nil, nil, errors.WithStack(err)
 	}
+	if err := setClientRateLimits(clientConfig); err != nil {
+		return nil, nil, nil, err
+	}
 
 	client, err := kubernetes.NewForConfig(clientConfig)
 	if err != nil {
@@ -311,6 +382,25 @@ func GetFullClients() (*kubernetes.Clientset, snapshotterClientSet.Interface, *v
 	return client, snapshotterClient, veleroClient, nil
 }
 
+// setClientRateLimits sets the QPS and burst of the client config from the environment, if they are set.
+func setClientRateLimits(clientConfig *rest.Config) error {
+	if value := os.Getenv(ClientQPSEnv); value != "" {
+		qps, err := strconv.ParseFloat(value, 32)
+		if err != nil || qps <= 0 {
+			return errors.Errorf("invalid %s %q, it must be a positive number", ClientQPSEnv, value)
+		}
+		clientConfig.QPS = float32(qps)
+	}
+	if value := os.Getenv(ClientBurstEnv); value != "" {
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst <= 0 {
+			return errors.Errorf("invalid %s %q, it must be a positive integer", ClientBurstEnv, value)
+		}
+		clientConfig.Burst = burst
+	}
+	return nil
+}
+
 // IsVolumeSnapshotClassHasListerSecret returns whether a volumesnapshotclass has a snapshotlister secret
 func IsVolumeSnapshotClassHasListerSecret(vc *snapshotv1api.VolumeSnapshotClass) bool {
 	// https://github.com/kubernetes-csi/external-snapshotter/blob/master/pkg/utils/util.go#L59-L60
@@ -358,10 +448,10 @@ func AddLabels(o *metav1.ObjectMeta, vals map[string]string) {
 }
 
 // IsVolumeSnapshotExists returns whether a specific volumesnapshot object exists.
-func IsVolumeSnapshotExists(volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface) bool {
+func IsVolumeSnapshotExists(ctx context.Context, volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface) bool {
 	exists := false
 	if volSnap != nil {
-		vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(context.TODO(), volSnap.Name, metav1.GetOptions{})
+		vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(ctx, volSnap.Name, metav1.GetOptions{})
 		if err == nil && vs != nil {
 			exists = true
 		}
@@ -370,9 +460,9 @@ func IsVolumeSnapshotExists(volSnap *snapshotv1api.VolumeSnapshot, snapshotClien
 	return exists
 }
 
-func SetVolumeSnapshotContentDeletionPolicy(vscName string, csiClient snapshotter.SnapshotV1Interface) error {
+func SetVolumeSnapshotContentDeletionPolicy(ctx context.Context, vscName string, csiClient snapshotter.SnapshotV1Interface) error {
 	pb := []byte(`{"spec":{"deletionPolicy":"Delete"}}`)
-	_, err := csiClient.VolumeSnapshotContents().Patch(context.TODO(), vscName, types.MergePatchType, pb, metav1.PatchOptions{})
+	_, err := csiClient.VolumeSnapshotContents().Patch(ctx, vscName, types.MergePatchType, pb, metav1.PatchOptions{})
 
 	return err
 }
@@ -384,9 +474,9 @@ func HasBackupLabel(o *metav1.ObjectMeta, backupName string) bool {
 	return o.Labels[velerov1api.BackupNameLabel] == label.GetValidName(backupName)
 }
 
-func CleanupVolumeSnapshot(volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) {
+func CleanupVolumeSnapshot(ctx context.Context, volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) {
 	log.Infof("Deleting Volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name)
-	vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(context.TODO(), volSnap.Name, metav1.GetOptions{})
+	vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(ctx, volSnap.Name, metav1.GetOptions{})
 	if err != nil {
 		log.Debugf("Failed to get volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name)
 		return
@@ -395,12 +485,12 @@ func CleanupVolumeSnapshot(volSnap *snapshotv1api.VolumeSnapshot, snapshotClient
 	if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil {
 		// we patch the DeletionPolicy of the volumesnapshotcontent to set it to Delete.
 		// This ensures that the volume snapshot in the storage provider is also deleted.
-		err := SetVolumeSnapshotContentDeletionPolicy(*vs.Status.BoundVolumeSnapshotContentName, snapshotClient)
+		err := SetVolumeSnapshotContentDeletionPolicy(ctx, *vs.Status.BoundVolumeSnapshotContentName, snapshotClient)
 		if err != nil {
 			log.Debugf("Failed to patch DeletionPolicy of volume snapshot %s/%s", vs.Namespace, vs.Name)
 		}
 	}
-	err = snapshotClient.VolumeSnapshots(vs.Namespace).Delete(context.TODO(), vs.Name, metav1.DeleteOptions{})
+	err = snapshotClient.VolumeSnapshots(vs.Namespace).Delete(ctx, vs.Name, metav1.DeleteOptions{})
 	if err != nil {
 		log.Debugf("Failed to delete volumesnapshot %s/%s: %v", vs.Namespace, vs.Name, err)
 	} else {
@@ -410,7 +500,7 @@ func CleanupVolumeSnapshot(volSnap *snapshotv1api.VolumeSnapshot, snapshotClient
 
 // deleteVolumeSnapshot is called by deleteVolumeSnapshots and handles the single VolumeSnapshot
 // instance.
-func DeleteVolumeSnapshot(vs snapshotv1api.VolumeSnapshot, vsc snapshotv1api.VolumeSnapshotContent,
+func DeleteVolumeSnapshot(ctx context.Context, vs snapshotv1api.VolumeSnapshot, vsc snapshotv1api.VolumeSnapshotContent,
 	backup *velerov1api.Backup, snapshotClient snapshotter.SnapshotV1Interface, logger logrus.FieldLogger) {
 	modifyVSCFlag := false
 	if vs.Status != nil && vs.Status.BoundVolumeSnapshotContentName != nil && len(*vs.Status.BoundVolumeSnapshotContentName) > 0 {
@@ -428,7 +518,7 @@ func DeleteVolumeSnapshot(vs snapshotv1api.VolumeSnapshot, vsc snapshotv1api.Vol
 	if modifyVSCFlag {
 		logger.Debugf("Patching VolumeSnapshotContent %s", vsc.Name)
 		patchData := []byte(fmt.Sprintf(`{"spec":{"deletionPolicy":"%s"}}`, snapshotv1api.VolumeSnapshotContentRetain))
-		updatedVSC, err := snapshotClient.VolumeSnapshotContents().Patch(context.Background(), vsc.Name, types.MergePatchType, patchData, metav1.PatchOptions{})
+		updatedVSC, err := snapshotClient.VolumeSnapshotContents().Patch(ctx, vsc.Name, types.MergePatchType, patchData, metav1.PatchOptions{})
 		if err != nil {
 			logger.Errorf("fail to modify VolumeSnapshotContent %s DeletionPolicy to Retain: %s", vsc.Name, err.Error())
 			return
@@ -445,7 +535,7 @@ func DeleteVolumeSnapshot(vs snapshotv1api.VolumeSnapshot, vsc snapshotv1api.Vol
 
 	// Delete VolumeSnapshot from cluster
 	logger.Debugf("Deleting VolumeSnapshot %s/%s", vs.Namespace, vs.Name)
-	err := snapshotClient.VolumeSnapshots(vs.Namespace).Delete(context.TODO(), vs.Name, metav1.DeleteOptions{})
+	err := snapshotClient.VolumeSnapshots(vs.Namespace).Delete(ctx, vs.Name, metav1.DeleteOptions{})
 	if err != nil {
 		logger.Errorf("fail to delete VolumeSnapshot %s/%s: %s", vs.Namespace, vs.Name, err.Error())
 	}
@@ -456,25 +546,27 @@ func DeleteVolumeSnapshot(vs snapshotv1api.VolumeSnapshot, vsc snapshotv1api.Vol
 // and Source. Source is updated to let csi-controller thinks the VSC is statically provsisioned with VS.
 // Set VolumeSnapshotRef's UID to nil will let the csi-controller finds out the related VS is gone, then
 // VSC can be deleted.
+// Once the VolumeSnapshotContent is deleted, it must be created again, or its storage snapshot is left without one.
+// So the sequence doesn't run on the context of the action, which may end in between, but on its own timeout.
 func recreateVolumeSnapshotContent(vsc snapshotv1api.VolumeSnapshotContent, backup *velerov1api.Backup,
 	snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) error {
 	// Read resource timeout from backup annotation, if not set, use default value.
-	timeout, err := time.ParseDuration(backup.Annotations[ResourceTimeoutAnnotation])
-	if err != nil {
-		log.Warnf("fail to parse resource timeout annotation %s: %s", backup.Annotations[ResourceTimeoutAnnotation], err.Error())
-		timeout = 10 * time.Minute
-	}
+	timeout := GetResourceTimeout(backup.Annotations, log)
 	log.Debugf("resource timeout is set to %s", timeout.String())
 	interval := 1 * time.Second
 
-	err = snapshotClient.VolumeSnapshotContents().Delete(context.TODO(), vsc.Name, metav1.DeleteOptions{})
+	// The wait for the deletion takes up to the timeout, the create gets the rest.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*timeout)
+	defer cancel()
+
+	err := snapshotClient.VolumeSnapshotContents().Delete(ctx, vsc.Name, metav1.DeleteOptions{})
 	if err != nil {
 		return errors.Wrapf(err, "fail to delete VolumeSnapshotContent: %s", vsc.Name)
 	}
 
 	// Check VolumeSnapshotContents is already deleted, before re-creating it.
-	err = wait.PollImmediate(interval, timeout, func() (bool, error) {
-		_, err := snapshotClient.VolumeSnapshotContents().Get(context.TODO(), vsc.Name, metav1.GetOptions{})
+	err = wait.PollImmediateWithContext(ctx, interval, timeout, func(ctx context.Context) (bool, error) {
+		_, err := snapshotClient.VolumeSnapshotContents().Get(ctx, vsc.Name, metav1.GetOptions{})
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				return true, nil
@@ -484,6 +576,9 @@ func recreateVolumeSnapshotContent(vsc snapshotv1api.VolumeSnapshotContent, back
 		return false, nil
 	})
 	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
 		return errors.Wrapf(err, "fail to retrieve VolumeSnapshotContent %s info", vsc.Name)
 	}
 
@@ -503,7 +598,7 @@ func recreateVolumeSnapshotContent(vsc snapshotv1api.VolumeSnapshotContent, back
 	}
 	// ResourceVersion shouldn't exist for new creation.
 	vsc.ResourceVersion = ""
-	_, err = snapshotClient.VolumeSnapshotContents().Create(context.TODO(), &vsc, metav1.CreateOptions{})
+	_, err = snapshotClient.VolumeSnapshotContents().Create(ctx, &vsc, metav1.CreateOptions{})
 	if err != nil {
 		return errors.Wrapf(err, "fail to create VolumeSnapshotContent %s", vsc.Name)
 	}
@@ -158,7 +158,7 @@ func TestGetPVForPVC(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actualPV, actualError := GetPVForPVC(tc.inPVC, fakeClient.CoreV1())
+			actualPV, actualError := GetPVForPVC(context.Background(), tc.inPVC, fakeClient.CoreV1())
 
 			if tc.expectError {
 				assert.NotNil(t, actualError, "Want error; Got nil error")
@@ -281,7 +281,7 @@ func TestGetPodsUsingPVC(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actualPods, err := GetPodsUsingPVC(tc.pvcNamespace, tc.pvcName, fakeClient.CoreV1())
+			actualPods, err := GetPodsUsingPVC(context.Background(), tc.pvcNamespace, tc.pvcName, fakeClient.CoreV1())
 			assert.Nilf(t, err, "Want error=nil; Got error=%v", err)
 			assert.Equalf(t, len(actualPods), tc.expectedPodCount, "unexpected number of pods in result; Want: %d; Got: %d", tc.expectedPodCount, len(actualPods))
 		})
@@ -618,7 +618,7 @@ func TestIsPVCDefaultToFSBackup(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actualIsFSUploaderUsed, _ := IsPVCDefaultToFSBackup(tc.inPVCNamespace, tc.inPVCName, fakeClient.CoreV1(), tc.defaultVolumesToFSBackup)
+			actualIsFSUploaderUsed, _ := IsPVCDefaultToFSBackup(context.Background(), tc.inPVCNamespace, tc.inPVCName, fakeClient.CoreV1(), tc.defaultVolumesToFSBackup)
 			assert.Equal(t, tc.expectedIsFSUploaderUsed, actualIsFSUploaderUsed)
 		})
 	}
@@ -747,60 +747,67 @@ func TestGetVolumeSnapshotClass(t *testing.T) {
 	fakeClient := snapshotFake.NewSimpleClientset(objs...)
 
 	testCases := []struct {
-		name        string
-		driverName  string
-		pvc         *v1.PersistentVolumeClaim
-		backup      *velerov1api.Backup
-		expectedVSC *snapshotv1api.VolumeSnapshotClass
-		expectError bool
+		name           string
+		driverName     string
+		pvc            *v1.PersistentVolumeClaim
+		backup         *velerov1api.Backup
+		expectedVSC    *snapshotv1api.VolumeSnapshotClass
+		expectedSource string
+		expectError    bool
 	}{
 		{
-			name:        "no annotations on pvc and backup, should find hostpath volumesnapshotclass using default behaviour of labels",
-			driverName:  "hostpath.csi.k8s.io",
-			pvc:         pvcNone,
-			backup:      backupNone,
-			expectedVSC: hostpathClass,
-			expectError: false,
+			name:           "no annotations on pvc and backup, should find hostpath volumesnapshotclass using default behaviour of labels",
+			driverName:     "hostpath.csi.k8s.io",
+			pvc:            pvcNone,
+			backup:         backupNone,
+			expectedVSC:    hostpathClass,
+			expectedSource: VolumeSnapshotClassSourceLabel,
+			expectError:    false,
 		},
 		{
-			name:        "foowithoutlabel VSC annotations on pvc",
-			driverName:  "foo.csi.k8s.io",
-			pvc:         pvcFoo,
-			backup:      backupNone,
-			expectedVSC: fooClassWithoutLabel,
-			expectError: false,
+			name:           "foowithoutlabel VSC annotations on pvc",
+			driverName:     "foo.csi.k8s.io",
+			pvc:            pvcFoo,
+			backup:         backupNone,
+			expectedVSC:    fooClassWithoutLabel,
+			expectedSource: VolumeSnapshotClassSourcePVCAnnotation,
+			expectError:    false,
 		},
 		{
-			name:        "foowithoutlabel VSC annotations on pvc, but csi driver does not match, no annotation on backup so fallback to default behaviour of labels",
-			driverName:  "bar.csi.k8s.io",
-			pvc:         pvcFoo,
-			backup:      backupNone,
-			expectedVSC: barClass,
-			expectError: false,
+			name:           "foowithoutlabel VSC annotations on pvc, but csi driver does not match, no annotation on backup so fallback to default behaviour of labels",
+			driverName:     "bar.csi.k8s.io",
+			pvc:            pvcFoo,
+			backup:         backupNone,
+			expectedVSC:    barClass,
+			expectedSource: VolumeSnapshotClassSourceLabel,
+			expectError:    false,
 		},
 		{
-			name:        "foowithoutlabel VSC annotations on pvc, but csi driver does not match so fallback to fetch from backupAnnotations ",
-			driverName:  "bar.csi.k8s.io",
-			pvc:         pvcFoo,
-			backup:      backupBar2,
-			expectedVSC: barClass2,
-			expectError: false,
+			name:           "foowithoutlabel VSC annotations on pvc, but csi driver does not match so fallback to fetch from backupAnnotations ",
+			driverName:     "bar.csi.k8s.io",
+			pvc:            pvcFoo,
+			backup:         backupBar2,
+			expectedVSC:    barClass2,
+			expectedSource: VolumeSnapshotClassSourceBackupAnnotation,
+			expectError:    false,
 		},
 		{
-			name:        "foowithoutlabel VSC annotations on backup for foo.csi.k8s.io",
-			driverName:  "foo.csi.k8s.io",
-			pvc:         pvcNone,
-			backup:      backupFoo,
-			expectedVSC: fooClassWithoutLabel,
-			expectError: false,
+			name:           "foowithoutlabel VSC annotations on backup for foo.csi.k8s.io",
+			driverName:     "foo.csi.k8s.io",
+			pvc:            pvcNone,
+			backup:         backupFoo,
+			expectedVSC:    fooClassWithoutLabel,
+			expectedSource: VolumeSnapshotClassSourceBackupAnnotation,
+			expectError:    false,
 		},
 		{
-			name:        "foowithoutlabel VSC annotations on backup for bar.csi.k8s.io, no annotation corresponding to foo.csi.k8s.io, so fallback to default behaviour of labels",
-			driverName:  "bar.csi.k8s.io",
-			pvc:         pvcNone,
-			backup:      backupFoo,
-			expectedVSC: barClass,
-			expectError: false,
+			name:           "foowithoutlabel VSC annotations on backup for bar.csi.k8s.io, no annotation corresponding to foo.csi.k8s.io, so fallback to default behaviour of labels",
+			driverName:     "bar.csi.k8s.io",
+			pvc:            pvcNone,
+			backup:         backupFoo,
+			expectedVSC:    barClass,
+			expectedSource: VolumeSnapshotClassSourceLabel,
+			expectError:    false,
 		},
 		{
 			name:        "no snapshotClass for given driver",
@@ -811,23 +818,28 @@ func TestGetVolumeSnapshotClass(t *testing.T) {
 			expectError: true,
 		},
 		{
-			name:        "foo2 VSC annotations on pvc, but doesn't exist in cluster, fallback to default behaviour of labels",
-			driverName:  "foo.csi.k8s.io",
-			pvc:         pvcFoo2,
-			backup:      backupFoo2,
-			expectedVSC: fooClass,
-			expectError: false,
+			name:           "foo2 VSC annotations on pvc, but doesn't exist in cluster, fallback to default behaviour of labels",
+			driverName:     "foo.csi.k8s.io",
+			pvc:            pvcFoo2,
+			backup:         backupFoo2,
+			expectedVSC:    fooClass,
+			expectedSource: VolumeSnapshotClassSourceLabel,
+			expectError:    false,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actualSnapshotClass, actualError := GetVolumeSnapshotClass(tc.driverName, tc.backup, tc.pvc, logrus.New(), fakeClient.SnapshotV1())
+			actualSnapshotClass, actualError := GetVolumeSnapshotClass(context.Background(), tc.driverName, tc.backup, tc.pvc, logrus.New(), fakeClient.SnapshotV1())
 			if tc.expectError {
 				assert.NotNil(t, actualError)
 				assert.Nil(t, actualSnapshotClass)
 				return
 			}
 			assert.Equal(t, tc.expectedVSC, actualSnapshotClass)
+
+			_, actualSource, actualError := GetVolumeSnapshotClassWithSource(context.Background(), tc.driverName, tc.backup, tc.pvc, logrus.New(), fakeClient.SnapshotV1())
+			require.NoError(t, actualError)
+			assert.Equal(t, tc.expectedSource, actualSource)
 		})
 	}
 }
@@ -1128,7 +1140,7 @@ func TestGetVolumeSnapshotContentForVolumeSnapshot(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actualVSC, actualError := GetVolumeSnapshotContentForVolumeSnapshot(tc.volSnap, fakeClient.SnapshotV1(), logrus.New().WithField("fake", "test"), tc.wait, 0)
+			actualVSC, actualError := GetVolumeSnapshotContentForVolumeSnapshot(context.Background(), tc.volSnap, fakeClient.SnapshotV1(), logrus.New().WithField("fake", "test"), tc.wait, 0)
 			if tc.expectError && actualError == nil {
 				assert.NotNil(t, actualError)
 				assert.Nil(t, actualVSC)
@@ -1569,7 +1581,7 @@ func TestIsVolumeSnapshotExists(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actual := IsVolumeSnapshotExists(tc.vs, fakeClient.SnapshotV1())
+			actual := IsVolumeSnapshotExists(context.Background(), tc.vs, fakeClient.SnapshotV1())
 			assert.Equal(t, tc.expected, actual)
 		})
 	}
@@ -1636,7 +1648,7 @@ func TestSetVolumeSnapshotContentDeletionPolicy(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			fakeClient := snapshotFake.NewSimpleClientset(tc.objs...)
-			err := SetVolumeSnapshotContentDeletionPolicy(tc.inputVSCName, fakeClient.SnapshotV1())
+			err := SetVolumeSnapshotContentDeletionPolicy(context.Background(), tc.inputVSCName, fakeClient.SnapshotV1())
 			if tc.expectError {
 				assert.NotNil(t, err)
 			} else {
@@ -1749,7 +1761,7 @@ func TestDeleteVolumeSnapshots(t *testing.T) {
 			_, err = vsClient.SnapshotV1().VolumeSnapshotContents().Create(context.Background(), &tc.vsc, metav1.CreateOptions{})
 			require.NoError(t, err)
 
-			DeleteVolumeSnapshot(tc.vs, tc.vsc, backup, vsClient.SnapshotV1(), logger)
+			DeleteVolumeSnapshot(context.Background(), tc.vs, tc.vsc, backup, vsClient.SnapshotV1(), logger)
 
 			vsList, err := vsClient.SnapshotV1().VolumeSnapshots("velero").List(context.TODO(), metav1.ListOptions{})
 			require.NoError(t, err)
@@ -0,0 +1,169 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+// Sources of the VolumeSnapshotClass chosen for a PVC, in the order they are checked.
+const (
+	VolumeSnapshotClassSourcePVCAnnotation    = "pvc-annotation"
+	VolumeSnapshotClassSourceBackupAnnotation = "backup-annotation"
+	VolumeSnapshotClassSourceLabel            = "default-label"
+	VolumeSnapshotClassSourceOnlyClass        = "only-class-for-driver"
+)
+
+// VolumeReport is the record of what the plugin did for one PVC during a backup.
+type VolumeReport struct {
+	// PVC is the namespace/name of the PVC.
+	PVC string `json:"pvc"`
+	PV  string `json:"pv,omitempty"`
+	// Driver is the CSI driver of the PV.
+	Driver                    string `json:"driver,omitempty"`
+	VolumeSnapshotClass       string `json:"volumeSnapshotClass,omitempty"`
+	VolumeSnapshotClassSource string `json:"volumeSnapshotClassSource,omitempty"`
+	// VolumeSnapshot is the namespace/name of the VolumeSnapshot created for the PVC.
+	VolumeSnapshot        string       `json:"volumeSnapshot,omitempty"`
+	VolumeSnapshotContent string       `json:"volumeSnapshotContent,omitempty"`
+	SnapshotHandle        string       `json:"snapshotHandle,omitempty"`
+	RestoreSize           string       `json:"restoreSize,omitempty"`
+	SnapshotCreated       *metav1.Time `json:"snapshotCreated,omitempty"`
+	// HandleDuration is the time from the VolumeSnapshot creation until the storage snapshot handle was available.
+	HandleDuration string `json:"handleDuration,omitempty"`
+	// ReadyDuration is the time from the VolumeSnapshot creation until the VolumeSnapshot was observed ReadyToUse.
+	ReadyDuration        string `json:"readyDuration,omitempty"`
+	DataMoverOperationID string `json:"dataMoverOperationID,omitempty"`
+	DataUpload           string `json:"dataUpload,omitempty"`
+	DataMover            string `json:"dataMover,omitempty"`
+	// RetryOf is the previous backup whose DataUpload was resubmitted or reused for the PVC.
+	RetryOf string `json:"retryOf,omitempty"`
+	// SkipReason explains why no CSI snapshot was taken for the PVC.
+	SkipReason string `json:"skipReason,omitempty"`
+}
+
+// VolumeReportConfigMapName returns the name of the ConfigMap holding the volume reports of the backup.
+func VolumeReportConfigMapName(backupName string) string {
+	return backupName + "-csi-volume-report"
+}
+
+// VolumeReportKey returns the key of the PVC's report in the volume report ConfigMap.
+func VolumeReportKey(pvcNamespace, pvcName string) string {
+	return pvcNamespace + "." + pvcName
+}
+
+// UpdateVolumeReport applies update to the report of the PVC and stores it in the volume report
+// ConfigMap of the backup. The ConfigMap lives in the backup namespace and is owned by the backup,
+// so it is removed together with the backup.
+func UpdateVolumeReport(ctx context.Context, kubeClient kubernetes.Interface, backup *velerov1api.Backup, pvcNamespace, pvcName string,
+	update func(*VolumeReport)) error {
+	name := VolumeReportConfigMapName(backup.Name)
+	key := VolumeReportKey(pvcNamespace, pvcName)
+
+	return retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		cm, err := kubeClient.CoreV1().ConfigMaps(backup.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "fail to get volume report configmap %s/%s", backup.Namespace, name)
+		}
+		exists := err == nil
+		if !exists {
+			cm = newVolumeReportConfigMap(backup)
+		}
+
+		report := VolumeReport{}
+		if data, ok := cm.Data[key]; ok {
+			if err := json.Unmarshal([]byte(data), &report); err != nil {
+				return errors.Wrapf(err, "fail to unmarshal volume report %s", key)
+			}
+		}
+		report.PVC = pvcNamespace + "/" + pvcName
+		update(&report)
+
+		data, err := json.Marshal(report)
+		if err != nil {
+			return errors.Wrapf(err, "fail to marshal volume report %s", key)
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = string(data)
+
+		if exists {
+			_, err = kubeClient.CoreV1().ConfigMaps(backup.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		} else {
+			_, err = kubeClient.CoreV1().ConfigMaps(backup.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+		}
+		return err
+	})
+}
+
+// GetVolumeReports returns the volume reports of the backup keyed by PVC namespace/name.
+func GetVolumeReports(ctx context.Context, kubeClient kubernetes.Interface, backup *velerov1api.Backup) (map[string]VolumeReport, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(backup.Namespace).Get(ctx, VolumeReportConfigMapName(backup.Name), metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to get volume report configmap of backup %s", backup.Name)
+	}
+
+	reports := map[string]VolumeReport{}
+	for key, data := range cm.Data {
+		report := VolumeReport{}
+		if err := json.Unmarshal([]byte(data), &report); err != nil {
+			return nil, errors.Wrapf(err, "fail to unmarshal volume report %s", key)
+		}
+		reports[report.PVC] = report
+	}
+
+	return reports, nil
+}
+
+func newVolumeReportConfigMap(backup *velerov1api.Backup) *corev1api.ConfigMap {
+	return &corev1api.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: backup.Namespace,
+			Name:      VolumeReportConfigMapName(backup.Name),
+			Labels: map[string]string{
+				velerov1api.BackupNameLabel: label.GetValidName(backup.Name),
+				velerov1api.BackupUIDLabel:  string(backup.UID),
+				VolumeReportLabel:           "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: velerov1api.SchemeGroupVersion.String(),
+					Kind:       "Backup",
+					Name:       backup.Name,
+					UID:        backup.UID,
+					Controller: boolptr.True(),
+				},
+			},
+		},
+		Data: map[string]string{},
+	}
+}
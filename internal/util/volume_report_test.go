@@ -0,0 +1,70 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+)
+
+func TestUpdateVolumeReport(t *testing.T) {
+	backup := builder.ForBackup("velero", "backup").ObjectMeta(builder.WithUID("backup-uid")).Result()
+	client := fake.NewSimpleClientset()
+
+	require.NoError(t, UpdateVolumeReport(context.Background(), client, backup, "ns", "pvc-1", func(report *VolumeReport) {
+		report.PV = "pv-1"
+		report.VolumeSnapshotClass = "vsclass"
+		report.VolumeSnapshotClassSource = VolumeSnapshotClassSourceLabel
+	}))
+	require.NoError(t, UpdateVolumeReport(context.Background(), client, backup, "ns", "pvc-1", func(report *VolumeReport) {
+		report.SnapshotHandle = "handle"
+	}))
+	require.NoError(t, UpdateVolumeReport(context.Background(), client, backup, "ns", "pvc-2", func(report *VolumeReport) {
+		report.SkipReason = "PV is not a CSI volume"
+	}))
+
+	cm, err := client.CoreV1().ConfigMaps("velero").Get(context.Background(), "backup-csi-volume-report", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", cm.Labels[VolumeReportLabel])
+	assert.Equal(t, "backup", cm.Labels[velerov1api.BackupNameLabel])
+	require.Len(t, cm.OwnerReferences, 1)
+	assert.Equal(t, "backup-uid", string(cm.OwnerReferences[0].UID))
+
+	reports, err := GetVolumeReports(context.Background(), client, backup)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]VolumeReport{
+		"ns/pvc-1": {
+			PVC:                       "ns/pvc-1",
+			PV:                        "pv-1",
+			VolumeSnapshotClass:       "vsclass",
+			VolumeSnapshotClassSource: VolumeSnapshotClassSourceLabel,
+			SnapshotHandle:            "handle",
+		},
+		"ns/pvc-2": {
+			PVC:        "ns/pvc-2",
+			SkipReason: "PV is not a CSI volume",
+		},
+	}, reports)
+}
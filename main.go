@@ -48,17 +48,33 @@ func newPVCBackupItemAction(logger logrus.FieldLogger) (interface{}, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	resourceCache, err := util.GetResourceCache()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
 	return &backup.PVCBackupItemAction{
 		Log:            logger,
 		Client:         client,
 		SnapshotClient: snapshotClient,
 		VeleroClient:   veleroClient,
+		EventRecorder:  util.GetEventRecorder(logger),
+		Cache:          resourceCache,
 	}, nil
 }
 
 func newVolumeSnapshotBackupItemAction(logger logrus.FieldLogger) (interface{}, error) {
-	return &backup.VolumeSnapshotBackupItemAction{Log: logger}, nil
+	client, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &backup.VolumeSnapshotBackupItemAction{
+		Log:            logger,
+		Client:         client,
+		SnapshotClient: snapshotClient,
+		EventRecorder:  util.GetEventRecorder(logger),
+	}, nil
 }
 
 func newVolumesnapshotClassBackupItemAction(logger logrus.FieldLogger) (interface{}, error) {
@@ -66,7 +82,15 @@ func newVolumesnapshotClassBackupItemAction(logger logrus.FieldLogger) (interfac
 }
 
 func newVolumeSnapContentBackupItemAction(logger logrus.FieldLogger) (interface{}, error) {
-	return &backup.VolumeSnapshotContentBackupItemAction{Log: logger}, nil
+	_, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &backup.VolumeSnapshotContentBackupItemAction{
+		Log:            logger,
+		SnapshotClient: snapshotClient,
+	}, nil
 }
 
 func newPVCRestoreItemAction(logger logrus.FieldLogger) (interface{}, error) {
@@ -75,11 +99,18 @@ func newPVCRestoreItemAction(logger logrus.FieldLogger) (interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	resourceCache, err := util.GetResourceCache()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	return &restore.PVCRestoreItemAction{
 		Log:            logger,
 		Client:         client,
 		SnapshotClient: snapshotClient,
 		VeleroClient:   veleroClient,
+		EventRecorder:  util.GetEventRecorder(logger),
+		Cache:          resourceCache,
 	}, nil
 }
 
@@ -88,7 +119,17 @@ func newVolumeSnapshotContentRestoreItemAction(logger logrus.FieldLogger) (inter
 }
 
 func newVolumeSnapshotRestoreItemAction(logger logrus.FieldLogger) (interface{}, error) {
-	return &restore.VolumeSnapshotRestoreItemAction{Log: logger}, nil
+	client, snapshotClient, veleroClient, err := util.GetFullClients()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &restore.VolumeSnapshotRestoreItemAction{
+		Log:            logger,
+		Client:         client,
+		SnapshotClient: snapshotClient,
+		VeleroClient:   veleroClient,
+	}, nil
 }
 
 func newVolumeSnapshotClassRestoreItemAction(logger logrus.FieldLogger) (interface{}, error) {
@@ -96,9 +137,27 @@ func newVolumeSnapshotClassRestoreItemAction(logger logrus.FieldLogger) (interfa
 }
 
 func newVolumeSnapshotDeleteItemAction(logger logrus.FieldLogger) (interface{}, error) {
-	return &delete.VolumeSnapshotDeleteItemAction{Log: logger}, nil
+	client, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &delete.VolumeSnapshotDeleteItemAction{
+		Log:            logger,
+		Client:         client,
+		SnapshotClient: snapshotClient,
+	}, nil
 }
 
 func newVolumeSnapshotContentDeleteItemAction(logger logrus.FieldLogger) (interface{}, error) {
-	return &delete.VolumeSnapshotContentDeleteItemAction{Log: logger}, nil
+	client, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &delete.VolumeSnapshotContentDeleteItemAction{
+		Log:            logger,
+		Client:         client,
+		SnapshotClient: snapshotClient,
+	}, nil
 }
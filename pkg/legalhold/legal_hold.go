@@ -0,0 +1,39 @@
+/*
+Copyright the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package legalhold lets tools outside the plugin see which storage snapshots the plugin keeps under legal hold.
+package legalhold
+
+import (
+	"context"
+
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	veleroClientSet "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
+)
+
+// Label is the label of the hold ConfigMaps and backups, and the annotation of the held VolumeSnapshots and VolumeSnapshotContents.
+const Label = util.LegalHoldLabel
+
+// ListHeldSnapshotHandles returns the sorted handles of all storage snapshots under legal hold: the snapshots of the held
+// backups, the snapshots held by the hold ConfigMaps in the Velero namespace, and the snapshots of the annotated
+// VolumeSnapshots and VolumeSnapshotContents, including those kept by the deletion of a held backup.
+func ListHeldSnapshotHandles(ctx context.Context, veleroNamespace string, kubeClient kubernetes.Interface,
+	snapshotClient snapshotter.SnapshotV1Interface, veleroClient veleroClientSet.Interface) ([]string, error) {
+	return util.ListHeldSnapshotHandles(ctx, veleroNamespace, kubeClient, snapshotClient, veleroClient)
+}